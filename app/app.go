@@ -2,10 +2,12 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/big"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 	"time"
 	"unicode"
@@ -20,18 +22,25 @@ import (
 	"go.uber.org/zap"
 )
 
+// persistTimeout bounds the upstream reads completing the sessions saved on exit.
+const persistTimeout = 30 * time.Second
+
 func Run(
 	ctx context.Context,
-	rpcURL string,
-	forkBlock uint64,
-	chainID *big.Int,
+	chains []entity.Chain,
 	stateTTL time.Duration,
 	cleanupInterval time.Duration,
+	cache *provider.DiskCache,
 	startHook chan<- struct{},
+	storageOpts ...services.StorageOption,
 ) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	if len(chains) == 0 {
+		return errors.New("no chain configured")
+	}
+
 	logger, err := log.NewZapLogger(false)
 	if err != nil {
 		return fmt.Errorf("failed to create logger: %w", err)
@@ -39,40 +48,25 @@ func Run(
 
 	httpserver := server.New(":6969", logger)
 
-	forkConfig := entity.ForkConfig{
-		ChainID:   chainID.Uint64(),
-		ForkBlock: new(big.Int).SetUint64(forkBlock),
-	}
+	storageOpts = append([]services.StorageOption{services.WithLogger(logger)}, storageOpts...)
+	routes := make([]controller.Chain, 0, len(chains))
+	storages := make([]*services.ExecutionCtxStorage, 0, len(chains))
+	names := make(map[string]bool, len(chains))
+	for _, chain := range chains {
+		if names[chain.Name] {
+			return fmt.Errorf("duplicate chain %s", chain.Name)
+		}
+		names[chain.Name] = true
 
-	stateReader, err := provider.NewJsonRPCProvider(rpcURL)
-	if err != nil {
-		return fmt.Errorf("state reader error: %w", err)
+		route, storage, err := newChain(ctx, chain, stateTTL, cleanupInterval, cache, storageOpts)
+		if err != nil {
+			return fmt.Errorf("chain %s: %w", chain.Name, err)
+		}
+		routes = append(routes, route)
+		storages = append(storages, storage)
 	}
 
-	storage := services.NewExecutionStorage(forkConfig, stateReader, stateTTL)
-	go storage.Watcher(ctx, cleanupInterval)
-	ethRpcService := services.NewRpcService(storage, forkConfig, stateReader)
-	smelterRpcService := services.NewSmelterRpc(storage)
-	otterscanRpcService := services.NewOtterscanRpc(ethRpcService, storage)
-	erigonRpcService := services.NewErigonRpc(ethRpcService)
-
-	rpcServer := jsonrpc.NewServer(
-		jsonrpc.WithServerMethodNameFormatter(
-			func(namespace, method string) string {
-				r := []rune(method)
-				r[0] = unicode.ToLower(r[0])
-				return namespace + "_" + string(r)
-			},
-		),
-
-	)
-
-	rpcServer.Register("eth", ethRpcService)
-	rpcServer.Register("smelter", smelterRpcService)
-	rpcServer.Register("ots", otterscanRpcService)
-	rpcServer.Register("erigon", erigonRpcService)
-
-	controller.SetupRouter(httpserver.Router(), rpcServer, logger)
+	controller.SetupRouter(httpserver.Router(), routes, logger)
 
 	httpserver.Start()
 
@@ -87,18 +81,105 @@ func Run(
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 
+	var runErr error
 	select {
 	case <-ctx.Done():
 		logger.Info("context canceled")
 	case s := <-interrupt:
 		logger.Info("signal -> " + s.String())
 	case err = <-httpserver.Notify():
-		return fmt.Errorf("notify -> %w", err)
+		runErr = fmt.Errorf("notify -> %w", err)
+	}
+
+	if runErr == nil {
+		if err := httpserver.Shutdown(); err != nil {
+			logger.Error("app::shutdown", zap.Error(err))
+		}
 	}
 
-	if err := httpserver.Shutdown(); err != nil {
-		logger.Error("app::shutdown", zap.Error(err))
+	// the sessions are saved on every exit, the run context is usually done by now
+	persistCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), persistTimeout)
+	defer cancel()
+	for _, storage := range storages {
+		if err := storage.Persist(persistCtx); err != nil {
+			logger.Error("app::persist", zap.Error(err))
+		}
 	}
 
-	return nil
+	return runErr
+}
+
+// newChain sets up the provider, the session storage and the rpc server of a chain,
+// the provider reads through the cache when one is set.
+func newChain(
+	ctx context.Context,
+	chain entity.Chain,
+	stateTTL time.Duration,
+	cleanupInterval time.Duration,
+	cache *provider.DiskCache,
+	storageOpts []services.StorageOption,
+) (controller.Chain, *services.ExecutionCtxStorage, error) {
+	forkConfig := entity.ForkConfig{
+		ChainID:   chain.ChainID,
+		ForkBlock: new(big.Int).SetUint64(chain.ForkBlock),
+	}
+
+	var stateReader entity.ChainReaderAndCaller
+	if chain.BatchWindow > 0 {
+		rpcProvider, err := provider.NewBatchJSONRPcProvider(chain.RPCURL)
+		if err != nil {
+			return controller.Chain{}, nil, fmt.Errorf("state reader error: %w", err)
+		}
+		stateReader = provider.NewCoalescingProvider(rpcProvider, chain.BatchWindow, chain.MaxBatchSize)
+	} else {
+		rpcProvider, err := provider.NewJsonRPCProvider(chain.RPCURL)
+		if err != nil {
+			return controller.Chain{}, nil, fmt.Errorf("state reader error: %w", err)
+		}
+		stateReader = rpcProvider
+	}
+
+	route := controller.Chain{Chain: chain}
+	if cache != nil {
+		cachedProvider := provider.NewCachedProvider(stateReader, cache, chain.ChainID, chain.CacheConfirmations)
+		stateReader = cachedProvider
+		route.Cache = cachedProvider
+	}
+
+	storageOpts = append(slices.Clip(storageOpts), services.WithChainName(chain.Name))
+	storage := services.NewExecutionStorage(forkConfig, stateReader, stateTTL, storageOpts...)
+	if err := storage.Restore(ctx); err != nil {
+		return controller.Chain{}, nil, err
+	}
+	go storage.Watcher(ctx, cleanupInterval)
+	go storage.Roller(ctx)
+	ethRpcService := services.NewRpcService(storage, forkConfig, stateReader)
+	smelterRpcService := services.NewSmelterRpc(storage)
+	personalRpcService := services.NewPersonalRpc(storage)
+	otterscanRpcService := services.NewOtterscanRpc(ethRpcService, storage, forkConfig, stateReader)
+	erigonRpcService := services.NewErigonRpc(ethRpcService)
+	debugRpcService := services.NewDebugRpc(storage, forkConfig, stateReader)
+	traceRpcService := services.NewTraceRpc(storage, forkConfig, stateReader)
+
+	rpcServer := jsonrpc.NewServer(
+		jsonrpc.WithServerMethodNameFormatter(
+			func(namespace, method string) string {
+				r := []rune(method)
+				r[0] = unicode.ToLower(r[0])
+				return namespace + "_" + string(r)
+			},
+		),
+	)
+
+	rpcServer.Register("eth", ethRpcService)
+	rpcServer.Register("smelter", smelterRpcService)
+	rpcServer.Register("personal", personalRpcService)
+	rpcServer.Register("ots", otterscanRpcService)
+	rpcServer.Register("erigon", erigonRpcService)
+	rpcServer.Register("debug", debugRpcService)
+	rpcServer.Register("trace", traceRpcService)
+
+	route.RPCServer = rpcServer
+	route.Sessions = storage
+	return route, storage, nil
 }
@@ -1,25 +1,48 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
 	"math/big"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/raul0ligma/smelter/app"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/pkg/hdwallet"
+	"github.com/raul0ligma/smelter/provider"
+	"github.com/raul0ligma/smelter/services"
 	"github.com/raul0ligma/smelter/utils"
 	clitool "github.com/urfave/cli/v2"
 )
 
 func main() {
 	var (
-		rpcURL          string
-		forkBlock       uint64
-		stateTTL        time.Duration
-		cleanupInterval time.Duration
-		chainID         *big.Int
+		rpcURL             string
+		forkBlock          uint64
+		extraChains        clitool.StringSlice
+		stateTTL           time.Duration
+		cleanupInterval    time.Duration
+		maxSessions        int
+		rollEvery          uint64
+		stateDir           string
+		resetState         string
+		cacheDir           string
+		cacheSize          int64
+		cacheConfirmations uint64
+		flattenEvery       int
+		batchWindow        time.Duration
+		maxBatchSize       int
+		rollInterval       time.Duration
+		mnemonic           string
+		accounts           int
+		accountBalance     uint64
 	)
 
 	cli := &clitool.App{
@@ -27,10 +50,8 @@ func main() {
 		Usage: "run a local node by passing in --rpcURL and --forkBlock",
 		Flags: []clitool.Flag{
 			&clitool.StringFlag{
-				Required:    true,
 				Name:        "rpcURL",
-				Value:       "http://localhost:8485",
-				Usage:       "rpc url of the chain to fork",
+				Usage:       "rpc url of the default chain to fork",
 				Destination: &rpcURL,
 			},
 			&clitool.Uint64Flag{
@@ -39,10 +60,15 @@ func main() {
 				Usage:       "block number of the chain to create a fork from",
 				Destination: &forkBlock,
 			},
+			&clitool.StringSliceFlag{
+				Name:        "chain",
+				Usage:       "additional chain served under /v1/:chain as name=rpcURL, a fork block is set with name=rpcURL@block",
+				Destination: &extraChains,
+			},
 			&clitool.DurationFlag{
 				Name:        "stateTTL",
 				Value:       time.Minute * 5,
-				Usage:       "TTL for a fork state before it's cleaned",
+				Usage:       "TTL for an unused fork state before it's cleaned, every request resets it",
 				Destination: &stateTTL,
 			},
 			&clitool.DurationFlag{
@@ -51,32 +77,152 @@ func main() {
 				Usage:       "periodic interval to check and clean unused fork states",
 				Destination: &cleanupInterval,
 			},
+			&clitool.IntFlag{
+				Name:        "maxSessions",
+				Value:       0,
+				Usage:       "max number of fork states, the least recently used is evicted when reached, 0 for no limit",
+				Destination: &maxSessions,
+			},
+			&clitool.Uint64Flag{
+				Name:        "rollEvery",
+				Value:       0,
+				Usage:       "move the fork block to the chain head once it's this many blocks ahead, 0 to keep the fork block",
+				Destination: &rollEvery,
+			},
+			&clitool.DurationFlag{
+				Name:        "rollInterval",
+				Value:       time.Minute,
+				Usage:       "periodic interval to check the chain head with --rollEvery",
+				Destination: &rollInterval,
+			},
+			&clitool.StringFlag{
+				Name:        "stateDir",
+				Usage:       "directory the fork states are saved to on shutdown and restored from on start",
+				Destination: &stateDir,
+			},
+			&clitool.StringFlag{
+				Name:        "resetState",
+				Usage:       "state dump whose accounts are loaded into every fork state forked anew by smelter_resetFork or --rollEvery",
+				Destination: &resetState,
+			},
+			&clitool.StringFlag{
+				Name:        "cacheDir",
+				Usage:       "directory upstream responses at the fork block are cached in across restarts, empty to disable",
+				Destination: &cacheDir,
+			},
+			&clitool.Int64Flag{
+				Name:        "cacheSize",
+				Value:       1024,
+				Usage:       "size limit of --cacheDir in MB, the least recently used responses are removed over it, 0 for no limit",
+				Destination: &cacheSize,
+			},
+			&clitool.Uint64Flag{
+				Name:        "cacheConfirmations",
+				Value:       64,
+				Usage:       "blocks a block has to be below the head for its responses to be cached, so reorged state is never cached",
+				Destination: &cacheConfirmations,
+			},
+			&clitool.IntFlag{
+				Name:        "flattenEvery",
+				Value:       64,
+				Usage:       "blocks keep only their state changes, every n-th stacked block keeps a full copy to bound historical reads, 0 to never flatten",
+				Destination: &flattenEvery,
+			},
+			&clitool.DurationFlag{
+				Name:        "batchWindow",
+				Value:       time.Millisecond * 2,
+				Usage:       "single upstream reads made within this window are sent as one batch, 0 to send them one by one",
+				Destination: &batchWindow,
+			},
+			&clitool.IntFlag{
+				Name:        "maxBatchSize",
+				Value:       100,
+				Usage:       "max number of requests in a batch of --batchWindow, 0 for no limit",
+				Destination: &maxBatchSize,
+			},
+			&clitool.StringFlag{
+				Name:        "mnemonic",
+				Value:       "test test test test test test test test test test test junk",
+				Usage:       "mnemonic the dev accounts are derived from",
+				Destination: &mnemonic,
+			},
+			&clitool.IntFlag{
+				Name:        "accounts",
+				Value:       10,
+				Usage:       "number of dev accounts funded in every fork state",
+				Destination: &accounts,
+			},
+			&clitool.Uint64Flag{
+				Name:        "accountBalance",
+				Value:       10000,
+				Usage:       "balance of the dev accounts in ether",
+				Destination: &accountBalance,
+			},
 		},
 		Action: func(cCtx *clitool.Context) error {
-			if rpcURL == "" {
-				return errors.New("invalid rpc url")
+			chains := make([]entity.Chain, 0)
+			if rpcURL != "" {
+				chains = append(chains, entity.Chain{Name: defaultChain, RPCURL: rpcURL, ForkBlock: forkBlock})
+			}
+			for _, value := range extraChains.Value() {
+				chain, err := parseChain(value)
+				if err != nil {
+					return err
+				}
+				chains = append(chains, chain)
 			}
 
-			client, err := ethclient.Dial(rpcURL)
+			if len(chains) == 0 {
+				return errors.New("invalid rpc url, set --rpcURL or --chain")
+			}
+
+			for i := range chains {
+				chains[i].BatchWindow, chains[i].MaxBatchSize = batchWindow, maxBatchSize
+				chains[i].CacheConfirmations = cacheConfirmations
+				if err := resolveChain(cCtx.Context, &chains[i]); err != nil {
+					return fmt.Errorf("chain %s: %w", chains[i].Name, err)
+				}
+			}
+
+			keys, err := hdwallet.DeriveKeys(mnemonic, "", accounts)
 			if err != nil {
 				return err
 			}
 
-			if forkBlock == 0 {
-				forkBlock, err = client.BlockNumber(cCtx.Context)
-				if err != nil {
+			balance := new(big.Int).Mul(new(big.Int).SetUint64(accountBalance), big.NewInt(params.Ether))
+			devAccounts := entity.NewDevAccounts(keys, balance)
+
+			var cache *provider.DiskCache
+			if cacheDir != "" {
+				if cache, err = provider.NewDiskCache(cacheDir, cacheSize*1024*1024); err != nil {
 					return err
 				}
 			}
 
-			chainID, err = client.ChainID(cCtx.Context)
-			if err != nil {
-				return err
+			storageOpts := []services.StorageOption{
+				services.WithDevAccounts(devAccounts),
+				services.WithMaxSessions(maxSessions),
+				services.WithRollingFork(rollEvery, rollInterval),
+				services.WithStateDir(stateDir),
+				services.WithFlattenEvery(flattenEvery),
+			}
+			if resetState != "" {
+				data, err := os.ReadFile(resetState)
+				if err != nil {
+					return fmt.Errorf("read reset state: %w", err)
+				}
+				dump, err := entity.DecodeStateDump(data)
+				if err != nil {
+					return fmt.Errorf("decode reset state: %w", err)
+				}
+				storageOpts = append(storageOpts, services.WithResetState(dump))
 			}
 
 			utils.PrintSmelter()
-			utils.PrintConfig(rpcURL, chainID, forkBlock)
-			return app.Run(cCtx.Context, rpcURL, forkBlock, chainID, stateTTL, cleanupInterval, nil)
+			for _, chain := range chains {
+				utils.PrintConfig(chain.Name, chain.RPCURL, chain.ChainID, chain.ForkBlock)
+			}
+			return app.Run(cCtx.Context, chains, stateTTL, cleanupInterval, cache, nil, storageOpts...)
 		},
 	}
 
@@ -84,3 +230,47 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+const defaultChain = "default"
+
+// parseChain parses name=rpcURL with an optional @block suffix, the suffix is only
+// taken as the fork block when it's a number so urls with credentials still work.
+func parseChain(value string) (entity.Chain, error) {
+	name, rpcURL, ok := strings.Cut(value, "=")
+	if !ok || name == "" || rpcURL == "" {
+		return entity.Chain{}, fmt.Errorf("invalid chain %s, expected name=rpcURL", value)
+	}
+
+	chain := entity.Chain{Name: name, RPCURL: rpcURL}
+	if i := strings.LastIndex(rpcURL, "@"); i != -1 {
+		if block, err := strconv.ParseUint(rpcURL[i+1:], 10, 64); err == nil {
+			chain.RPCURL = rpcURL[:i]
+			chain.ForkBlock = block
+		}
+	}
+
+	return chain, nil
+}
+
+// resolveChain reads the chain id and, without a fork block, the latest block.
+func resolveChain(ctx context.Context, chain *entity.Chain) error {
+	client, err := ethclient.Dial(chain.RPCURL)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if chain.ForkBlock == 0 {
+		if chain.ForkBlock, err = client.BlockNumber(ctx); err != nil {
+			return err
+		}
+	}
+
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return err
+	}
+
+	chain.ChainID = chainID.Uint64()
+	return nil
+}
@@ -0,0 +1,48 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raul0ligma/smelter/entity"
+)
+
+type responseCache interface {
+	CacheStats() entity.CacheStats
+	ClearCache() error
+}
+
+func (h *chainHandler) cache(c echo.Context) (responseCache, error) {
+	chain, err := h.chain(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if chain.Cache == nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "cache disabled")
+	}
+
+	return chain.Cache, nil
+}
+
+func (h *chainHandler) cacheStats(c echo.Context) error {
+	cache, err := h.cache(c)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, cache.CacheStats())
+}
+
+func (h *chainHandler) clearCache(c echo.Context) error {
+	cache, err := h.cache(c)
+	if err != nil {
+		return err
+	}
+
+	if err = cache.ClearCache(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -1,22 +1,98 @@
 package controller
 
 import (
+	"net/http"
+
 	"github.com/filecoin-project/go-jsonrpc"
 	"github.com/labstack/echo/v4"
+	"github.com/raul0ligma/smelter/entity"
 	"github.com/raul0ligma/smelter/pkg/log"
 	"github.com/raul0ligma/smelter/pkg/server"
 )
 
+// Chain is an upstream along with the rpc server and sessions serving it, Cache
+// is nil when upstream responses aren't cached.
+type Chain struct {
+	entity.Chain
+	RPCServer *jsonrpc.RPCServer
+	Sessions  sessionStorage
+	Cache     responseCache
+}
+
+// SetupRouter serves every chain under /v1/:chain, the first chain is the default
+// one which is also served without the chain segment.
 func SetupRouter(
 	router server.Router,
-	rpcServer *jsonrpc.RPCServer,
+	chains []Chain,
 	logger log.Logger,
 ) {
+	handler := &chainHandler{
+		chains:  make(map[string]*Chain, len(chains)),
+		listing: make([]entity.Chain, 0, len(chains)),
+	}
+	for i := range chains {
+		handler.chains[chains[i].Name] = &chains[i]
+		handler.listing = append(handler.listing, chains[i].Chain)
+	}
+	if len(chains) > 0 {
+		handler.fallback = &chains[0]
+	}
+
+	router.GET("/v1/chains", handler.listChains)
+	for _, prefix := range []string{"/v1", "/v1/:chain"} {
+		router.POST(
+			prefix+"/rpc/:key", handler.rpc,
+			server.SetExecutionContextMw,
+			server.SetCallerContextMw,
+			server.SetResponseHeaderMw,
+		)
+
+		router.GET(prefix+"/metrics", handler.metrics)
+		router.GET(prefix+"/sessions", handler.list)
+		router.GET(prefix+"/sessions/:key", handler.info)
+		router.POST(prefix+"/sessions/:key/reset", handler.reset)
+		router.POST(prefix+"/sessions/:key/clone/:target", handler.clone)
+		router.DELETE(prefix+"/sessions/:key", handler.delete)
+		router.GET(prefix+"/cache", handler.cacheStats)
+		router.DELETE(prefix+"/cache", handler.clearCache)
+	}
+}
+
+type chainHandler struct {
+	chains   map[string]*Chain
+	listing  []entity.Chain
+	fallback *Chain
+}
+
+// chain resolves the chain of the request, routes without the chain segment use
+// the default chain.
+func (h *chainHandler) chain(c echo.Context) (*Chain, error) {
+	name := c.Param("chain")
+	if name == "" {
+		if h.fallback == nil {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "no chain configured")
+		}
+		return h.fallback, nil
+	}
+
+	chain, ok := h.chains[name]
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "unknown chain "+name)
+	}
+
+	return chain, nil
+}
+
+func (h *chainHandler) listChains(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.listing)
+}
+
+func (h *chainHandler) rpc(c echo.Context) error {
+	chain, err := h.chain(c)
+	if err != nil {
+		return err
+	}
 
-	router.POST(
-		"/v1/rpc/:key", echo.WrapHandler(rpcServer),
-		server.SetExecutionContextMw,
-		server.SetCallerContextMw,
-		server.SetResponseHeaderMw,
-	)
+	chain.RPCServer.ServeHTTP(c.Response(), c.Request())
+	return nil
 }
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/services"
+)
+
+type sessionStorage interface {
+	Metrics() entity.SessionMetrics
+	List() []entity.SessionInfo
+	Info(key string) (entity.SessionInfo, error)
+	Reset(ctx context.Context, key string) error
+	Delete(key string) error
+	Clone(src, dst string) error
+}
+
+func (h *chainHandler) sessions(c echo.Context) (sessionStorage, error) {
+	chain, err := h.chain(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return chain.Sessions, nil
+}
+
+func (h *chainHandler) metrics(c echo.Context) error {
+	sessions, err := h.sessions(c)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, sessions.Metrics())
+}
+
+func (h *chainHandler) list(c echo.Context) error {
+	sessions, err := h.sessions(c)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, sessions.List())
+}
+
+func (h *chainHandler) info(c echo.Context) error {
+	sessions, err := h.sessions(c)
+	if err != nil {
+		return err
+	}
+
+	info, err := sessions.Info(c.Param("key"))
+	if err != nil {
+		return sessionError(err)
+	}
+
+	return c.JSON(http.StatusOK, info)
+}
+
+func (h *chainHandler) reset(c echo.Context) error {
+	sessions, err := h.sessions(c)
+	if err != nil {
+		return err
+	}
+
+	if err = sessions.Reset(c.Request().Context(), c.Param("key")); err != nil {
+		return sessionError(err)
+	}
+
+	return h.info(c)
+}
+
+func (h *chainHandler) delete(c echo.Context) error {
+	sessions, err := h.sessions(c)
+	if err != nil {
+		return err
+	}
+
+	if err = sessions.Delete(c.Param("key")); err != nil {
+		return sessionError(err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *chainHandler) clone(c echo.Context) error {
+	sessions, err := h.sessions(c)
+	if err != nil {
+		return err
+	}
+
+	dst := c.Param("target")
+	if err = sessions.Clone(c.Param("key"), dst); err != nil {
+		return sessionError(err)
+	}
+
+	info, err := sessions.Info(dst)
+	if err != nil {
+		return sessionError(err)
+	}
+
+	return c.JSON(http.StatusCreated, info)
+}
+
+func sessionError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrSessionNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrSessionExists):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+}
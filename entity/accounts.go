@@ -0,0 +1,75 @@
+package entity
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var ErrUnknownAccount = errors.New("unknown account")
+
+// DevAccounts are node managed accounts which are funded with Balance in every
+// session and sign on behalf of their users.
+type DevAccounts struct {
+	addrs   []common.Address
+	keys    map[common.Address]*ecdsa.PrivateKey
+	Balance *big.Int
+}
+
+func NewDevAccounts(keys []*ecdsa.PrivateKey, balance *big.Int) *DevAccounts {
+	d := &DevAccounts{
+		addrs:   make([]common.Address, 0, len(keys)),
+		keys:    make(map[common.Address]*ecdsa.PrivateKey, len(keys)),
+		Balance: balance,
+	}
+
+	for _, key := range keys {
+		addr := crypto.PubkeyToAddress(key.PublicKey)
+		d.addrs = append(d.addrs, addr)
+		d.keys[addr] = key
+	}
+
+	return d
+}
+
+// Addresses returns the accounts in derivation order, a nil set has no accounts.
+func (d *DevAccounts) Addresses() []common.Address {
+	if d == nil {
+		return []common.Address{}
+	}
+
+	return d.addrs
+}
+
+func (d *DevAccounts) Has(addr common.Address) bool {
+	if d == nil {
+		return false
+	}
+
+	_, ok := d.keys[addr]
+	return ok
+}
+
+// SignHash signs a 32 byte hash, V of the signature is 27 or 28.
+func (d *DevAccounts) SignHash(addr common.Address, hash []byte) ([]byte, error) {
+	if !d.Has(addr) {
+		return nil, ErrUnknownAccount
+	}
+
+	sig, err := crypto.Sign(hash, d.keys[addr])
+	if err != nil {
+		return nil, err
+	}
+
+	sig[crypto.RecoveryIDOffset] += 27
+	return sig, nil
+}
+
+// SignText signs data with the EIP-191 personal message prefix.
+func (d *DevAccounts) SignText(addr common.Address, data []byte) ([]byte, error) {
+	return d.SignHash(addr, accounts.TextHash(data))
+}
@@ -0,0 +1,260 @@
+package entity
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var (
+	// keccak256("Transfer(address,address,uint256)")
+	TransferTopic = common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+	// keccak256("Approval(address,address,uint256)")
+	ApprovalTopic = common.HexToHash("0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925")
+	// keccak256("ApprovalForAll(address,address,bool)")
+	ApprovalForAllTopic = common.HexToHash("0x17307eab39ab6107e8899845ad3d59bd9653f200f220920489ca2b5937696c31")
+	// keccak256("TransferSingle(address,address,address,uint256,uint256)")
+	TransferSingleTopic = common.HexToHash("0xc3d58168c5ae7397731d063d5bbf3d657854427343f4c083240f7aacaa2d0f62")
+	// keccak256("TransferBatch(address,address,address,uint256[],uint256[])")
+	TransferBatchTopic = common.HexToHash("0x4a39dc06d4c0dbc64b70af90fd698a233a518aa5d07e595d983b8c0526c8f7fb")
+	// NativeAssetAddress is the ERC-7528 pseudo address of the native token.
+	NativeAssetAddress = common.HexToAddress("0xEeeeeEeeeEeEeeEeEeEeeEEEeeeeEeeeeeeeEEeE")
+)
+
+type AssetType string
+
+const (
+	AssetTypeNative  AssetType = "NATIVE"
+	AssetTypeERC20   AssetType = "ERC20"
+	AssetTypeERC721  AssetType = "ERC721"
+	AssetTypeERC1155 AssetType = "ERC1155"
+)
+
+// AssetChange is a transfer of the native token or of a token, the token id is
+// only set for ERC721 and ERC1155 transfers.
+type AssetChange struct {
+	Type    AssetType      `json:"type"`
+	Token   common.Address `json:"token"`
+	From    common.Address `json:"from"`
+	To      common.Address `json:"to"`
+	TokenID *hexutil.Big   `json:"tokenId,omitempty"`
+	Amount  *hexutil.Big   `json:"amount"`
+	LogIdx  hexutil.Uint   `json:"logIndex"`
+}
+
+// ApprovalChange is an allowance or operator approval, Approved is only set for
+// ApprovalForAll which ERC1155 shares with ERC721 and is reported as the latter.
+type ApprovalChange struct {
+	Type     AssetType      `json:"type"`
+	Token    common.Address `json:"token"`
+	Owner    common.Address `json:"owner"`
+	Spender  common.Address `json:"spender"`
+	TokenID  *hexutil.Big   `json:"tokenId,omitempty"`
+	Amount   *hexutil.Big   `json:"amount,omitempty"`
+	Approved *bool          `json:"approved,omitempty"`
+}
+
+// BalanceChange is the native balance of an account before and after execution.
+type BalanceChange struct {
+	Address common.Address `json:"address"`
+	From    *hexutil.Big   `json:"from"`
+	To      *hexutil.Big   `json:"to"`
+	Diff    *hexutil.Big   `json:"diff"`
+}
+
+// SimulationDiff is the outcome of a simulated transaction along with the assets
+// it moved and the raw state it changed.
+type SimulationDiff struct {
+	Success        bool             `json:"success"`
+	Error          string           `json:"error,omitempty"`
+	Revert         string           `json:"revert,omitempty"`
+	ReturnData     hexutil.Bytes    `json:"returnData"`
+	GasUsed        hexutil.Uint64   `json:"gasUsed"`
+	Logs           []*types.Log     `json:"logs"`
+	BalanceChanges []BalanceChange  `json:"balanceChanges"`
+	AssetChanges   []AssetChange    `json:"assetChanges"`
+	Approvals      []ApprovalChange `json:"approvals"`
+	StateDiff      StateDiff        `json:"stateDiff"`
+}
+
+// DecodeAssetChanges decodes the standard Transfer and Approval events of the
+// logs, ERC20 and ERC721 events share their signature and are told apart by the
+// indexed token id. Logs of the native pseudo address are native transfers.
+func DecodeAssetChanges(logs []*types.Log) ([]AssetChange, []ApprovalChange) {
+	transfers := make([]AssetChange, 0)
+	approvals := make([]ApprovalChange, 0)
+	for _, log := range logs {
+		if len(log.Topics) == 0 {
+			continue
+		}
+
+		switch log.Topics[0] {
+		case TransferTopic:
+			if change, ok := decodeTransfer(log); ok {
+				transfers = append(transfers, change)
+			}
+		case TransferSingleTopic:
+			if len(log.Topics) != 4 || len(log.Data) != 64 {
+				continue
+			}
+			transfers = append(transfers, AssetChange{
+				Type:    AssetTypeERC1155,
+				Token:   log.Address,
+				From:    common.BytesToAddress(log.Topics[2].Bytes()),
+				To:      common.BytesToAddress(log.Topics[3].Bytes()),
+				TokenID: wordAt(log.Data, 0),
+				Amount:  wordAt(log.Data, 1),
+				LogIdx:  hexutil.Uint(log.Index),
+			})
+		case TransferBatchTopic:
+			if len(log.Topics) != 4 {
+				continue
+			}
+			ids, values, ok := decodeBatch(log.Data)
+			if !ok {
+				continue
+			}
+			for i := range ids {
+				transfers = append(transfers, AssetChange{
+					Type:    AssetTypeERC1155,
+					Token:   log.Address,
+					From:    common.BytesToAddress(log.Topics[2].Bytes()),
+					To:      common.BytesToAddress(log.Topics[3].Bytes()),
+					TokenID: ids[i],
+					Amount:  values[i],
+					LogIdx:  hexutil.Uint(log.Index),
+				})
+			}
+		case ApprovalTopic:
+			if change, ok := decodeApproval(log); ok {
+				approvals = append(approvals, change)
+			}
+		case ApprovalForAllTopic:
+			if len(log.Topics) != 3 || len(log.Data) != 32 {
+				continue
+			}
+			approved := new(big.Int).SetBytes(log.Data).Sign() != 0
+			approvals = append(approvals, ApprovalChange{
+				Type:     AssetTypeERC721,
+				Token:    log.Address,
+				Owner:    common.BytesToAddress(log.Topics[1].Bytes()),
+				Spender:  common.BytesToAddress(log.Topics[2].Bytes()),
+				Approved: &approved,
+			})
+		}
+	}
+
+	return transfers, approvals
+}
+
+func decodeTransfer(log *types.Log) (AssetChange, bool) {
+	change := AssetChange{
+		Token:  log.Address,
+		LogIdx: hexutil.Uint(log.Index),
+	}
+	switch {
+	case len(log.Topics) == 3 && len(log.Data) == 32:
+		change.Type = AssetTypeERC20
+		if log.Address == NativeAssetAddress {
+			change.Type = AssetTypeNative
+		}
+		change.Amount = wordAt(log.Data, 0)
+	case len(log.Topics) == 4 && len(log.Data) == 0:
+		change.Type = AssetTypeERC721
+		change.TokenID = (*hexutil.Big)(log.Topics[3].Big())
+		change.Amount = (*hexutil.Big)(big.NewInt(1))
+	default:
+		return AssetChange{}, false
+	}
+
+	change.From = common.BytesToAddress(log.Topics[1].Bytes())
+	change.To = common.BytesToAddress(log.Topics[2].Bytes())
+	return change, true
+}
+
+func decodeApproval(log *types.Log) (ApprovalChange, bool) {
+	change := ApprovalChange{Token: log.Address}
+	switch {
+	case len(log.Topics) == 3 && len(log.Data) == 32:
+		change.Type = AssetTypeERC20
+		change.Amount = wordAt(log.Data, 0)
+	case len(log.Topics) == 4 && len(log.Data) == 0:
+		change.Type = AssetTypeERC721
+		change.TokenID = (*hexutil.Big)(log.Topics[3].Big())
+	default:
+		return ApprovalChange{}, false
+	}
+
+	change.Owner = common.BytesToAddress(log.Topics[1].Bytes())
+	change.Spender = common.BytesToAddress(log.Topics[2].Bytes())
+	return change, true
+}
+
+// decodeBatch unpacks the ids and values arrays of a TransferBatch event.
+func decodeBatch(data []byte) ([]*hexutil.Big, []*hexutil.Big, bool) {
+	if len(data) < 64 {
+		return nil, nil, false
+	}
+
+	ids, ok := wordArray(data, wordAt(data, 0).ToInt())
+	if !ok {
+		return nil, nil, false
+	}
+
+	values, ok := wordArray(data, wordAt(data, 1).ToInt())
+	if !ok || len(ids) != len(values) {
+		return nil, nil, false
+	}
+
+	return ids, values, true
+}
+
+func wordArray(data []byte, offset *big.Int) ([]*hexutil.Big, bool) {
+	if !offset.IsUint64() || offset.Uint64()%32 != 0 || offset.Uint64()+32 > uint64(len(data)) {
+		return nil, false
+	}
+
+	start := offset.Uint64() / 32
+	length := wordAt(data, start).ToInt()
+	if !length.IsUint64() || length.Uint64() > uint64(len(data))/32-start-1 {
+		return nil, false
+	}
+
+	words := make([]*hexutil.Big, 0, length.Uint64())
+	for i := uint64(0); i < length.Uint64(); i++ {
+		words = append(words, wordAt(data, start+1+i))
+	}
+
+	return words, true
+}
+
+func wordAt(data []byte, index uint64) *hexutil.Big {
+	return (*hexutil.Big)(new(big.Int).SetBytes(data[index*32 : (index+1)*32]))
+}
+
+// BalanceChanges returns the native balance changes of the diff ordered by address.
+func (d StateDiff) BalanceChanges() []BalanceChange {
+	changes := make([]BalanceChange, 0, len(d))
+	for addr, account := range d {
+		from, ok := account.Balance.From.(*hexutil.Big)
+		if !ok {
+			continue
+		}
+		to, _ := account.Balance.To.(*hexutil.Big)
+		changes = append(changes, BalanceChange{
+			Address: addr,
+			From:    from,
+			To:      to,
+			Diff:    (*hexutil.Big)(new(big.Int).Sub(to.ToInt(), from.ToInt())),
+		})
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return bytes.Compare(changes[i].Address.Bytes(), changes[j].Address.Bytes()) < 0
+	})
+	return changes
+}
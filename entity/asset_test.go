@@ -0,0 +1,70 @@
+package entity
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeAssetChanges(t *testing.T) {
+	token := common.HexToAddress("0x69")
+	from := common.HexToAddress("0x6")
+	to := common.HexToAddress("0x7")
+	word := func(v int64) []byte { return common.BigToHash(big.NewInt(v)).Bytes() }
+	topic := func(addr common.Address) common.Hash { return common.BytesToHash(addr.Bytes()) }
+	concat := func(words ...[]byte) []byte {
+		var out []byte
+		for _, w := range words {
+			out = append(out, w...)
+		}
+		return out
+	}
+
+	logs := []*types.Log{
+		// ERC721 transfer of token 9
+		{Address: token, Topics: []common.Hash{TransferTopic, topic(from), topic(to), common.BigToHash(big.NewInt(9))}},
+		// ERC1155 batch of ids 1 and 2
+		{Address: token, Topics: []common.Hash{TransferBatchTopic, topic(from), topic(from), topic(to)}, Data: concat(
+			word(64), word(160), word(2), word(1), word(2), word(2), word(10), word(20),
+		)},
+		// ERC1155 batch with a bogus offset is skipped
+		{Address: token, Topics: []common.Hash{TransferBatchTopic, topic(from), topic(from), topic(to)}, Data: concat(word(1<<40), word(0))},
+		{Address: token, Topics: []common.Hash{ApprovalForAllTopic, topic(from), topic(to)}, Data: word(1)},
+		// unrelated event
+		{Address: token, Topics: []common.Hash{common.HexToHash("0x1")}},
+	}
+
+	transfers, approvals := DecodeAssetChanges(logs)
+	assert.Len(t, transfers, 3)
+	assert.Equal(t, AssetTypeERC721, transfers[0].Type)
+	assert.Equal(t, int64(9), transfers[0].TokenID.ToInt().Int64())
+	assert.Equal(t, AssetChange{
+		Type:    AssetTypeERC1155,
+		Token:   token,
+		From:    from,
+		To:      to,
+		TokenID: (*hexutil.Big)(big.NewInt(2)),
+		Amount:  (*hexutil.Big)(big.NewInt(20)),
+	}, transfers[2])
+
+	approved := true
+	assert.Equal(t, []ApprovalChange{{Type: AssetTypeERC721, Token: token, Owner: from, Spender: to, Approved: &approved}}, approvals)
+}
+
+func TestStateDiffBalanceChanges(t *testing.T) {
+	diff := StateDiff{
+		common.HexToAddress("0x7"): {Balance: Diff{From: (*hexutil.Big)(big.NewInt(1)), To: (*hexutil.Big)(big.NewInt(5))}},
+		common.HexToAddress("0x6"): {Balance: Diff{From: (*hexutil.Big)(big.NewInt(5)), To: (*hexutil.Big)(big.NewInt(1))}},
+		common.HexToAddress("0x8"): {Nonce: Diff{From: hexutil.Uint64(0), To: hexutil.Uint64(1)}},
+	}
+
+	changes := diff.BalanceChanges()
+	assert.Len(t, changes, 2)
+	assert.Equal(t, common.HexToAddress("0x6"), changes[0].Address)
+	assert.Equal(t, int64(-4), changes[0].Diff.ToInt().Int64())
+	assert.Equal(t, int64(4), changes[1].Diff.ToInt().Int64())
+}
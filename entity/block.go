@@ -55,24 +55,44 @@ func NewBlock(
 		GasUsed:    GasUsed,
 	}
 
-	b := types.NewBlock(header, &types.Body{
+	return NewBlockWithHeader(header, transactions, receipts)
+}
+
+// NewBlockWithHeader assembles a block from a prepared header, the transaction
+// and receipt roots and the bloom are derived from the body.
+func NewBlockWithHeader(header *types.Header, transactions types.Transactions, receipts types.Receipts) *types.Block {
+	return types.NewBlock(header, &types.Body{
 		Transactions: transactions,
 	}, receipts, newHasher())
-
-	return b
 }
 
+// BlockState is the state after a block, Accounts and State only hold what the
+// block changed and the rest is read from Parent, see NewBlockState.
 type BlockState struct {
 	Accounts *AccountsStorage
 	State    *AccountsState
 	Block    *types.Block
+	Parent   *BlockState
+	depth    int
+}
+
+// BlockOverrides replaces fields of the block environment of an execution, the
+// field names follow geth's eth_call and eth_simulateV1 block overrides.
+type BlockOverrides struct {
+	Number        *hexutil.Big    `json:"number,omitempty"`
+	Time          *hexutil.Uint64 `json:"time,omitempty"`
+	GasLimit      *hexutil.Uint64 `json:"gasLimit,omitempty"`
+	FeeRecipient  *common.Address `json:"feeRecipient,omitempty"`
+	PrevRandao    *common.Hash    `json:"prevRandao,omitempty"`
+	BaseFeePerGas *hexutil.Big    `json:"baseFeePerGas,omitempty"`
 }
 
 type BlockStorage struct {
-	storage  map[common.Hash]*BlockState
-	num2Hash map[uint64]common.Hash
-	latest   uint64
-	mu       sync.Mutex
+	storage      map[common.Hash]*BlockState
+	num2Hash     map[uint64]common.Hash
+	latest       uint64
+	flattenEvery int
+	mu           sync.Mutex
 }
 
 func NewBlockStorage() *BlockStorage {
@@ -128,12 +148,41 @@ func (b *BlockStorage) AddBlock(state *BlockState) {
 	}
 }
 
+// AddBlockChanges stores the block along with the changes it made on top of the
+// state after the previous block.
+func (b *BlockStorage) AddBlockChanges(block *types.Block, accounts *AccountsStorage, state *AccountsState) {
+	b.mu.Lock()
+	parent := b.storage[b.num2Hash[block.NumberU64()-1]]
+	flattenEvery := b.flattenEvery
+	b.mu.Unlock()
+
+	b.AddBlock(NewBlockState(parent, accounts, state, block, flattenEvery))
+}
+
+// SetFlattenEvery sets how many layers are stacked before a block keeps a full
+// copy of the state, 0 never flattens.
+func (b *BlockStorage) SetFlattenEvery(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.flattenEvery = n
+}
+
 func (b *BlockStorage) Latest() uint64 {
 	return b.latest
 }
 
+// Apply adds the blocks of s, the block states are shared as they are only
+// extended by the reads at their block.
 func (b *BlockStorage) Apply(s *BlockStorage) {
+	s.mu.Lock()
+	blocks := make([]*BlockState, 0, len(s.storage))
 	for _, v := range s.storage {
+		blocks = append(blocks, v)
+	}
+	s.mu.Unlock()
+
+	for _, v := range blocks {
 		b.AddBlock(v)
 	}
 }
@@ -0,0 +1,48 @@
+package entity
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// BundleArgs is an ordered bundle of signed transactions, with Commit the bundle
+// is mined as a single block when every transaction succeeds.
+type BundleArgs struct {
+	Txs       []hexutil.Bytes `json:"txs"`
+	Coinbase  *common.Address `json:"coinbase,omitempty"`
+	Timestamp *hexutil.Uint64 `json:"timestamp,omitempty"`
+	Commit    bool            `json:"commit,omitempty"`
+}
+
+// BundleTxResult is the outcome of a bundle transaction, the coinbase diff holds
+// the gas fees paid to the coinbase along with any direct payment.
+type BundleTxResult struct {
+	TxHash            common.Hash     `json:"txHash"`
+	FromAddress       common.Address  `json:"fromAddress"`
+	ToAddress         *common.Address `json:"toAddress"`
+	GasUsed           hexutil.Uint64  `json:"gasUsed"`
+	GasPrice          *hexutil.Big    `json:"gasPrice"`
+	GasFees           *hexutil.Big    `json:"gasFees"`
+	CoinbaseDiff      *hexutil.Big    `json:"coinbaseDiff"`
+	EthSentToCoinbase *hexutil.Big    `json:"ethSentToCoinbase"`
+	Success           bool            `json:"success"`
+	Error             string          `json:"error,omitempty"`
+	Revert            string          `json:"revert,omitempty"`
+	ReturnData        hexutil.Bytes   `json:"returnData"`
+	Logs              []*types.Log    `json:"logs"`
+	StateDiff         StateDiff       `json:"stateDiff"`
+}
+
+type BundleResult struct {
+	BundleHash        common.Hash       `json:"bundleHash"`
+	Results           []*BundleTxResult `json:"results"`
+	TotalGasUsed      hexutil.Uint64    `json:"totalGasUsed"`
+	GasFees           *hexutil.Big      `json:"gasFees"`
+	CoinbaseDiff      *hexutil.Big      `json:"coinbaseDiff"`
+	EthSentToCoinbase *hexutil.Big      `json:"ethSentToCoinbase"`
+	StateBlockNumber  hexutil.Uint64    `json:"stateBlockNumber"`
+	Committed         bool              `json:"committed"`
+	BlockHash         *common.Hash      `json:"blockHash,omitempty"`
+	BlockNumber       *hexutil.Uint64   `json:"blockNumber,omitempty"`
+}
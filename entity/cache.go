@@ -0,0 +1,13 @@
+package entity
+
+// CacheStats are the counters of the upstream response cache for a chain, Bytes
+// and Entries cover the chain while MaxBytes is the limit shared by every chain.
+type CacheStats struct {
+	ChainID  uint64 `json:"chainId"`
+	Entries  int    `json:"entries"`
+	Bytes    int64  `json:"bytes"`
+	MaxBytes int64  `json:"maxBytes"`
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+	Evicted  uint64 `json:"evicted"`
+}
@@ -0,0 +1,286 @@
+package entity
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Word is a storage key or value, it's decoded from hex of any length up to 32
+// bytes as anvil writes them without the leading zeros.
+type Word common.Hash
+
+func (w Word) MarshalText() ([]byte, error) {
+	return common.Hash(w).MarshalText()
+}
+
+func (w *Word) UnmarshalText(input []byte) error {
+	raw := strings.TrimPrefix(strings.TrimPrefix(string(input), "0x"), "0X")
+	if raw == "" {
+		*w = Word{}
+		return nil
+	}
+
+	value, ok := new(big.Int).SetString(raw, 16)
+	if !ok || value.Sign() < 0 || value.BitLen() > 256 {
+		return fmt.Errorf("invalid storage word %s", input)
+	}
+
+	*w = Word(common.BigToHash(value))
+	return nil
+}
+
+// AccountDump is an account in the anvil state format. Session dumps always hold
+// the balance and code, they're only nil in the changes of a block and in partial
+// dumps where the missing parts keep the values of the session on load.
+type AccountDump struct {
+	Nonce   uint64         `json:"nonce"`
+	Balance *hexutil.Big   `json:"balance"`
+	Code    *hexutil.Bytes `json:"code"`
+	Storage map[Word]Word  `json:"storage"`
+}
+
+type AccountsDump map[common.Address]*AccountDump
+
+// TraceDump keeps the reverted flag which is left out of the traces json.
+type TraceDump struct {
+	TransactionTrace
+	Reverted bool `json:"reverted,omitempty"`
+}
+
+// TransactionDump is a local transaction along with its receipt, sender and traces.
+// Nonce is the nonce the transaction is indexed by, without it the nonce of the
+// transaction is used.
+type TransactionDump struct {
+	Transaction hexutil.Bytes   `json:"transaction"`
+	Sender      common.Address  `json:"sender"`
+	Nonce       *hexutil.Uint64 `json:"nonce,omitempty"`
+	Receipt     *types.Receipt  `json:"receipt"`
+	Traces      []TraceDump     `json:"traces"`
+}
+
+// BlockDump is a local block in rlp along with the state it changed.
+type BlockDump struct {
+	Block    hexutil.Bytes `json:"block"`
+	Accounts AccountsDump  `json:"accounts"`
+}
+
+// ChainDump is the local chain of a session.
+type ChainDump struct {
+	Blocks       []BlockDump       `json:"blocks"`
+	Transactions []TransactionDump `json:"transactions"`
+}
+
+// StateDump is the state of a session, the accounts and the best block follow the
+// anvil state format so anvil dumps can be loaded and the other way round. The
+// local chain is smelter specific and anvil ignores it.
+type StateDump struct {
+	Accounts        AccountsDump   `json:"accounts"`
+	BestBlockNumber hexutil.Uint64 `json:"best_block_number"`
+	Fork            *ForkConfig    `json:"fork,omitempty"`
+	Smelter         *ChainDump     `json:"smelter,omitempty"`
+}
+
+// DecodeStateDump decodes a dump given either as json or, like anvil_dumpState
+// returns it, as a hex string of the gzipped json.
+func DecodeStateDump(data []byte) (*StateDump, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var encoded hexutil.Bytes
+		if err := json.Unmarshal(data, &encoded); err != nil {
+			return nil, fmt.Errorf("invalid state: %w", err)
+		}
+		data = encoded
+	}
+
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid state: %w", err)
+		}
+		if data, err = io.ReadAll(reader); err != nil {
+			return nil, fmt.Errorf("invalid state: %w", err)
+		}
+	}
+
+	dump := &StateDump{}
+	if err := json.Unmarshal(data, dump); err != nil {
+		return nil, fmt.Errorf("invalid state: %w", err)
+	}
+
+	return dump, nil
+}
+
+// DumpAccounts merges the cached code and storage with the cached balances and nonces.
+func DumpAccounts(storage *AccountsStorage, state *AccountsState) AccountsDump {
+	dump := make(AccountsDump)
+	account := func(addr common.Address) *AccountDump {
+		if _, ok := dump[addr]; !ok {
+			dump[addr] = &AccountDump{Storage: make(map[Word]Word)}
+		}
+		return dump[addr]
+	}
+
+	storage.mu.RLock()
+	for addr, v := range storage.data {
+		if !v.Initialized {
+			continue
+		}
+
+		acc := account(addr)
+		code := hexutil.Bytes(common.CopyBytes(v.Code))
+		acc.Code = &code
+		for k, slot := range v.Slots {
+			acc.Storage[Word(k)] = Word(slot)
+		}
+	}
+	storage.mu.RUnlock()
+
+	state.mu.RLock()
+	for addr, v := range state.data {
+		if !v.Initialized {
+			continue
+		}
+
+		acc := account(addr)
+		acc.Nonce = v.Nonce
+		acc.Balance = (*hexutil.Big)(new(big.Int).Set(v.Balance))
+	}
+	state.mu.RUnlock()
+
+	return dump
+}
+
+// Load splits the accounts back into the code and storage and the balances and nonces.
+func (d AccountsDump) Load() (*AccountsStorage, *AccountsState) {
+	storage, state := NewAccountsStorage(), NewAccountsState()
+	for addr, acc := range d {
+		if acc.Code != nil {
+			slots := make(map[common.Hash]common.Hash, len(acc.Storage))
+			for k, v := range acc.Storage {
+				slots[common.Hash(k)] = common.Hash(v)
+			}
+			storage.NewAccountWithStorage(addr, common.CopyBytes(*acc.Code), slots)
+		}
+
+		if acc.Balance != nil {
+			state.NewAccount(addr, acc.Nonce, new(big.Int).Set(acc.Balance.ToInt()))
+		}
+	}
+
+	return storage, state
+}
+
+// Dump returns the blocks ordered by number.
+func (b *BlockStorage) Dump() ([]BlockDump, error) {
+	b.mu.Lock()
+	blocks := make([]*BlockState, 0, len(b.storage))
+	for _, v := range b.storage {
+		blocks = append(blocks, v)
+	}
+	b.mu.Unlock()
+
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Block.NumberU64() < blocks[j].Block.NumberU64()
+	})
+
+	dump := make([]BlockDump, 0, len(blocks))
+	for _, v := range blocks {
+		encoded, err := rlp.EncodeToBytes(v.Block)
+		if err != nil {
+			return nil, fmt.Errorf("encode block %d: %w", v.Block.NumberU64(), err)
+		}
+
+		dump = append(dump, BlockDump{Block: encoded, Accounts: DumpAccounts(v.Accounts, v.State)})
+	}
+
+	return dump, nil
+}
+
+// Load decodes the block along with its state.
+func (d BlockDump) Load() (*BlockState, error) {
+	block := new(types.Block)
+	if err := rlp.DecodeBytes(d.Block, block); err != nil {
+		return nil, fmt.Errorf("decode block: %w", err)
+	}
+
+	storage, state := d.Accounts.Load()
+	return &BlockState{Accounts: storage, State: state, Block: block}, nil
+}
+
+// Dump returns the transactions which have a receipt, ordered by their position.
+func (ts *TransactionStorage) Dump() ([]TransactionDump, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	indexed := make(map[common.Hash]uint64)
+	for _, nonces := range ts.nonces {
+		for nonce, hash := range nonces {
+			indexed[hash] = nonce
+		}
+	}
+
+	dump := make([]TransactionDump, 0, len(ts.receipts))
+	for hash, receipt := range ts.receipts {
+		tx, ok := ts.txs[hash]
+		if !ok {
+			continue
+		}
+
+		encoded, err := tx.MarshalBinary()
+		if err != nil {
+			return nil, fmt.Errorf("encode transaction %s: %w", hash.Hex(), err)
+		}
+
+		traces := make([]TraceDump, 0, len(ts.traces[hash]))
+		for _, trace := range ts.traces[hash] {
+			traces = append(traces, TraceDump{TransactionTrace: trace, Reverted: trace.Reverted})
+		}
+
+		entry := TransactionDump{
+			Transaction: encoded,
+			Sender:      ts.senders[hash],
+			Receipt:     receipt,
+			Traces:      traces,
+		}
+		if nonce, ok := indexed[hash]; ok {
+			entry.Nonce = (*hexutil.Uint64)(&nonce)
+		}
+		dump = append(dump, entry)
+	}
+
+	sort.Slice(dump, func(i, j int) bool {
+		a, b := dump[i].Receipt, dump[j].Receipt
+		if a.BlockNumber.Cmp(b.BlockNumber) != 0 {
+			return a.BlockNumber.Cmp(b.BlockNumber) < 0
+		}
+		return a.TransactionIndex < b.TransactionIndex
+	})
+	return dump, nil
+}
+
+// Load decodes the transaction and its traces.
+func (d TransactionDump) Load() (*types.Transaction, TransactionTraces, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(d.Transaction); err != nil {
+		return nil, nil, fmt.Errorf("decode transaction: %w", err)
+	}
+
+	traces := make(TransactionTraces, 0, len(d.Traces))
+	for _, trace := range d.Traces {
+		trace.TransactionTrace.Reverted = trace.Reverted
+		traces = append(traces, trace.TransactionTrace)
+	}
+
+	return tx, traces, nil
+}
@@ -2,6 +2,7 @@ package entity
 
 import (
 	"math/big"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 )
@@ -11,6 +12,22 @@ type ForkConfig struct {
 	ForkBlock *big.Int `json:"forkBlock"`
 }
 
+// Chain is an upstream served under /v1/:chain, the rpc url is kept out of the
+// listings as it usually embeds an api key.
+type Chain struct {
+	Name      string `json:"name"`
+	RPCURL    string `json:"-"`
+	ChainID   uint64 `json:"chainId"`
+	ForkBlock uint64 `json:"forkBlock"`
+	// BatchWindow coalesces the single upstream reads made within it into batches
+	// of at most MaxBatchSize requests, 0 sends them one by one.
+	BatchWindow  time.Duration `json:"-"`
+	MaxBatchSize int           `json:"-"`
+	// CacheConfirmations is how far below the head a block has to be for its
+	// upstream responses to be cached.
+	CacheConfirmations uint64 `json:"-"`
+}
+
 type Slot struct {
 	Addr  common.Address
 	Key   common.Hash
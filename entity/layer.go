@@ -0,0 +1,220 @@
+package entity
+
+import (
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Changes records the accounts and slots written to a state since the last block
+// was mined, only those are kept in the state of the block.
+type Changes struct {
+	mu       sync.Mutex
+	accounts map[common.Address]struct{}
+	slots    map[common.Address]map[common.Hash]struct{}
+}
+
+func NewChanges() *Changes {
+	return &Changes{
+		accounts: make(map[common.Address]struct{}),
+		slots:    make(map[common.Address]map[common.Hash]struct{}),
+	}
+}
+
+func (c *Changes) Account(addr common.Address) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.accounts[addr] = struct{}{}
+}
+
+func (c *Changes) Slot(addr common.Address, key common.Hash) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.slot(addr, key)
+}
+
+func (c *Changes) slot(addr common.Address, key common.Hash) {
+	c.accounts[addr] = struct{}{}
+	if _, ok := c.slots[addr]; !ok {
+		c.slots[addr] = make(map[common.Hash]struct{})
+	}
+	c.slots[addr][key] = struct{}{}
+}
+
+// State marks every account of s.
+func (c *Changes) State(s *AccountsState) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for addr := range s.data {
+		c.accounts[addr] = struct{}{}
+	}
+}
+
+// Storage marks every account and slot of s.
+func (c *Changes) Storage(s *AccountsStorage) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for addr, account := range s.data {
+		c.accounts[addr] = struct{}{}
+		for key := range account.Slots {
+			c.slot(addr, key)
+		}
+	}
+}
+
+func (c *Changes) Clone() *Changes {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clone := NewChanges()
+	for addr := range c.accounts {
+		clone.accounts[addr] = struct{}{}
+	}
+	for addr, keys := range c.slots {
+		for key := range keys {
+			clone.slot(addr, key)
+		}
+	}
+
+	return clone
+}
+
+// Take copies the current values of the changed accounts and slots out of storage
+// and state and starts over, the code is shared as it's never modified in place.
+func (c *Changes) Take(storage *AccountsStorage, state *AccountsState) (*AccountsStorage, *AccountsState) {
+	c.mu.Lock()
+	accounts, slots := c.accounts, c.slots
+	c.accounts = make(map[common.Address]struct{})
+	c.slots = make(map[common.Address]map[common.Hash]struct{})
+	c.mu.Unlock()
+
+	diffStorage, diffState := NewAccountsStorage(), NewAccountsState()
+
+	storage.mu.RLock()
+	for addr := range accounts {
+		account, ok := storage.data[addr]
+		if !ok || !account.Initialized {
+			continue
+		}
+
+		changed := make(map[common.Hash]common.Hash, len(slots[addr]))
+		for key := range slots[addr] {
+			if value, ok := account.Slots[key]; ok {
+				changed[key] = value
+			}
+		}
+		diffStorage.data[addr] = &AccountStorage{Code: account.Code, Initialized: true, Slots: changed}
+	}
+	storage.mu.RUnlock()
+
+	state.mu.RLock()
+	for addr := range accounts {
+		account, ok := state.data[addr]
+		if !ok || !account.Initialized {
+			continue
+		}
+
+		diffState.data[addr] = &AccountState{
+			Address:     addr,
+			Balance:     new(big.Int).Set(account.Balance),
+			Nonce:       account.Nonce,
+			Initialized: true,
+		}
+	}
+	state.mu.RUnlock()
+
+	return diffStorage, diffState
+}
+
+// NewBlockState layers the changes made by block on top of the state after its
+// parent, a nil parent is the fork block. Once flattenEvery layers are stacked the
+// block keeps a full copy instead, 0 never flattens.
+func NewBlockState(
+	parent *BlockState,
+	accounts *AccountsStorage,
+	state *AccountsState,
+	block *types.Block,
+	flattenEvery int,
+) *BlockState {
+	b := &BlockState{Accounts: accounts, State: state, Block: block, Parent: parent}
+	if parent != nil {
+		b.depth = parent.depth + 1
+	}
+
+	if flattenEvery > 0 && b.depth >= flattenEvery {
+		b.Accounts, b.State = b.Flatten()
+		b.Parent = nil
+		b.depth = 0
+	}
+
+	return b
+}
+
+// layers returns the block states from b down to the oldest one.
+func (b *BlockState) layers() []*BlockState {
+	layers := make([]*BlockState, 0, b.depth+1)
+	for layer := b; layer != nil; layer = layer.Parent {
+		layers = append(layers, layer)
+	}
+
+	return layers
+}
+
+// Account returns the balance and nonce of the account after the block, false
+// when no block changed the account since the fork.
+func (b *BlockState) Account(addr common.Address) (*AccountState, bool) {
+	for layer := b; layer != nil; layer = layer.Parent {
+		if state := layer.State.State(addr); state != nil {
+			return state, true
+		}
+	}
+
+	return nil, false
+}
+
+// Code returns the code of the account after the block, false when no block
+// changed the account since the fork.
+func (b *BlockState) Code(addr common.Address) ([]byte, bool) {
+	for layer := b; layer != nil; layer = layer.Parent {
+		if code, ok := layer.Accounts.LookupCode(addr); ok {
+			return code, true
+		}
+	}
+
+	return nil, false
+}
+
+// Slot returns the value of the slot after the block, false when no block wrote
+// the slot since the fork.
+func (b *BlockState) Slot(addr common.Address, key common.Hash) (common.Hash, bool) {
+	for layer := b; layer != nil; layer = layer.Parent {
+		if value, ok := layer.Accounts.LookupStorage(addr, key); ok {
+			return value, true
+		}
+	}
+
+	return common.Hash{}, false
+}
+
+// Flatten merges the layers into a full copy of the state after the block, the
+// copy can be written to.
+func (b *BlockState) Flatten() (*AccountsStorage, *AccountsState) {
+	storage, state := NewAccountsStorage(), NewAccountsState()
+	layers := b.layers()
+	for i := len(layers) - 1; i >= 0; i-- {
+		storage.Apply(NewAccountsStorageWitStorage(layers[i].Accounts.Clone()))
+		state.Apply(NewAccountsStateWithStorage(layers[i].State.Clone()))
+	}
+
+	return storage, state
+}
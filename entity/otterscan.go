@@ -1,6 +1,7 @@
 package entity
 
 import (
+	"encoding/json"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -9,9 +10,58 @@ import (
 	"github.com/raul0ligma/smelter/utils"
 )
 
+// TransactionSearchResponse is the result of ots_searchTransactions*, entries are kept
+// raw so pages served by the upstream can be merged with local ones as is.
 type TransactionSearchResponse struct {
-	Txs      []*SerializedTransaction `json:"txs"`
-	Receipts []*SerializedReceipt     `json:"receipts"`
+	Txs       []json.RawMessage `json:"txs"`
+	Receipts  []json.RawMessage `json:"receipts"`
+	FirstPage bool              `json:"firstPage"`
+	LastPage  bool              `json:"lastPage"`
+}
+
+func NewTransactionSearchResponse() *TransactionSearchResponse {
+	return &TransactionSearchResponse{
+		Txs:      make([]json.RawMessage, 0),
+		Receipts: make([]json.RawMessage, 0),
+	}
+}
+
+// Add appends a transaction and its receipt to the response.
+func (r *TransactionSearchResponse) Add(tx *SerializedTransaction, receipt *SerializedReceipt) error {
+	encodedTx, err := json.Marshal(tx)
+	if err != nil {
+		return err
+	}
+
+	encodedReceipt, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+
+	r.Txs = append(r.Txs, encodedTx)
+	r.Receipts = append(r.Receipts, encodedReceipt)
+	return nil
+}
+
+type ContractCreator struct {
+	Hash    common.Hash    `json:"hash"`
+	Creator common.Address `json:"creator"`
+}
+
+type InternalOperationType int
+
+const (
+	OperationTransfer InternalOperationType = iota
+	OperationSelfDestruct
+	OperationCreate
+	OperationCreate2
+)
+
+type InternalOperation struct {
+	Type  InternalOperationType `json:"type"`
+	From  common.Address        `json:"from"`
+	To    common.Address        `json:"to"`
+	Value string                `json:"value"`
 }
 
 type BlockIssuance struct {
@@ -107,6 +157,69 @@ type TransactionTrace struct {
 	Value  string `json:"value"`
 	Input  string `json:"input"`
 	Output string `json:"output"`
+	Gas    string `json:"gas"`
+	// Reverted frames are kept in the trace but didn't change any state.
+	Reverted bool `json:"-"`
 }
 
 type TransactionTraces []TransactionTrace
+
+// InternalOperations returns the value transfers, contract creations and self
+// destructs which happened below the top level call.
+func (t TransactionTraces) InternalOperations() []*InternalOperation {
+	ops := make([]*InternalOperation, 0)
+	for _, trace := range t {
+		if trace.Depth == 0 || trace.Reverted {
+			continue
+		}
+
+		op := &InternalOperation{
+			From:  common.HexToAddress(trace.From),
+			To:    common.HexToAddress(trace.To),
+			Value: trace.Value,
+		}
+
+		switch trace.Type {
+		case "CALL":
+			value, ok := new(big.Int).SetString(trace.Value, 0)
+			if !ok || value.Sign() == 0 {
+				continue
+			}
+			op.Type = OperationTransfer
+		case "SELFDESTRUCT":
+			op.Type = OperationSelfDestruct
+		case "CREATE":
+			op.Type = OperationCreate
+		case "CREATE2":
+			op.Type = OperationCreate2
+		default:
+			continue
+		}
+
+		ops = append(ops, op)
+	}
+
+	return ops
+}
+
+// Participants returns every address which took part in a call frame of the trace.
+func (t TransactionTraces) Participants() []common.Address {
+	seen := make(map[common.Address]struct{})
+	addrs := make([]common.Address, 0)
+	for _, trace := range t {
+		for _, addr := range []string{trace.From, trace.To} {
+			if !common.IsHexAddress(addr) {
+				continue
+			}
+
+			a := common.HexToAddress(addr)
+			if _, ok := seen[a]; ok {
+				continue
+			}
+			seen[a] = struct{}{}
+			addrs = append(addrs, a)
+		}
+	}
+
+	return addrs
+}
@@ -0,0 +1,153 @@
+package entity
+
+import (
+	"encoding/json"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+type TraceType string
+
+const (
+	TraceTypeTrace     TraceType = "trace"
+	TraceTypeStateDiff TraceType = "stateDiff"
+	TraceTypeVmTrace   TraceType = "vmTrace"
+)
+
+// TraceResults is the parity style response of trace_call and trace_replay*,
+// outputs which were not requested are rendered as null.
+type TraceResults struct {
+	Output          hexutil.Bytes   `json:"output"`
+	StateDiff       StateDiff       `json:"stateDiff"`
+	Trace           json.RawMessage `json:"trace"`
+	VmTrace         *VmTrace        `json:"vmTrace"`
+	TransactionHash *common.Hash    `json:"transactionHash,omitempty"`
+}
+
+// FlatTrace is a single call frame of a parity trace, the action and result are
+// kept as received from the tracer and only the call participants are decoded.
+type FlatTrace struct {
+	Action              json.RawMessage `json:"action"`
+	BlockHash           *common.Hash    `json:"blockHash"`
+	BlockNumber         uint64          `json:"blockNumber"`
+	Error               string          `json:"error,omitempty"`
+	Result              json.RawMessage `json:"result,omitempty"`
+	Subtraces           int             `json:"subtraces"`
+	TraceAddress        []int           `json:"traceAddress"`
+	TransactionHash     *common.Hash    `json:"transactionHash"`
+	TransactionPosition uint64          `json:"transactionPosition"`
+	Type                string          `json:"type"`
+}
+
+type FlatTraceParticipants struct {
+	From    *common.Address `json:"from"`
+	To      *common.Address `json:"to"`
+	Address *common.Address `json:"address"`
+}
+
+func (f *FlatTrace) Participants() FlatTraceParticipants {
+	var p FlatTraceParticipants
+	_ = json.Unmarshal(f.Action, &p)
+
+	var res FlatTraceParticipants
+	_ = json.Unmarshal(f.Result, &res)
+	if p.To == nil {
+		// contract creations report the created contract in the result
+		p.To = res.Address
+	}
+
+	return p
+}
+
+type TraceFilter struct {
+	FromBlock   *string          `json:"fromBlock,omitempty"`
+	ToBlock     *string          `json:"toBlock,omitempty"`
+	FromAddress []common.Address `json:"fromAddress,omitempty"`
+	ToAddress   []common.Address `json:"toAddress,omitempty"`
+	After       *uint64          `json:"after,omitempty"`
+	Count       *uint64          `json:"count,omitempty"`
+}
+
+// Diff is a parity style change of a single value, it is rendered as "=" when
+// unchanged, {"+": to} when created, {"-": from} when removed and
+// {"*": {"from": from, "to": to}} otherwise.
+type Diff struct {
+	From any
+	To   any
+}
+
+func (d Diff) MarshalJSON() ([]byte, error) {
+	switch {
+	case d.From == nil && d.To == nil:
+		return json.Marshal("=")
+	case d.From == nil:
+		return json.Marshal(map[string]any{"+": d.To})
+	case d.To == nil:
+		return json.Marshal(map[string]any{"-": d.From})
+	default:
+		return json.Marshal(map[string]any{"*": map[string]any{"from": d.From, "to": d.To}})
+	}
+}
+
+type AccountDiff struct {
+	Balance Diff                 `json:"balance"`
+	Nonce   Diff                 `json:"nonce"`
+	Code    Diff                 `json:"code"`
+	Storage map[common.Hash]Diff `json:"storage"`
+}
+
+type StateDiff map[common.Address]*AccountDiff
+
+// PrestateAccount is an account as reported by geth's prestateTracer.
+type PrestateAccount struct {
+	Balance *hexutil.Big                `json:"balance,omitempty"`
+	Nonce   uint64                      `json:"nonce,omitempty"`
+	Code    hexutil.Bytes               `json:"code,omitempty"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty"`
+}
+
+func (p *PrestateAccount) BalanceOrZero() *big.Int {
+	if p == nil || p.Balance == nil {
+		return new(big.Int)
+	}
+
+	return p.Balance.ToInt()
+}
+
+// PrestateDiff is the output of geth's prestateTracer in diff mode.
+type PrestateDiff struct {
+	Pre  map[common.Address]*PrestateAccount `json:"pre"`
+	Post map[common.Address]*PrestateAccount `json:"post"`
+}
+
+type VmTrace struct {
+	Code hexutil.Bytes  `json:"code"`
+	Ops  []*VmOperation `json:"ops"`
+}
+
+type VmOperation struct {
+	Cost uint64               `json:"cost"`
+	Ex   *VmExecutedOperation `json:"ex"`
+	Pc   uint64               `json:"pc"`
+	Sub  *VmTrace             `json:"sub"`
+	Op   string               `json:"op"`
+}
+
+type VmExecutedOperation struct {
+	Used  uint64         `json:"used"`
+	Push  []*hexutil.Big `json:"push"`
+	Mem   *VmMemoryDiff  `json:"mem"`
+	Store *VmStoreDiff   `json:"store"`
+}
+
+type VmMemoryDiff struct {
+	Off  uint64        `json:"off"`
+	Data hexutil.Bytes `json:"data"`
+}
+
+type VmStoreDiff struct {
+	Key *hexutil.Big `json:"key"`
+	Val *hexutil.Big `json:"val"`
+}
@@ -15,6 +15,13 @@ type ChainStateAndTransactionReader interface {
 	ethereum.TransactionReader
 	ethereum.ChainIDReader
 	BatchedRpc
+	RawRpc
+}
+
+// ChainReaderAndCaller is a reader which also runs calls against the upstream.
+type ChainReaderAndCaller interface {
+	ChainStateAndTransactionReader
+	ethereum.ContractCaller
 }
 
 type BatchReq struct {
@@ -32,3 +39,9 @@ type BatchedStateReader interface {
 	ethereum.ChainStateReader
 	BatchedRpc
 }
+
+// RawRpc allows forwarding a request to the upstream node as is, this is used
+// for namespaces the fork can't answer from local state (e.g. pre-fork traces).
+type RawRpc interface {
+	CallContext(ctx context.Context, result any, method string, args ...any) error
+}
@@ -0,0 +1,87 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// rough per entry costs including the map overhead, the estimate is meant to
+// compare sessions with each other rather than to match the heap exactly.
+const (
+	accountSizeEstimate     = 160
+	slotSizeEstimate        = 112
+	blockSizeEstimate       = 1024
+	transactionSizeEstimate = 2048
+)
+
+// SessionSize is an estimate of the memory held by an execution session, the
+// accounts and slots include the changes kept for every mined block.
+type SessionSize struct {
+	Accounts     int    `json:"accounts"`
+	Slots        int    `json:"slots"`
+	CodeBytes    int    `json:"codeBytes"`
+	Blocks       int    `json:"blocks"`
+	Transactions int    `json:"transactions"`
+	Bytes        uint64 `json:"bytes"`
+}
+
+// AddState adds the accounts, slots and code of the state.
+func (s *SessionSize) AddState(storage *AccountsStorage, state *AccountsState) {
+	accounts, slots, code := storage.Size()
+	s.Accounts += max(accounts, state.Len())
+	s.Slots += slots
+	s.CodeBytes += code
+}
+
+// AddBlocks adds the blocks along with the state they keep, the code is shared
+// with the live state and is not counted again.
+func (s *SessionSize) AddBlocks(blocks *BlockStorage) {
+	blocks.mu.Lock()
+	defer blocks.mu.Unlock()
+
+	for _, block := range blocks.storage {
+		s.Blocks++
+		if block.Block != nil {
+			s.Transactions += len(block.Block.Transactions())
+		}
+		if block.Accounts != nil && block.State != nil {
+			accounts, slots, _ := block.Accounts.Size()
+			s.Accounts += max(accounts, block.State.Len())
+			s.Slots += slots
+		}
+	}
+}
+
+// Estimate fills in Bytes from the counts.
+func (s *SessionSize) Estimate() {
+	s.Bytes = uint64(s.Accounts)*accountSizeEstimate +
+		uint64(s.Slots)*slotSizeEstimate +
+		uint64(s.CodeBytes) +
+		uint64(s.Blocks)*blockSizeEstimate +
+		uint64(s.Transactions)*transactionSizeEstimate
+}
+
+// SessionMetrics are the counters of the execution session storage, Expired
+// sessions outlived the ttl while Evicted ones made room for a new session.
+// BaseBytes is the estimate of the upstream values shared by the sessions.
+type SessionMetrics struct {
+	Sessions    int    `json:"sessions"`
+	MaxSessions int    `json:"maxSessions"`
+	Created     uint64 `json:"created"`
+	Expired     uint64 `json:"expired"`
+	Evicted     uint64 `json:"evicted"`
+	Bytes       uint64 `json:"bytes"`
+	BaseBytes   uint64 `json:"baseBytes"`
+}
+
+// SessionInfo describes an execution session, ForkBlock is the upstream block it
+// is forked from and BlockNumber its latest block.
+type SessionInfo struct {
+	Key         string         `json:"key"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	LastUsed    time.Time      `json:"lastUsed"`
+	ForkBlock   hexutil.Uint64 `json:"forkBlock"`
+	BlockNumber hexutil.Uint64 `json:"blockNumber"`
+	Size        SessionSize    `json:"size"`
+}
@@ -0,0 +1,71 @@
+package entity
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// TransactionArgs is a call of eth_simulateV1 or eth_sendTransaction, every field
+// is optional.
+type TransactionArgs struct {
+	From                 *common.Address `json:"from"`
+	To                   *common.Address `json:"to"`
+	Gas                  *hexutil.Uint64 `json:"gas"`
+	GasPrice             *hexutil.Big    `json:"gasPrice"`
+	MaxFeePerGas         *hexutil.Big    `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big    `json:"maxPriorityFeePerGas"`
+	Value                *hexutil.Big    `json:"value"`
+	Nonce                *hexutil.Uint64 `json:"nonce"`
+	Data                 *hexutil.Bytes  `json:"data"`
+	Input                *hexutil.Bytes  `json:"input"`
+}
+
+// CallData returns the input of the call, input takes precedence over data.
+func (a *TransactionArgs) CallData() []byte {
+	if a.Input != nil {
+		return *a.Input
+	}
+
+	if a.Data != nil {
+		return *a.Data
+	}
+
+	return nil
+}
+
+// SimBlock is a batch of calls executed in order in a single simulated block.
+type SimBlock struct {
+	BlockOverrides *BlockOverrides   `json:"blockOverrides"`
+	StateOverrides StateOverrides    `json:"stateOverrides"`
+	Calls          []TransactionArgs `json:"calls"`
+}
+
+type SimulateOpts struct {
+	BlockStateCalls        []SimBlock `json:"blockStateCalls"`
+	TraceTransfers         bool       `json:"traceTransfers"`
+	Validation             bool       `json:"validation"`
+	ReturnFullTransactions bool       `json:"returnFullTransactions"`
+}
+
+type SimulatedCallError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data,omitempty"`
+}
+
+type SimulatedCall struct {
+	ReturnData hexutil.Bytes       `json:"returnData"`
+	Logs       []*types.Log        `json:"logs"`
+	GasUsed    hexutil.Uint64      `json:"gasUsed"`
+	Status     hexutil.Uint64      `json:"status"`
+	Error      *SimulatedCallError `json:"error,omitempty"`
+}
+
+// SimulatedBlock is a block produced by eth_simulateV1, transactions are either
+// hashes or full transactions.
+type SimulatedBlock struct {
+	*SerializedBlock
+	Transactions []any           `json:"transactions"`
+	Calls        []SimulatedCall `json:"calls"`
+}
@@ -1,8 +1,12 @@
 package entity
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"maps"
 	"math/big"
+	"strings"
 	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -74,6 +78,34 @@ func (a *AccountsStorage) ReadStorage(addr common.Address, key common.Hash) comm
 	return s.Slots[key]
 }
 
+// LookupCode reads the code of the account and reports whether it's loaded.
+func (a *AccountsStorage) LookupCode(addr common.Address) ([]byte, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	s, ok := a.data[addr]
+	if !ok || !s.Initialized {
+		return nil, false
+	}
+
+	return s.Code, true
+}
+
+// LookupStorage reads a slot and reports whether it's set, unlike ReadStorage a
+// slot set to zero can be told apart from a missing one.
+func (a *AccountsStorage) LookupStorage(addr common.Address, key common.Hash) (common.Hash, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	s, ok := a.data[addr]
+	if !ok || !s.Initialized {
+		return common.Hash{}, false
+	}
+
+	value, ok := s.Slots[key]
+	return value, ok
+}
+
 func (a *AccountsStorage) SetStorage(addr common.Address, key common.Hash, value common.Hash) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
@@ -86,6 +118,19 @@ func (a *AccountsStorage) SetStorage(addr common.Address, key common.Hash, value
 	s.Slots[key] = value
 }
 
+// ClearStorage drops every slot of the account, the account has to be loaded.
+func (a *AccountsStorage) ClearStorage(addr common.Address) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, ok := a.data[addr]
+	if !ok || !s.Initialized {
+		return
+	}
+
+	s.Slots = map[common.Hash]common.Hash{}
+}
+
 func (a *AccountsStorage) SetCode(addr common.Address, code []byte) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
@@ -111,6 +156,9 @@ func (a *AccountsStorage) GetCode(addr common.Address) []byte {
 }
 
 func (a *AccountsStorage) Clone() AccountsStorageCache {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
 	clone := map[common.Address]*AccountStorage{}
 	for key, v := range a.data {
 		slots := make(map[common.Hash]common.Hash)
@@ -125,6 +173,20 @@ func (a *AccountsStorage) Clone() AccountsStorageCache {
 	return clone
 }
 
+// Size returns the number of accounts, slots and code bytes held.
+func (a *AccountsStorage) Size() (accounts, slots, code int) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for _, v := range a.data {
+		accounts++
+		slots += len(v.Slots)
+		code += len(v.Code)
+	}
+
+	return accounts, slots, code
+}
+
 func (a *AccountsStorage) Set(s map[common.Address]*AccountStorage) {
 	a.data = s
 }
@@ -216,6 +278,9 @@ func NewAccountsStateWithStorage(data AccountStateStorage) *AccountsState {
 }
 
 func (a *AccountsState) Clone() AccountStateStorage {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
 	clone := map[common.Address]*AccountState{}
 	for k, v := range a.data {
 		clone[k] = &AccountState{
@@ -229,6 +294,14 @@ func (a *AccountsState) Clone() AccountStateStorage {
 	return clone
 }
 
+// Len returns the number of accounts held.
+func (a *AccountsState) Len() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return len(a.data)
+}
+
 func (a *AccountsState) Set(s AccountStateStorage) {
 	a.data = s
 }
@@ -343,10 +416,100 @@ func (a *AccountsState) Apply(s *AccountsState) {
 	}
 }
 
+// StateOverride replaces parts of an account for an execution, State replaces the
+// whole storage of the account while StateDiff only replaces the given slots.
+// Storage is kept for older clients and behaves like StateDiff.
 type StateOverride struct {
-	Code    []byte   `json:"code"`
-	Balance *big.Int `json:"balance"`
-	Storage Storage  `json:"storage"`
+	Code      hexutil.Bytes   `json:"code,omitempty"`
+	Balance   *big.Int        `json:"balance,omitempty"`
+	Nonce     *hexutil.Uint64 `json:"nonce,omitempty"`
+	State     Storage         `json:"state,omitempty"`
+	StateDiff Storage         `json:"stateDiff,omitempty"`
+	Storage   Storage         `json:"storage,omitempty"`
+}
+
+// UnmarshalJSON accepts the balance either as a json number or as a hex or
+// decimal string.
+func (o *StateOverride) UnmarshalJSON(data []byte) error {
+	type override StateOverride
+	var raw struct {
+		override
+		Balance json.RawMessage `json:"balance"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*o = StateOverride(raw.override)
+	o.Balance = nil
+	if len(raw.Balance) == 0 || string(raw.Balance) == "null" {
+		return nil
+	}
+
+	balance := strings.Trim(string(raw.Balance), `"`)
+	value, ok := new(big.Int).SetString(balance, 0)
+	if !ok {
+		return fmt.Errorf("invalid balance %s", balance)
+	}
+
+	o.Balance = value
+	return nil
 }
 
 type StateOverrides map[common.Address]StateOverride
+
+// Clone returns a deep copy of the overrides.
+func (o StateOverrides) Clone() StateOverrides {
+	if o == nil {
+		return nil
+	}
+
+	clone := make(StateOverrides, len(o))
+	for addr, override := range o {
+		if override.Code != nil {
+			override.Code = bytes.Clone(override.Code)
+		}
+		if override.Balance != nil {
+			override.Balance = new(big.Int).Set(override.Balance)
+		}
+		if override.Nonce != nil {
+			nonce := *override.Nonce
+			override.Nonce = &nonce
+		}
+		override.State = maps.Clone(override.State)
+		override.StateDiff = maps.Clone(override.StateDiff)
+		override.Storage = maps.Clone(override.Storage)
+		clone[addr] = override
+	}
+
+	return clone
+}
+
+// StorageSlot is a storage slot of an account along with its value.
+type StorageSlot struct {
+	Address common.Address `json:"address"`
+	Slot    common.Hash    `json:"slot"`
+	Value   common.Hash    `json:"value"`
+}
+
+// SlotCandidate is a slot read by a getter, Probe is a value which changes the
+// return of the getter when written into the slot and Return what it returned.
+// Matches is set when the getter returns the slot as is.
+type SlotCandidate struct {
+	StorageSlot
+	Probe   *common.Hash  `json:"probe,omitempty"`
+	Return  hexutil.Bytes `json:"return,omitempty"`
+	Matches bool          `json:"matches"`
+}
+
+// Rank orders the candidates, lower ranks are more likely to back the getter.
+func (c *SlotCandidate) Rank() int {
+	switch {
+	case c.Matches:
+		return 0
+	case c.Probe != nil:
+		return 1
+	default:
+		return 2
+	}
+}
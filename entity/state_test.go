@@ -1,6 +1,7 @@
 package entity
 
 import (
+	"encoding/json"
 	"math/big"
 	"testing"
 
@@ -38,3 +39,43 @@ func TestAccountsState(t *testing.T) {
 	state.SetNonce(addr, nonce+1)
 	assert.Equal(t, nonce+1, state.GetNonce(addr))
 }
+
+func TestStateOverrideUnmarshal(t *testing.T) {
+	var overrides StateOverrides
+	err := json.Unmarshal([]byte(`{
+		"0x0000000000000000000000000000000000000001": {"balance": "0x10", "nonce": "0x2", "code": "0x6000"},
+		"0x0000000000000000000000000000000000000002": {"balance": 100, "stateDiff": {
+			"0x0000000000000000000000000000000000000000000000000000000000000001": "0x0000000000000000000000000000000000000000000000000000000000000002"
+		}}
+	}`), &overrides)
+	assert.NoError(t, err)
+
+	first := overrides[common.HexToAddress("0x1")]
+	assert.Equal(t, big.NewInt(16), first.Balance)
+	assert.Equal(t, uint64(2), uint64(*first.Nonce))
+	assert.Equal(t, []byte{0x60, 0x00}, []byte(first.Code))
+
+	second := overrides[common.HexToAddress("0x2")]
+	assert.Equal(t, big.NewInt(100), second.Balance)
+	assert.Nil(t, second.Nonce)
+	assert.Equal(t, common.HexToHash("0x2"), second.StateDiff[common.HexToHash("0x1")])
+}
+
+func TestStateOverridesClone(t *testing.T) {
+	addr := common.HexToAddress("0x1")
+	key := common.HexToHash("0x1")
+	overrides := StateOverrides{addr: {
+		Balance:   big.NewInt(1),
+		State:     Storage{key: common.HexToHash("0x1")},
+		StateDiff: Storage{key: common.HexToHash("0x1")},
+	}}
+
+	clone := overrides.Clone()
+	clone[addr].Balance.SetInt64(2)
+	clone[addr].State[key] = common.HexToHash("0x2")
+	clone[addr].StateDiff[key] = common.HexToHash("0x2")
+
+	assert.Equal(t, big.NewInt(1), overrides[addr].Balance)
+	assert.Equal(t, common.HexToHash("0x1"), overrides[addr].State[key])
+	assert.Equal(t, common.HexToHash("0x1"), overrides[addr].StateDiff[key])
+}
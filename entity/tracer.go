@@ -1,8 +1,33 @@
 package entity
 
-import "github.com/ethereum/go-ethereum/core/tracing"
+import (
+	"encoding/json"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+)
 
 type TraceProvider interface {
 	Hooks() *tracing.Hooks
 	OtterTrace() TransactionTraces
 }
+
+// TraceConfig mirrors the options accepted by geth's debug_trace* methods,
+// the embedded logger config is only used by the default struct logger.
+type TraceConfig struct {
+	*logger.Config
+	Tracer       *string         `json:"tracer"`
+	TracerConfig json.RawMessage `json:"tracerConfig,omitempty"`
+	Timeout      *string         `json:"timeout,omitempty"`
+}
+
+type TraceCallConfig struct {
+	TraceConfig
+}
+
+type TxTraceResult struct {
+	TxHash common.Hash     `json:"txHash"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
@@ -2,40 +2,49 @@ package entity
 
 import (
 	"encoding/json"
+	"sort"
 	"sync"
-	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
-	types2 "github.com/raul0ligma/smelter/types"
 	"github.com/raul0ligma/smelter/utils"
 )
 
 type SerializedTransaction struct {
-	From             common.Address `json:"from"`
-	BlockHash        common.Hash    `json:"blockHash"`
-	BlockNumber      string         `json:"blockNumber"`
-	ChainId          string         `json:"chainId"`
-	Confirmations    uint64         `json:"confirmations"`
-	Creates          common.Address `json:"creates"`
-	Data             string         `json:"data"`
-	Gas              string         `json:"gas"`
-	GasLimit         uint64         `json:"gasLimit"`
-	GasPrice         string         `json:"gasPrice"`
-	Hash             common.Hash    `json:"hash"`
-	Nonce            string         `json:"nonce"`
-	R                string         `json:"r"`
-	S                string         `json:"s"`
-	V                string         `json:"v"`
-	TransactionIndex uint           `json:"transactionIndex"`
-	Type             string         `json:"type"`
-	Value            string         `json:"value"`
-	Input            string         `json:"input"`
+	From                 common.Address    `json:"from"`
+	To                   *common.Address   `json:"to"`
+	BlockHash            common.Hash       `json:"blockHash"`
+	BlockNumber          string            `json:"blockNumber"`
+	ChainId              string            `json:"chainId,omitempty"`
+	Confirmations        uint64            `json:"confirmations"`
+	Creates              *common.Address   `json:"creates"`
+	Data                 string            `json:"data"`
+	Gas                  string            `json:"gas"`
+	GasLimit             uint64            `json:"gasLimit"`
+	GasPrice             string            `json:"gasPrice"`
+	MaxFeePerGas         string            `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas string            `json:"maxPriorityFeePerGas,omitempty"`
+	MaxFeePerBlobGas     string            `json:"maxFeePerBlobGas,omitempty"`
+	BlobVersionedHashes  []common.Hash     `json:"blobVersionedHashes,omitempty"`
+	AccessList           *types.AccessList `json:"accessList,omitempty"`
+	Hash                 common.Hash       `json:"hash"`
+	Nonce                string            `json:"nonce"`
+	R                    string            `json:"r"`
+	S                    string            `json:"s"`
+	V                    string            `json:"v"`
+	YParity              *hexutil.Uint64   `json:"yParity,omitempty"`
+	TransactionIndex     hexutil.Uint      `json:"transactionIndex"`
+	Type                 string            `json:"type"`
+	Value                string            `json:"value"`
+	Input                string            `json:"input"`
 }
 
+// SerializedReceipt is a receipt along with the sender and the block timestamp
+// of its transaction, the otterscan methods expect both to be inlined.
 type SerializedReceipt struct {
-	From common.Address `json:"from"`
+	From      common.Address `json:"from"`
+	Timestamp uint64         `json:"timestamp"`
 	types.Receipt
 }
 
@@ -52,45 +61,92 @@ func (sr *SerializedReceipt) MarshalJSON() ([]byte, error) {
 	}
 
 	data["from"] = sr.From
-	data["type"] = 0
-	data["timestamp"] = time.Now().Unix()
+	data["timestamp"] = sr.Timestamp
 
 	return json.Marshal(data)
 }
 
-func SerializeReceipt(r *types.Receipt) *SerializedReceipt {
+func SerializeReceipt(r *types.Receipt, from common.Address, timestamp uint64) *SerializedReceipt {
 	return &SerializedReceipt{
-		From:    types2.Address0x1,
-		Receipt: *r,
+		From:      from,
+		Timestamp: timestamp,
+		Receipt:   *r,
 	}
 }
 
-func SerializeTransaction(tx *types.Transaction, receipt *types.Receipt) *SerializedTransaction {
+// RecoverSender returns the signer of tx, the zero address is returned for
+// unsigned transactions.
+func RecoverSender(tx *types.Transaction) common.Address {
+	var signer types.Signer = types.HomesteadSigner{}
+	if tx.Protected() {
+		signer = types.LatestSignerForChainID(tx.ChainId())
+	}
+
+	sender, err := types.Sender(signer, tx)
+	if err != nil {
+		return common.Address{}
+	}
+
+	return sender
+}
+
+func SerializeTransaction(tx *types.Transaction, receipt *types.Receipt, from common.Address) *SerializedTransaction {
 	if tx == nil || receipt == nil {
 		return nil
 	}
 
-	return &SerializedTransaction{
-		From:             types2.Address0x1,
+	v, r, sig := tx.RawSignatureValues()
+	serialized := &SerializedTransaction{
+		From:             from,
+		To:               tx.To(),
 		BlockHash:        receipt.BlockHash,
 		BlockNumber:      utils.Big2Hex(receipt.BlockNumber),
-		ChainId:          hexutil.Encode(tx.ChainId().Bytes()),
 		Confirmations:    1,
-		Creates:          common.HexToAddress(""),
 		Data:             hexutil.Encode(tx.Data()),
 		Input:            hexutil.Encode(tx.Data()),
 		GasLimit:         tx.Gas(),
 		GasPrice:         utils.Big2Hex(tx.GasPrice()),
 		Hash:             tx.Hash(),
 		Nonce:            hexutil.EncodeUint64(tx.Nonce()),
-		R:                utils.Big2Hex(nil),
-		S:                utils.Big2Hex(nil),
-		V:                utils.Big2Hex(nil),
+		R:                utils.Big2Hex(r),
+		S:                utils.Big2Hex(sig),
+		V:                utils.Big2Hex(v),
 		Gas:              hexutil.EncodeUint64(tx.Gas()),
-		TransactionIndex: receipt.TransactionIndex,
+		TransactionIndex: hexutil.Uint(receipt.TransactionIndex),
 		Type:             hexutil.EncodeUint64(uint64(tx.Type())),
 		Value:            utils.Big2Hex(tx.Value()),
 	}
+
+	// unprotected legacy transactions don't commit to a chain
+	if tx.Protected() {
+		serialized.ChainId = utils.Big2Hex(tx.ChainId())
+	}
+
+	if tx.To() == nil {
+		serialized.Creates = &receipt.ContractAddress
+	}
+
+	if tx.Type() != types.LegacyTxType {
+		accessList := tx.AccessList()
+		yParity := hexutil.Uint64(v.Uint64())
+		serialized.AccessList = &accessList
+		serialized.YParity = &yParity
+	}
+
+	if tx.Type() >= types.DynamicFeeTxType {
+		serialized.MaxFeePerGas = utils.Big2Hex(tx.GasFeeCap())
+		serialized.MaxPriorityFeePerGas = utils.Big2Hex(tx.GasTipCap())
+		if receipt.EffectiveGasPrice != nil {
+			serialized.GasPrice = utils.Big2Hex(receipt.EffectiveGasPrice)
+		}
+	}
+
+	if tx.Type() == types.BlobTxType {
+		serialized.MaxFeePerBlobGas = utils.Big2Hex(tx.BlobGasFeeCap())
+		serialized.BlobVersionedHashes = tx.BlobHashes()
+	}
+
+	return serialized
 }
 
 type TransactionStorage struct {
@@ -98,6 +154,37 @@ type TransactionStorage struct {
 	txs      map[common.Hash]*types.Transaction
 	receipts map[common.Hash]*types.Receipt
 	traces   map[common.Hash]TransactionTraces
+	senders  map[common.Hash]common.Address
+	creators map[common.Address]*ContractCreator
+	nonces   map[common.Address]map[uint64]common.Hash
+	accounts map[common.Address][]*IndexedTransaction
+}
+
+// TxPosition locates a transaction in the local chain, it doubles as the
+// pagination cursor of account histories.
+type TxPosition struct {
+	BlockNumber      hexutil.Uint64 `json:"blockNumber"`
+	TransactionIndex hexutil.Uint   `json:"transactionIndex"`
+}
+
+func (p TxPosition) Before(o TxPosition) bool {
+	if p.BlockNumber != o.BlockNumber {
+		return p.BlockNumber < o.BlockNumber
+	}
+
+	return p.TransactionIndex < o.TransactionIndex
+}
+
+type IndexedTransaction struct {
+	Hash     common.Hash
+	Position TxPosition
+}
+
+// AccountHistory is a page of the local transactions of an account, Cursor is
+// nil once there are no more transactions.
+type AccountHistory struct {
+	Transactions []*SerializedTransaction `json:"transactions"`
+	Cursor       *TxPosition              `json:"cursor"`
 }
 
 func NewTransactionStorage() *TransactionStorage {
@@ -105,6 +192,10 @@ func NewTransactionStorage() *TransactionStorage {
 		txs:      make(map[common.Hash]*types.Transaction),
 		receipts: make(map[common.Hash]*types.Receipt),
 		traces:   make(map[common.Hash]TransactionTraces),
+		senders:  make(map[common.Hash]common.Address),
+		creators: make(map[common.Address]*ContractCreator),
+		nonces:   make(map[common.Address]map[uint64]common.Hash),
+		accounts: make(map[common.Address][]*IndexedTransaction),
 	}
 }
 
@@ -148,9 +239,125 @@ func (ts *TransactionStorage) GetTrace(hash common.Hash) TransactionTraces {
 	return ts.traces[hash]
 }
 
-func (ts *TransactionStorage) Apply(s *TransactionStorage) {
+// AddSender records who executed a transaction, local transactions are
+// unsigned so the sender can't be recovered from the transaction itself.
+func (ts *TransactionStorage) AddSender(hash common.Hash, sender common.Address) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.senders[hash] = sender
+}
+
+// GetSender returns the recorded sender of a transaction.
+func (ts *TransactionStorage) GetSender(hash common.Hash) (common.Address, bool) {
 	ts.mu.RLock()
 	defer ts.mu.RUnlock()
+	sender, ok := ts.senders[hash]
+	return sender, ok
+}
+
+// AddSenderNonce indexes a transaction by its sender and nonce.
+func (ts *TransactionStorage) AddSenderNonce(sender common.Address, nonce uint64, hash common.Hash) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if _, ok := ts.nonces[sender]; !ok {
+		ts.nonces[sender] = make(map[uint64]common.Hash)
+	}
+	ts.nonces[sender][nonce] = hash
+}
+
+// GetBySenderAndNonce returns the hash of the transaction sent by sender with the given nonce.
+func (ts *TransactionStorage) GetBySenderAndNonce(sender common.Address, nonce uint64) (common.Hash, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	hash, ok := ts.nonces[sender][nonce]
+	return hash, ok
+}
+
+// AddContractCreator records the transaction and the account which deployed a contract.
+func (ts *TransactionStorage) AddContractCreator(contract common.Address, creator *ContractCreator) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.creators[contract] = creator
+}
+
+func (ts *TransactionStorage) GetContractCreator(contract common.Address) *ContractCreator {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.creators[contract]
+}
+
+// IndexAccounts records that the transaction at pos touched every one of addrs.
+func (ts *TransactionStorage) IndexAccounts(hash common.Hash, pos TxPosition, addrs ...common.Address) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for _, addr := range addrs {
+		ts.indexAccount(addr, &IndexedTransaction{Hash: hash, Position: pos})
+	}
+}
+
+func (ts *TransactionStorage) indexAccount(addr common.Address, tx *IndexedTransaction) {
+	txs := ts.accounts[addr]
+	i := sort.Search(len(txs), func(i int) bool {
+		return !txs[i].Position.Before(tx.Position)
+	})
+
+	if i < len(txs) && txs[i].Position == tx.Position {
+		return
+	}
+
+	txs = append(txs, nil)
+	copy(txs[i+1:], txs[i:])
+	txs[i] = tx
+	ts.accounts[addr] = txs
+}
+
+// AccountTransactions returns up to limit transactions touching addr ordered by
+// position, starting right after cursor. A nil cursor starts at the oldest
+// transaction, or at the newest one when desc is set.
+func (ts *TransactionStorage) AccountTransactions(
+	addr common.Address,
+	cursor *TxPosition,
+	limit int,
+	desc bool,
+) []*IndexedTransaction {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	txs := ts.accounts[addr]
+	page := make([]*IndexedTransaction, 0)
+	if desc {
+		end := len(txs)
+		if cursor != nil {
+			end = sort.Search(len(txs), func(i int) bool {
+				return !txs[i].Position.Before(*cursor)
+			})
+		}
+
+		for i := end - 1; i >= 0 && len(page) < limit; i-- {
+			page = append(page, txs[i])
+		}
+		return page
+	}
+
+	start := 0
+	if cursor != nil {
+		start = sort.Search(len(txs), func(i int) bool {
+			return cursor.Before(txs[i].Position)
+		})
+	}
+
+	for i := start; i < len(txs) && len(page) < limit; i++ {
+		page = append(page, txs[i])
+	}
+	return page
+}
+
+func (ts *TransactionStorage) Apply(s *TransactionStorage) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
 	for hash, v := range s.txs {
 		ts.txs[hash] = v
@@ -159,6 +366,33 @@ func (ts *TransactionStorage) Apply(s *TransactionStorage) {
 	for hash, v := range s.receipts {
 		ts.receipts[hash] = v
 	}
+
+	for hash, v := range s.traces {
+		ts.traces[hash] = v
+	}
+
+	for hash, v := range s.senders {
+		ts.senders[hash] = v
+	}
+
+	for contract, v := range s.creators {
+		ts.creators[contract] = v
+	}
+
+	for sender, nonces := range s.nonces {
+		if _, ok := ts.nonces[sender]; !ok {
+			ts.nonces[sender] = make(map[uint64]common.Hash)
+		}
+		for nonce, hash := range nonces {
+			ts.nonces[sender][nonce] = hash
+		}
+	}
+
+	for addr, txs := range s.accounts {
+		for _, tx := range txs {
+			ts.indexAccount(addr, tx)
+		}
+	}
 }
 
 func (ts *TransactionStorage) All() []*types.Transaction {
@@ -1,10 +1,14 @@
 package entity
 
 import (
+	"encoding/json"
+	"math/big"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
 func TestAddAndGetTransaction(t *testing.T) {
@@ -42,3 +46,136 @@ func TestAddAndGetReceipt(t *testing.T) {
 		t.Fatalf("Expected receipt hash %v, got %v", receipt.TxHash, retrievedReceipt.TxHash)
 	}
 }
+
+func TestSenderNonceIndex(t *testing.T) {
+	storage := NewTransactionStorage()
+	sender := common.HexToAddress("0x6")
+	tx := types.NewTransaction(1, common.HexToAddress("0x0"), nil, 21000, nil, nil)
+	storage.AddSenderNonce(sender, tx.Nonce(), tx.Hash())
+
+	hash, ok := storage.GetBySenderAndNonce(sender, 1)
+	if !ok || hash != tx.Hash() {
+		t.Fatalf("Expected transaction hash %v, got %v", tx.Hash(), hash)
+	}
+
+	if _, ok := storage.GetBySenderAndNonce(sender, 2); ok {
+		t.Fatalf("Expected no transaction for nonce 2")
+	}
+}
+
+func TestInternalOperations(t *testing.T) {
+	traces := TransactionTraces{
+		{Type: "CALL", Depth: 0, From: "0x01", To: "0x02", Value: "0x10"},
+		{Type: "CALL", Depth: 1, From: "0x02", To: "0x03", Value: "0x0"},
+		{Type: "CALL", Depth: 1, From: "0x02", To: "0x04", Value: "0x05"},
+		{Type: "CREATE2", Depth: 1, From: "0x02", To: "0x05", Value: "0x0"},
+		{Type: "CREATE", Depth: 1, From: "0x02", To: "0x06", Value: "0x0", Reverted: true},
+	}
+
+	ops := traces.InternalOperations()
+	if len(ops) != 2 {
+		t.Fatalf("Expected 2 internal operations, got %d", len(ops))
+	}
+
+	if ops[0].Type != OperationTransfer || ops[0].To != common.HexToAddress("0x04") {
+		t.Fatalf("Expected transfer to 0x04, got %+v", ops[0])
+	}
+
+	if ops[1].Type != OperationCreate2 || ops[1].To != common.HexToAddress("0x05") {
+		t.Fatalf("Expected create2 of 0x05, got %+v", ops[1])
+	}
+}
+
+func TestAccountTransactions(t *testing.T) {
+	storage := NewTransactionStorage()
+	addr := common.HexToAddress("0x6")
+	other := common.HexToAddress("0x7")
+
+	// indexed out of order on purpose
+	for _, block := range []uint64{3, 1, 2} {
+		hash := common.BigToHash(new(big.Int).SetUint64(block))
+		storage.IndexAccounts(hash, TxPosition{BlockNumber: hexutil.Uint64(block)}, addr)
+	}
+	storage.IndexAccounts(common.HexToHash("0x4"), TxPosition{BlockNumber: 4}, other)
+
+	asc := storage.AccountTransactions(addr, nil, 10, false)
+	if len(asc) != 3 || asc[0].Position.BlockNumber != 1 || asc[2].Position.BlockNumber != 3 {
+		t.Fatalf("Expected blocks 1..3 in ascending order, got %+v", asc)
+	}
+
+	desc := storage.AccountTransactions(addr, nil, 2, true)
+	if len(desc) != 2 || desc[0].Position.BlockNumber != 3 || desc[1].Position.BlockNumber != 2 {
+		t.Fatalf("Expected blocks 3 and 2, got %+v", desc)
+	}
+
+	next := storage.AccountTransactions(addr, &desc[1].Position, 2, true)
+	if len(next) != 1 || next[0].Position.BlockNumber != 1 {
+		t.Fatalf("Expected block 1 after the cursor, got %+v", next)
+	}
+}
+
+func TestSerializeDynamicFeeTransaction(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	to := common.HexToAddress("0x69")
+	chainID := big.NewInt(1)
+
+	tx, err := types.SignNewTx(key, types.LatestSignerForChainID(chainID), &types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     7,
+		GasTipCap: big.NewInt(2),
+		GasFeeCap: big.NewInt(10),
+		Gas:       21000,
+		To:        &to,
+		Value:     big.NewInt(1),
+		AccessList: types.AccessList{
+			{Address: to, StorageKeys: []common.Hash{common.HexToHash("0x1")}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+
+	if recovered := RecoverSender(tx); recovered != sender {
+		t.Fatalf("Expected sender %v, got %v", sender, recovered)
+	}
+
+	receipt := &types.Receipt{
+		Type:              tx.Type(),
+		TxHash:            tx.Hash(),
+		BlockNumber:       big.NewInt(2),
+		EffectiveGasPrice: big.NewInt(5),
+		TransactionIndex:  3,
+	}
+	serialized := SerializeTransaction(tx, receipt, sender)
+	if serialized.From != sender || serialized.To == nil || *serialized.To != to {
+		t.Fatalf("Expected %v => %v, got %v => %v", sender, to, serialized.From, serialized.To)
+	}
+
+	if serialized.Type != "0x2" || serialized.ChainId != "0x01" || serialized.Creates != nil {
+		t.Fatalf("Unexpected type fields %+v", serialized)
+	}
+
+	if serialized.MaxFeePerGas != "0x0a" || serialized.MaxPriorityFeePerGas != "0x02" || serialized.GasPrice != "0x05" {
+		t.Fatalf("Unexpected fee fields %+v", serialized)
+	}
+
+	if serialized.R == "0x0" || serialized.S == "0x0" || serialized.YParity == nil {
+		t.Fatalf("Missing signature values %+v", serialized)
+	}
+
+	if serialized.AccessList == nil || len(*serialized.AccessList) != 1 {
+		t.Fatalf("Missing access list %+v", serialized)
+	}
+
+	encoded, err := json.Marshal(SerializeReceipt(receipt, sender, 42))
+	if err != nil {
+		t.Fatalf("Failed to marshal receipt: %v", err)
+	}
+
+	var decoded map[string]any
+	_ = json.Unmarshal(encoded, &decoded)
+	if decoded["type"] != "0x2" || decoded["timestamp"] != float64(42) {
+		t.Fatalf("Unexpected receipt %s", encoded)
+	}
+}
@@ -0,0 +1,228 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/producer"
+	"github.com/raul0ligma/smelter/statedb"
+	"github.com/raul0ligma/smelter/tracer"
+	"github.com/raul0ligma/smelter/vm"
+)
+
+// CallBundle executes the signed transactions in order on a scratch state over the
+// session db, every transaction sees the changes of the previous ones. With commit
+// the bundle is mined as a single block once every transaction succeeded,
+// otherwise nothing is persisted.
+func (e *SerialExecutor) CallBundle(
+	ctx context.Context,
+	txs []*types.Transaction,
+	overrides entity.StateOverrides,
+	blockOverrides *entity.BlockOverrides,
+	commit bool,
+) (*entity.BundleResult, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	executionDB := statedb.NewDB(ctx, e.db)
+	if err := executionDB.ApplyOverrides(overrides); err != nil {
+		return nil, err
+	}
+
+	blockCtx := e.blockContext(blockOverrides)
+	result := &entity.BundleResult{
+		Results:          make([]*entity.BundleTxResult, 0, len(txs)),
+		StateBlockNumber: hexutil.Uint64(e.prevBlockNum),
+	}
+
+	var (
+		receipts = make(types.Receipts, 0, len(txs))
+		traces   = make([]entity.TransactionTraces, 0, len(txs))
+		senders  = make([]common.Address, 0, len(txs))
+		hashes   = make([]byte, 0, len(txs)*common.HashLength)
+		gasFees  = new(big.Int)
+		success  = true
+	)
+	rules := e.cfg.ChainConfig.Rules(blockCtx.BlockNumber, blockCtx.Random != nil, blockCtx.Time)
+	coinbaseStart := executionDB.GetBalance(blockCtx.Coinbase).ToBig()
+	for i, tx := range txs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		from := entity.RecoverSender(tx)
+		if from == (common.Address{}) {
+			return nil, fmt.Errorf("invalid signature of transaction %s", tx.Hash().Hex())
+		}
+		if tx.Protected() && tx.ChainId().Cmp(e.cfg.ChainConfig.ChainID) != 0 {
+			return nil, fmt.Errorf("transaction %s: invalid chain id %s, want %s", tx.Hash().Hex(), tx.ChainId(), e.cfg.ChainConfig.ChainID)
+		}
+
+		// transactions of the same sender chain their nonces through the scratch state
+		nonce := executionDB.GetNonce(from)
+		if tx.Nonce() < nonce {
+			return nil, fmt.Errorf("nonce too low: address %s, tx: %d state: %d", from.Hex(), tx.Nonce(), nonce)
+		}
+		if tx.Nonce() > nonce {
+			return nil, fmt.Errorf("nonce too high: address %s, tx: %d state: %d", from.Hex(), tx.Nonce(), nonce)
+		}
+
+		tip, err := tx.EffectiveGasTip(blockCtx.BaseFee)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %s: %w", tx.Hash().Hex(), err)
+		}
+
+		msg := ethereum.CallMsg{
+			From:     from,
+			To:       tx.To(),
+			Gas:      tx.Gas(),
+			GasPrice: new(big.Int).Add(blockCtx.BaseFee, tip),
+			Value:    tx.Value(),
+			Data:     tx.Data(),
+		}
+
+		// the sender pays the intrinsic gas and has to cover the worst case fee upfront
+		// like in a real block, otherwise the fee would drive its balance negative
+		intrinsic, err := core.IntrinsicGas(tx.Data(), tx.AccessList(), tx.SetCodeAuthorizations(), tx.To() == nil,
+			rules.IsHomestead, rules.IsIstanbul, rules.IsShanghai)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %s: %w", tx.Hash().Hex(), err)
+		}
+		if tx.Gas() < intrinsic {
+			return nil, fmt.Errorf("intrinsic gas too low: address %s, tx: %d want: %d", from.Hex(), tx.Gas(), intrinsic)
+		}
+
+		cost := new(big.Int).Mul(new(big.Int).SetUint64(tx.Gas()), tx.GasFeeCap())
+		cost.Add(cost, tx.Value())
+		if balance := executionDB.GetBalance(from).ToBig(); balance.Cmp(cost) < 0 {
+			return nil, fmt.Errorf("insufficient funds for gas * price + value: address %s have %s want %s",
+				from.Hex(), balance, cost)
+		}
+
+		checkpoint := executionDB.Checkpoint()
+		coinbaseBefore := executionDB.GetBalance(blockCtx.Coinbase).ToBig()
+
+		logTracer := tracer.NewTracer(false)
+		logs := tracer.NewSimulateTracer(false, e.prevBlockNum+1)
+		logs.Reset(tx.Hash(), uint(i))
+		hooks := tracer.NewMuxTracer(logTracer, logs).Hooks()
+		executionDB.SetLogHook(hooks.OnLog)
+
+		chainCfg, evmCfg := e.cfg.ExecutionConfig(hooks)
+		env := vm.NewEVM(blockCtx, executionDB, chainCfg, evmCfg)
+		env.SetTxContext(vm.TxContext{Origin: from, GasPrice: msg.GasPrice})
+
+		receipt := &types.Receipt{
+			Type:              tx.Type(),
+			Status:            types.ReceiptStatusSuccessful,
+			TxHash:            tx.Hash(),
+			EffectiveGasPrice: msg.GasPrice,
+		}
+
+		var (
+			ret   []byte
+			left  uint64
+			vmErr error
+		)
+		value, _ := uint256.FromBig(msg.Value)
+		if msg.To == nil {
+			ret, receipt.ContractAddress, left, vmErr = env.Create(from, msg.Data, msg.Gas-intrinsic, value)
+		} else {
+			executionDB.SetNonce(from, executionDB.GetNonce(from)+1, tracing.NonceChangeUnspecified)
+			ret, left, vmErr = env.Call(from, *msg.To, msg.Data, msg.Gas-intrinsic, value)
+		}
+
+		used := msg.Gas - left
+		chargeGas(executionDB, blockCtx.Coinbase, blockCtx.BaseFee, msg, used)
+
+		diff, err := executionDB.StateDiff(checkpoint)
+		if err != nil {
+			return nil, err
+		}
+
+		fees := new(big.Int).Mul(tip, new(big.Int).SetUint64(used))
+		coinbaseDiff := new(big.Int).Sub(executionDB.GetBalance(blockCtx.Coinbase).ToBig(), coinbaseBefore)
+		txResult := &entity.BundleTxResult{
+			TxHash:            tx.Hash(),
+			FromAddress:       from,
+			ToAddress:         tx.To(),
+			GasUsed:           hexutil.Uint64(used),
+			GasPrice:          (*hexutil.Big)(msg.GasPrice),
+			GasFees:           (*hexutil.Big)(fees),
+			CoinbaseDiff:      (*hexutil.Big)(coinbaseDiff),
+			EthSentToCoinbase: (*hexutil.Big)(new(big.Int).Sub(coinbaseDiff, fees)),
+			Success:           vmErr == nil,
+			ReturnData:        ret,
+			Logs:              logs.Logs(),
+			StateDiff:         diff,
+		}
+		if vmErr != nil {
+			success = false
+			receipt.Status = types.ReceiptStatusFailed
+			txResult.Error = vmErr.Error()
+			if errors.Is(vmErr, vm.ErrExecutionReverted) {
+				txResult.Revert, _ = abi.UnpackRevert(ret)
+			}
+		}
+
+		receipt.GasUsed = used
+		receipt.Logs = txResult.Logs
+		receipt.Bloom = types.CreateBloom(receipt)
+
+		result.Results = append(result.Results, txResult)
+		result.TotalGasUsed += hexutil.Uint64(used)
+		gasFees.Add(gasFees, fees)
+		receipts = append(receipts, receipt)
+		traces = append(traces, logTracer.OtterTrace())
+		senders = append(senders, from)
+		hashes = append(hashes, tx.Hash().Bytes()...)
+	}
+
+	coinbaseDiff := new(big.Int).Sub(executionDB.GetBalance(blockCtx.Coinbase).ToBig(), coinbaseStart)
+	result.BundleHash = crypto.Keccak256Hash(hashes)
+	result.GasFees = (*hexutil.Big)(gasFees)
+	result.CoinbaseDiff = (*hexutil.Big)(coinbaseDiff)
+	result.EthSentToCoinbase = (*hexutil.Big)(new(big.Int).Sub(coinbaseDiff, gasFees))
+
+	if !commit || !success || len(txs) == 0 {
+		return result, nil
+	}
+
+	e.db.ApplyStorage(executionDB.Dirty().GetAccountStorage())
+	e.db.ApplyState(executionDB.Dirty().GetAccountState())
+
+	hash, block, err := producer.MineBlock(
+		txs,
+		receipts,
+		new(big.Int).SetUint64(e.prevBlockNum),
+		e.prevBlockHash,
+		e.db,
+		e.txn, e.blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	e.prevBlockHash = hash
+	e.prevBlockNum = block.Uint64()
+	for i, tx := range txs {
+		e.index(tx, senders[i], tx.Nonce(), traces[i])
+	}
+
+	number := hexutil.Uint64(block.Uint64())
+	result.Committed = true
+	result.BlockHash = &hash
+	result.BlockNumber = &number
+	return result, nil
+}
@@ -0,0 +1,95 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/holiman/uint256"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/statedb"
+	"github.com/raul0ligma/smelter/tracer"
+	"github.com/raul0ligma/smelter/vm"
+)
+
+// CallWithDiff executes tx on top of the session state like CallAndPersist without
+// persisting it and reports the assets it moved along with the state diff against
+// the session db, the overrides are part of the base state and are not reported.
+func (e *SerialExecutor) CallWithDiff(
+	ctx context.Context,
+	tx ethereum.CallMsg,
+	overrides entity.StateOverrides,
+) (*entity.SimulationDiff, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	executionDB := statedb.NewDB(ctx, e.db)
+	if err := executionDB.ApplyOverrides(overrides); err != nil {
+		return nil, err
+	}
+	checkpoint := executionDB.Checkpoint()
+
+	logs := tracer.NewSimulateTracer(true, e.prevBlockNum+1)
+	hooks := logs.Hooks()
+	executionDB.SetLogHook(hooks.OnLog)
+
+	chainCfg, evmCfg := e.cfg.ExecutionConfig(hooks)
+	env := vm.NewEVM(e.blockContext(nil), executionDB, chainCfg, evmCfg)
+	gasPrice := tx.GasPrice
+	if gasPrice == nil {
+		gasPrice = new(big.Int)
+	}
+	env.SetTxContext(vm.TxContext{Origin: tx.From, GasPrice: gasPrice})
+
+	var (
+		ret   []byte
+		left  uint64
+		vmErr error
+	)
+	value, _ := uint256.FromBig(tx.Value)
+	if tx.To == nil {
+		ret, _, left, vmErr = env.Create(tx.From, tx.Data, tx.Gas, value)
+	} else {
+		executionDB.SetNonce(tx.From, executionDB.GetNonce(tx.From)+1, tracing.NonceChangeUnspecified)
+		ret, left, vmErr = env.Call(tx.From, *tx.To, tx.Data, tx.Gas, value)
+	}
+
+	diff, err := executionDB.StateDiff(checkpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	// the native transfers are only traced to be decoded, they are not real logs
+	allLogs := logs.Logs()
+	txLogs := make([]*types.Log, 0, len(allLogs))
+	for _, log := range allLogs {
+		if log.Address != entity.NativeAssetAddress {
+			txLogs = append(txLogs, log)
+		}
+	}
+
+	assets, approvals := entity.DecodeAssetChanges(allLogs)
+	result := &entity.SimulationDiff{
+		Success:        vmErr == nil,
+		ReturnData:     ret,
+		GasUsed:        hexutil.Uint64(tx.Gas - left),
+		Logs:           txLogs,
+		BalanceChanges: diff.BalanceChanges(),
+		AssetChanges:   assets,
+		Approvals:      approvals,
+		StateDiff:      diff,
+	}
+	if vmErr != nil {
+		result.Error = vmErr.Error()
+		if errors.Is(vmErr, vm.ErrExecutionReverted) {
+			result.Revert, _ = abi.UnpackRevert(ret)
+		}
+	}
+
+	return result, nil
+}
@@ -9,6 +9,9 @@ import (
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/holiman/uint256"
 	"github.com/raul0ligma/smelter/config"
 	"github.com/raul0ligma/smelter/entity"
@@ -27,6 +30,7 @@ type SerialExecutor struct {
 	blocks        *entity.BlockStorage
 	prevBlockHash common.Hash
 	prevBlockNum  uint64
+	flattenEvery  int
 }
 
 func NewExecutor(
@@ -47,6 +51,7 @@ func NewExecutor(
 	for _, opt := range opts {
 		opt(e)
 	}
+	e.blocks.SetFlattenEvery(e.flattenEvery)
 
 	if e.prevBlockHash == common.HexToHash("") {
 		block, err := provider.BlockByNumber(ctx, cfg.ForkConfig.ForkBlock)
@@ -133,12 +138,56 @@ func (e *SerialExecutor) roll(
 
 	e.prevBlockHash = hash
 	e.prevBlockNum = block.Uint64()
-	e.txn.AddTrace(tx.Hash(), traceProvider.OtterTrace())
+	e.index(tx, msg.From, nonce, traceProvider.OtterTrace())
 
 	txHash := tx.Hash()
 	return &txHash
 }
 
+// index records the sender and traces of a mined transaction and adds it to the
+// lookups used by the otterscan and smelter apis. The transaction is looked up by
+// the nonce its sender had before it, the minted transactions carry nonce+1.
+func (e *SerialExecutor) index(tx *types.Transaction, from common.Address, nonce uint64, traces entity.TransactionTraces) {
+	e.txn.AddTrace(tx.Hash(), traces)
+	e.txn.AddSender(tx.Hash(), from)
+	e.txn.AddSenderNonce(from, nonce, tx.Hash())
+	e.indexCreations(tx.Hash(), traces)
+	e.indexAccounts(tx, from, traces)
+}
+
+// indexCreations records the creator of every contract deployed by the transaction.
+func (e *SerialExecutor) indexCreations(txHash common.Hash, traces entity.TransactionTraces) {
+	for _, trace := range traces {
+		if (trace.Type != "CREATE" && trace.Type != "CREATE2") || trace.Reverted {
+			continue
+		}
+
+		e.txn.AddContractCreator(common.HexToAddress(trace.To), &entity.ContractCreator{
+			Hash:    txHash,
+			Creator: common.HexToAddress(trace.From),
+		})
+	}
+}
+
+// indexAccounts adds the transaction to the history of its sender, receiver and
+// of every account which took part in one of its calls, including created contracts.
+func (e *SerialExecutor) indexAccounts(tx *types.Transaction, from common.Address, traces entity.TransactionTraces) {
+	receipt := e.txn.GetReceipt(tx.Hash())
+	if receipt == nil {
+		return
+	}
+
+	addrs := append([]common.Address{from}, traces.Participants()...)
+	if tx.To() != nil {
+		addrs = append(addrs, *tx.To())
+	}
+
+	e.txn.IndexAccounts(tx.Hash(), entity.TxPosition{
+		BlockNumber:      hexutil.Uint64(receipt.BlockNumber.Uint64()),
+		TransactionIndex: hexutil.Uint(receipt.TransactionIndex),
+	}, addrs...)
+}
+
 func (e *SerialExecutor) Call(
 	ctx context.Context,
 	tx ethereum.CallMsg,
@@ -172,25 +221,30 @@ func (e *SerialExecutor) Call(
 	return
 }
 
+// CallWithDB executes tx on db without persisting it, a nil db runs on top of the
+// session state. The block overrides replace fields of the default block env.
 func (e *SerialExecutor) CallWithDB(
 	ctx context.Context,
 	tx ethereum.CallMsg,
 	tracer entity.TraceProvider,
 	db *fork.DB,
 	overrides entity.StateOverrides,
+	blockOverrides *entity.BlockOverrides,
 ) (ret []byte, leftOverGas uint64, err error) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	if db == nil {
+		db = e.db
+	}
+
 	executionDB := statedb.NewDB(ctx, db)
 	if err = executionDB.ApplyOverrides(overrides); err != nil {
 		return nil, 0, err
 	}
 
 	chainCfg, evmCfg := e.cfg.ExecutionConfig(tracer.Hooks())
-	env := vm.NewEVM(e.cfg.BlockContext(new(big.Int).Add(e.cfg.ForkConfig.ForkBlock, new(big.Int).SetUint64(1)),
-		new(big.Int),
-		uint64(time.Now().Unix())),
+	env := vm.NewEVM(e.blockContext(blockOverrides),
 		executionDB,
 		chainCfg,
 		evmCfg)
@@ -206,6 +260,109 @@ func (e *SerialExecutor) CallWithDB(
 	return
 }
 
+// blockContext returns the block env of the next block with the overrides applied.
+func (e *SerialExecutor) blockContext(overrides *entity.BlockOverrides) vm.BlockContext {
+	blockCtx := e.cfg.BlockContext(new(big.Int).Add(e.cfg.ForkConfig.ForkBlock, new(big.Int).SetUint64(1)),
+		new(big.Int),
+		uint64(time.Now().Unix()))
+	if overrides == nil {
+		return blockCtx
+	}
+
+	if overrides.Number != nil {
+		blockCtx.BlockNumber = overrides.Number.ToInt()
+	}
+	if overrides.Time != nil {
+		blockCtx.Time = uint64(*overrides.Time)
+	}
+	if overrides.GasLimit != nil {
+		blockCtx.GasLimit = uint64(*overrides.GasLimit)
+	}
+	if overrides.FeeRecipient != nil {
+		blockCtx.Coinbase = *overrides.FeeRecipient
+	}
+	if overrides.BaseFeePerGas != nil {
+		blockCtx.BaseFee = overrides.BaseFeePerGas.ToInt()
+	}
+	if overrides.PrevRandao != nil {
+		random := *overrides.PrevRandao
+		blockCtx.Random = &random
+	}
+
+	return blockCtx
+}
+
+// Trace executes tx on db without persisting it, unlike CallWithDB it also drives
+// the transaction level tracing hooks which the geth tracers rely on. A nil db
+// runs on top of the session state and a nil header uses the default block env.
+// The execution is cancelled once ctx expires.
+func (e *SerialExecutor) Trace(
+	ctx context.Context,
+	tx ethereum.CallMsg,
+	tracer entity.TraceProvider,
+	db *fork.DB,
+	header *types.Header,
+	overrides entity.StateOverrides,
+) (ret []byte, leftOverGas uint64, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if db == nil {
+		db = e.db
+	}
+
+	executionDB := statedb.NewDB(ctx, db)
+	if err = executionDB.ApplyOverrides(overrides); err != nil {
+		return nil, 0, err
+	}
+
+	blockCtx := e.cfg.BlockContext(new(big.Int).Add(e.cfg.ForkConfig.ForkBlock, new(big.Int).SetUint64(1)),
+		new(big.Int),
+		uint64(time.Now().Unix()))
+	if header != nil {
+		blockCtx = e.cfg.BlockContext(header.Number, new(big.Int), header.Time)
+	}
+
+	hooks := tracer.Hooks()
+	chainCfg, evmCfg := e.cfg.ExecutionConfig(hooks)
+	env := vm.NewEVM(blockCtx, executionDB, chainCfg, evmCfg)
+	if deadline, ok := ctx.Deadline(); ok {
+		timer := time.AfterFunc(time.Until(deadline), env.Cancel)
+		defer timer.Stop()
+	}
+
+	if hooks.OnTxStart != nil {
+		hooks.OnTxStart(env.GetVMContext(), producer.NewTransactionContext(executionDB.GetNonce(tx.From), tx), tx.From)
+	}
+
+	value, _ := uint256.FromBig(tx.Value)
+	if tx.To == nil {
+		ret, _, leftOverGas, err = env.Create(tx.From, tx.Data, tx.Gas, value)
+	} else {
+		ret, leftOverGas, err = env.Call(
+			tx.From,
+			*tx.To,
+			tx.Data,
+			tx.Gas,
+			value,
+		)
+	}
+
+	if hooks.OnTxEnd != nil {
+		receipt := &types.Receipt{Status: types.ReceiptStatusSuccessful, GasUsed: tx.Gas - leftOverGas}
+		if err != nil {
+			receipt.Status = types.ReceiptStatusFailed
+		}
+		hooks.OnTxEnd(receipt, nil)
+	}
+
+	return
+}
+
+func (e *SerialExecutor) ChainConfig() *params.ChainConfig {
+	return e.cfg.ChainConfig
+}
+
 func (e *SerialExecutor) TxnStorage() *entity.TransactionStorage {
 	return e.txn
 }
@@ -214,6 +371,34 @@ func (e *SerialExecutor) BlockStorage() *entity.BlockStorage {
 	return e.blocks
 }
 
+// DB returns the state the executor persists transactions to.
+func (e *SerialExecutor) DB() *fork.DB {
+	return e.db
+}
+
+// Clone returns an executor with a copy of the state, the transactions and the
+// blocks, the clone evolves independently from e.
+func (e *SerialExecutor) Clone() *SerialExecutor {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	clone := &SerialExecutor{
+		db:            e.db.Clone(),
+		cfg:           e.cfg,
+		provider:      e.provider,
+		txn:           entity.NewTransactionStorage(),
+		blocks:        entity.NewBlockStorage(),
+		prevBlockHash: e.prevBlockHash,
+		prevBlockNum:  e.prevBlockNum,
+		flattenEvery:  e.flattenEvery,
+	}
+	clone.txn.Apply(e.txn)
+	clone.blocks.SetFlattenEvery(e.flattenEvery)
+	clone.blocks.Apply(e.blocks)
+
+	return clone
+}
+
 func (e *SerialExecutor) Latest() (common.Hash, uint64) {
 	return e.prevBlockHash, e.prevBlockNum
 }
@@ -10,3 +10,11 @@ func WithPreviousState(blockHash common.Hash, prevBlockNum uint64) Option {
 		se.prevBlockNum = prevBlockNum
 	}
 }
+
+// WithFlattenEvery makes every n-th stacked block keep a full copy of the state so
+// historical reads don't walk more than n layers, 0 never flattens.
+func WithFlattenEvery(n int) Option {
+	return func(se *SerialExecutor) {
+		se.flattenEvery = n
+	}
+}
@@ -0,0 +1,422 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/misc/eip1559"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/holiman/uint256"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/fork"
+	"github.com/raul0ligma/smelter/producer"
+	"github.com/raul0ligma/smelter/statedb"
+	"github.com/raul0ligma/smelter/tracer"
+	"github.com/raul0ligma/smelter/vm"
+)
+
+const (
+	// maxSimulateBlocks caps the blocks of a simulation, including the empty
+	// blocks filling the gaps between the requested block numbers
+	maxSimulateBlocks = 256
+	// timestampIncrement is the default time between two simulated blocks
+	timestampIncrement = 12
+
+	errCodeReverted = -32000
+	errCodeVMError  = -32015
+)
+
+// Simulate executes the block state calls of opts as a chain of blocks on top of
+// parent, every call sees the state left by the previous ones. The resulting state
+// is written into db so callers pass a throwaway copy of the session db.
+func (e *SerialExecutor) Simulate(
+	ctx context.Context,
+	db *fork.DB,
+	parent *types.Header,
+	opts entity.SimulateOpts,
+) ([]*entity.SimulatedBlock, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	blocks, err := sanitizeChain(parent, opts.BlockStateCalls)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*entity.SimulatedBlock, 0, len(blocks))
+	for _, block := range blocks {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		result, header, err := e.simulateBlock(ctx, db, parent, block, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, result)
+		parent = header
+	}
+
+	return results, nil
+}
+
+// sanitizeChain fills the default number and timestamp of every block, block
+// numbers and timestamps have to be strictly increasing and gaps between block
+// numbers are filled with empty blocks.
+func sanitizeChain(parent *types.Header, blocks []entity.SimBlock) ([]entity.SimBlock, error) {
+	var (
+		res        = make([]entity.SimBlock, 0, len(blocks))
+		base       = parent.Number.Uint64()
+		prevNumber = base
+		prevTime   = parent.Time
+	)
+	for _, block := range blocks {
+		overrides := entity.BlockOverrides{}
+		if block.BlockOverrides != nil {
+			overrides = *block.BlockOverrides
+		}
+
+		number := prevNumber + 1
+		if overrides.Number != nil {
+			if !overrides.Number.ToInt().IsUint64() {
+				return nil, fmt.Errorf("invalid block number %s", overrides.Number)
+			}
+			number = overrides.Number.ToInt().Uint64()
+		}
+
+		if number <= prevNumber {
+			return nil, fmt.Errorf("block numbers must be in order: %d <= %d", number, prevNumber)
+		}
+
+		if number-base > maxSimulateBlocks {
+			return nil, fmt.Errorf("too many blocks, at most %d can be simulated", maxSimulateBlocks)
+		}
+
+		for gap := prevNumber + 1; gap < number; gap++ {
+			timestamp := prevTime + timestampIncrement
+			res = append(res, entity.SimBlock{
+				BlockOverrides: &entity.BlockOverrides{
+					Number: (*hexutil.Big)(new(big.Int).SetUint64(gap)),
+					Time:   (*hexutil.Uint64)(&timestamp),
+				},
+			})
+			prevTime = timestamp
+		}
+
+		timestamp := prevTime + timestampIncrement
+		if overrides.Time != nil {
+			timestamp = uint64(*overrides.Time)
+			if timestamp <= prevTime {
+				return nil, fmt.Errorf("block timestamps must be in order: %d <= %d", timestamp, prevTime)
+			}
+		}
+
+		overrides.Number = (*hexutil.Big)(new(big.Int).SetUint64(number))
+		overrides.Time = (*hexutil.Uint64)(&timestamp)
+		block.BlockOverrides = &overrides
+		res = append(res, block)
+
+		prevNumber, prevTime = number, timestamp
+	}
+
+	return res, nil
+}
+
+func (e *SerialExecutor) simulateBlock(
+	ctx context.Context,
+	db *fork.DB,
+	parent *types.Header,
+	block entity.SimBlock,
+	opts entity.SimulateOpts,
+) (*entity.SimulatedBlock, *types.Header, error) {
+	header := e.simulatedHeader(parent, block.BlockOverrides, opts.Validation)
+
+	executionDB := statedb.NewDB(ctx, db)
+	if err := executionDB.ApplyOverrides(block.StateOverrides); err != nil {
+		return nil, nil, err
+	}
+
+	simTracer := tracer.NewSimulateTracer(opts.TraceTransfers, header.Number.Uint64())
+	hooks := simTracer.Hooks()
+	executionDB.SetLogHook(hooks.OnLog)
+
+	blockCtx := e.cfg.BlockContext(header.Number, header.BaseFee, header.Time)
+	blockCtx.Coinbase = header.Coinbase
+	blockCtx.GasLimit = header.GasLimit
+	blockCtx.Random = &header.MixDigest
+
+	chainCfg, evmCfg := e.cfg.ExecutionConfig(hooks)
+	env := vm.NewEVM(blockCtx, executionDB, chainCfg, evmCfg)
+	if deadline, ok := ctx.Deadline(); ok {
+		timer := time.AfterFunc(time.Until(deadline), env.Cancel)
+		defer timer.Stop()
+	}
+
+	var (
+		gasUsed  uint64
+		txs      = make(types.Transactions, 0, len(block.Calls))
+		receipts = make(types.Receipts, 0, len(block.Calls))
+		senders  = make([]common.Address, 0, len(block.Calls))
+		calls    = make([]entity.SimulatedCall, 0, len(block.Calls))
+	)
+	for i, args := range block.Calls {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		msg, nonce, err := simulatedMsg(executionDB, header, args, gasUsed, opts.Validation)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tx := producer.NewTransactionContext(nonce, msg)
+		simTracer.Reset(tx.Hash(), uint(i))
+		env.SetTxContext(vm.TxContext{Origin: msg.From, GasPrice: msg.GasPrice})
+
+		receipt := &types.Receipt{
+			Type:              tx.Type(),
+			Status:            types.ReceiptStatusSuccessful,
+			TxHash:            tx.Hash(),
+			EffectiveGasPrice: msg.GasPrice,
+			BlockNumber:       header.Number,
+			TransactionIndex:  uint(i),
+		}
+
+		var (
+			ret   []byte
+			left  uint64
+			vmErr error
+		)
+		value, _ := uint256.FromBig(msg.Value)
+		if msg.To == nil {
+			ret, receipt.ContractAddress, left, vmErr = env.Create(msg.From, msg.Data, msg.Gas, value)
+		} else {
+			executionDB.SetNonce(msg.From, nonce+1, tracing.NonceChangeUnspecified)
+			ret, left, vmErr = env.Call(msg.From, *msg.To, msg.Data, msg.Gas, value)
+		}
+
+		used := msg.Gas - left
+		if opts.Validation {
+			chargeGas(executionDB, header.Coinbase, header.BaseFee, msg, used)
+		}
+
+		gasUsed += used
+		call := entity.SimulatedCall{
+			ReturnData: ret,
+			Logs:       simTracer.Logs(),
+			GasUsed:    hexutil.Uint64(used),
+			Status:     hexutil.Uint64(types.ReceiptStatusSuccessful),
+		}
+		if vmErr != nil {
+			receipt.Status = types.ReceiptStatusFailed
+			call.Status = hexutil.Uint64(types.ReceiptStatusFailed)
+			call.Error = simulatedCallError(vmErr, ret)
+		}
+
+		receipt.GasUsed = used
+		receipt.CumulativeGasUsed = gasUsed
+		receipt.Logs = call.Logs
+		receipt.Bloom = types.CreateBloom(receipt)
+
+		txs = append(txs, tx)
+		receipts = append(receipts, receipt)
+		senders = append(senders, msg.From)
+		calls = append(calls, call)
+	}
+
+	db.ApplyStorage(executionDB.Dirty().GetAccountStorage())
+	db.ApplyState(executionDB.Dirty().GetAccountState())
+
+	header.GasUsed = gasUsed
+	b := entity.NewBlockWithHeader(header, txs, receipts)
+	for _, receipt := range receipts {
+		receipt.BlockHash = b.Hash()
+		for _, log := range receipt.Logs {
+			log.BlockHash = b.Hash()
+		}
+	}
+
+	serialized := entity.SerializeBlock(b)
+	serialized.Miner = header.Coinbase.Hex()
+	serialized.MixHash = header.MixDigest.Hex()
+	result := &entity.SimulatedBlock{
+		SerializedBlock: serialized,
+		Transactions:    make([]any, 0, len(txs)),
+		Calls:           calls,
+	}
+	for i, tx := range txs {
+		if opts.ReturnFullTransactions {
+			result.Transactions = append(result.Transactions, entity.SerializeTransaction(tx, receipts[i], senders[i]))
+			continue
+		}
+
+		result.Transactions = append(result.Transactions, tx.Hash())
+	}
+
+	return result, b.Header(), nil
+}
+
+// simulatedHeader prepares the header of a simulated block, without validation
+// the base fee defaults to zero so calls without a gas price don't fail.
+func (e *SerialExecutor) simulatedHeader(parent *types.Header, overrides *entity.BlockOverrides, validate bool) *types.Header {
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		UncleHash:  types.EmptyUncleHash,
+		Coinbase:   parent.Coinbase,
+		Difficulty: new(big.Int),
+		Number:     overrides.Number.ToInt(),
+		GasLimit:   parent.GasLimit,
+		Time:       uint64(*overrides.Time),
+		BaseFee:    new(big.Int),
+	}
+	if header.GasLimit == 0 {
+		header.GasLimit = e.cfg.GasLimit
+	}
+
+	if validate && parent.BaseFee != nil {
+		header.BaseFee = eip1559.CalcBaseFee(e.cfg.ChainConfig, parent)
+	}
+
+	if overrides.GasLimit != nil {
+		header.GasLimit = uint64(*overrides.GasLimit)
+	}
+	if overrides.FeeRecipient != nil {
+		header.Coinbase = *overrides.FeeRecipient
+	}
+	if overrides.PrevRandao != nil {
+		header.MixDigest = *overrides.PrevRandao
+	}
+	if overrides.BaseFeePerGas != nil {
+		header.BaseFee = overrides.BaseFeePerGas.ToInt()
+	}
+
+	return header
+}
+
+// simulatedMsg fills the defaults of a simulated call, calls without gas use the
+// gas left in the block. With validation the nonce, fee cap and funds of the
+// sender are checked like for a real transaction.
+func simulatedMsg(
+	db *statedb.StateDB,
+	header *types.Header,
+	args entity.TransactionArgs,
+	gasUsed uint64,
+	validate bool,
+) (ethereum.CallMsg, uint64, error) {
+	msg := ethereum.CallMsg{
+		To:       args.To,
+		Data:     args.CallData(),
+		Value:    new(big.Int),
+		GasPrice: new(big.Int),
+	}
+	if args.From != nil {
+		msg.From = *args.From
+	}
+	if args.Value != nil {
+		msg.Value = args.Value.ToInt()
+	}
+
+	remaining := header.GasLimit - gasUsed
+	msg.Gas = remaining
+	if args.Gas != nil {
+		if uint64(*args.Gas) > remaining {
+			return msg, 0, fmt.Errorf("block gas limit reached: %d > %d", uint64(*args.Gas), remaining)
+		}
+		msg.Gas = uint64(*args.Gas)
+	}
+
+	feeCap := new(big.Int)
+	switch {
+	case args.GasPrice != nil && (args.MaxFeePerGas != nil || args.MaxPriorityFeePerGas != nil):
+		return msg, 0, errors.New("both gasPrice and (maxFeePerGas or maxPriorityFeePerGas) specified")
+	case args.GasPrice != nil:
+		msg.GasPrice = args.GasPrice.ToInt()
+		feeCap = msg.GasPrice
+	case args.MaxFeePerGas != nil || args.MaxPriorityFeePerGas != nil:
+		tip := new(big.Int)
+		if args.MaxPriorityFeePerGas != nil {
+			tip = args.MaxPriorityFeePerGas.ToInt()
+		}
+
+		feeCap = new(big.Int).Add(header.BaseFee, tip)
+		if args.MaxFeePerGas != nil {
+			feeCap = args.MaxFeePerGas.ToInt()
+		}
+
+		if feeCap.Cmp(tip) < 0 {
+			return msg, 0, fmt.Errorf("maxFeePerGas (%s) < maxPriorityFeePerGas (%s)", feeCap, tip)
+		}
+
+		msg.GasFeeCap, msg.GasTipCap = feeCap, tip
+		msg.GasPrice = new(big.Int).Add(header.BaseFee, tip)
+		if msg.GasPrice.Cmp(feeCap) > 0 {
+			msg.GasPrice = feeCap
+		}
+	}
+
+	nonce := db.GetNonce(msg.From)
+	if args.Nonce != nil {
+		if validate && uint64(*args.Nonce) < nonce {
+			return msg, 0, fmt.Errorf("nonce too low: address %s, tx: %d state: %d", msg.From.Hex(), uint64(*args.Nonce), nonce)
+		}
+		if validate && uint64(*args.Nonce) > nonce {
+			return msg, 0, fmt.Errorf("nonce too high: address %s, tx: %d state: %d", msg.From.Hex(), uint64(*args.Nonce), nonce)
+		}
+		nonce = uint64(*args.Nonce)
+	}
+
+	if !validate {
+		return msg, nonce, nil
+	}
+
+	if feeCap.Cmp(header.BaseFee) < 0 {
+		return msg, 0, fmt.Errorf("max fee per gas less than block base fee: address %s, maxFeePerGas: %s, baseFee: %s",
+			msg.From.Hex(), feeCap, header.BaseFee)
+	}
+
+	cost := new(big.Int).Mul(new(big.Int).SetUint64(msg.Gas), feeCap)
+	cost.Add(cost, msg.Value)
+	if balance := db.GetBalance(msg.From).ToBig(); balance.Cmp(cost) < 0 {
+		return msg, 0, fmt.Errorf("insufficient funds for gas * price + value: address %s have %s want %s",
+			msg.From.Hex(), balance, cost)
+	}
+
+	return msg, nonce, nil
+}
+
+// chargeGas takes the fee of the used gas from the sender and pays the tip to
+// the fee recipient, the base fee is burnt.
+func chargeGas(db *statedb.StateDB, coinbase common.Address, baseFee *big.Int, msg ethereum.CallMsg, used uint64) {
+	fee, _ := uint256.FromBig(new(big.Int).Mul(new(big.Int).SetUint64(used), msg.GasPrice))
+	db.SubBalance(msg.From, fee, tracing.BalanceDecreaseGasBuy)
+
+	tip := new(big.Int).Sub(msg.GasPrice, baseFee)
+	if tip.Sign() <= 0 {
+		return
+	}
+
+	reward, _ := uint256.FromBig(tip.Mul(tip, new(big.Int).SetUint64(used)))
+	db.AddBalance(coinbase, reward, tracing.BalanceIncreaseRewardTransactionFee)
+}
+
+func simulatedCallError(err error, ret []byte) *entity.SimulatedCallError {
+	if !errors.Is(err, vm.ErrExecutionReverted) {
+		return &entity.SimulatedCallError{Code: errCodeVMError, Message: err.Error()}
+	}
+
+	message := err.Error()
+	if reason, unpackErr := abi.UnpackRevert(ret); unpackErr == nil {
+		message += ": " + reason
+	}
+
+	return &entity.SimulatedCallError{Code: errCodeReverted, Message: message, Data: hexutil.Encode(ret)}
+}
@@ -0,0 +1,127 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/raul0ligma/smelter/entity"
+)
+
+// Dump returns the session state along with the local blocks and transactions,
+// the accounts the session only holds in part are completed from the fork.
+func (e *SerialExecutor) Dump(ctx context.Context) (*entity.StateDump, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	blocks, err := e.blocks.Dump()
+	if err != nil {
+		return nil, err
+	}
+
+	txs, err := e.txn.Dump()
+	if err != nil {
+		return nil, err
+	}
+
+	forkCfg := *e.cfg.ForkConfig
+	storage, state := e.db.Copy()
+	accounts := entity.DumpAccounts(storage, state)
+	if err = e.complete(ctx, accounts); err != nil {
+		return nil, err
+	}
+
+	return &entity.StateDump{
+		Accounts:        accounts,
+		BestBlockNumber: hexutil.Uint64(e.prevBlockNum),
+		Fork:            &forkCfg,
+		Smelter: &entity.ChainDump{
+			Blocks:       blocks,
+			Transactions: txs,
+		},
+	}, nil
+}
+
+// complete fills the balance, nonce and code missing from the accounts with the
+// values of the session, anvil rejects accounts without them.
+func (e *SerialExecutor) complete(ctx context.Context, accounts entity.AccountsDump) error {
+	for addr, acc := range accounts {
+		if acc.Balance != nil && acc.Code != nil {
+			continue
+		}
+
+		nonce, balance, code, err := e.db.Account(ctx, addr)
+		if err != nil {
+			return fmt.Errorf("account %s: %w", addr.Hex(), err)
+		}
+
+		if acc.Balance == nil {
+			acc.Nonce, acc.Balance = nonce, (*hexutil.Big)(balance)
+		}
+		if acc.Code == nil {
+			encoded := hexutil.Bytes(code)
+			acc.Code = &encoded
+		}
+	}
+
+	return nil
+}
+
+// Load merges the dumped accounts into the session state and adds the dumped
+// blocks and transactions, the latest block moves to the latest dumped block when
+// it's ahead.
+func (e *SerialExecutor) Load(ctx context.Context, dump *entity.StateDump) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// accounts dumped in part keep the session values of the missing parts
+	accounts := make(entity.AccountsDump, len(dump.Accounts))
+	for addr, acc := range dump.Accounts {
+		copied := *acc
+		accounts[addr] = &copied
+	}
+	if err := e.complete(ctx, accounts); err != nil {
+		return err
+	}
+
+	storage, state := accounts.Load()
+	e.db.ApplyStorage(storage)
+	e.db.ApplyState(state)
+
+	if dump.Smelter == nil {
+		return nil
+	}
+
+	for _, v := range dump.Smelter.Blocks {
+		block, err := v.Load()
+		if err != nil {
+			return err
+		}
+
+		e.blocks.AddBlockChanges(block.Block, block.Accounts, block.State)
+		if block.Block.NumberU64() > e.prevBlockNum {
+			e.prevBlockNum = block.Block.NumberU64()
+			e.prevBlockHash = block.Block.Hash()
+		}
+	}
+
+	for _, v := range dump.Smelter.Transactions {
+		tx, traces, err := v.Load()
+		if err != nil {
+			return err
+		}
+
+		nonce := tx.Nonce()
+		if v.Nonce != nil {
+			nonce = uint64(*v.Nonce)
+		}
+
+		e.txn.AddTransaction(tx)
+		if v.Receipt != nil {
+			e.txn.AddReceipt(v.Receipt)
+		}
+		e.index(tx, v.Sender, nonce, traces)
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/statedb"
+	"github.com/raul0ligma/smelter/tracer"
+	"github.com/raul0ligma/smelter/vm"
+)
+
+var ErrSlotNotFound = errors.New("no storage slot holds the returned value")
+
+// probeValue is written into the candidate slots, it is large enough to be told
+// apart from real values and small enough not to overflow getter arithmetic.
+var probeValue = common.BigToHash(new(big.Int).SetUint64(0x1337c0ffee1337))
+
+// StorageSlot finds the slot holding the value returned by the getter call, the
+// value is the current content of the slot.
+func (e *SerialExecutor) StorageSlot(
+	ctx context.Context,
+	tx ethereum.CallMsg,
+	overrides entity.StateOverrides,
+) (*entity.StorageSlot, error) {
+	candidates, err := e.FindStorageSlots(ctx, tx, overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) == 0 || !candidates[0].Matches {
+		return nil, ErrSlotNotFound
+	}
+
+	return &candidates[0].StorageSlot, nil
+}
+
+// FindStorageSlots returns the slots read by the getter call ranked by how likely
+// they back its return value. Every slot is probed by writing a marker value and
+// then its complement, slots returned as is rank first followed by those changing
+// the return, ties are broken by the latest read.
+func (e *SerialExecutor) FindStorageSlots(
+	ctx context.Context,
+	tx ethereum.CallMsg,
+	overrides entity.StateOverrides,
+) ([]*entity.SlotCandidate, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	reads := tracer.NewSloadTracer()
+	executionDB, ret, err := e.callOnScratch(ctx, tx, reads, overrides, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	slots := reads.Reads()
+	candidates := make([]*entity.SlotCandidate, 0, len(slots))
+	for i := len(slots) - 1; i >= 0; i-- {
+		candidate := &entity.SlotCandidate{StorageSlot: slots[i]}
+		candidate.Value = executionDB.GetState(candidate.Address, candidate.Slot)
+
+		for _, probe := range []common.Hash{probeValue, complement(candidate.Value)} {
+			slot := entity.StorageSlot{Address: candidate.Address, Slot: candidate.Slot, Value: probe}
+			_, probed, err := e.callOnScratch(ctx, tx, tracer.NewSloadTracer(), overrides, &slot)
+			if err != nil || bytes.Equal(probed, ret) {
+				continue
+			}
+
+			candidate.Probe = &probe
+			candidate.Return = probed
+			candidate.Matches = len(probed) >= common.HashLength && bytes.Equal(probed[:common.HashLength], probe.Bytes())
+			break
+		}
+
+		candidates = append(candidates, candidate)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Rank() < candidates[j].Rank()
+	})
+
+	return candidates, nil
+}
+
+func complement(value common.Hash) common.Hash {
+	for i := range value {
+		value[i] = ^value[i]
+	}
+	return value
+}
+
+// SetStorageAt writes value into the slot of addr in the session state.
+func (e *SerialExecutor) SetStorageAt(ctx context.Context, addr common.Address, slot, value common.Hash) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.db.SetState(ctx, addr, slot, value)
+}
+
+// callOnScratch executes tx on a scratch state over the session db, the probe slot
+// is written before the call.
+func (e *SerialExecutor) callOnScratch(
+	ctx context.Context,
+	tx ethereum.CallMsg,
+	traceProvider entity.TraceProvider,
+	overrides entity.StateOverrides,
+	probe *entity.StorageSlot,
+) (*statedb.StateDB, []byte, error) {
+	executionDB := statedb.NewDB(ctx, e.db)
+	if err := executionDB.ApplyOverrides(overrides); err != nil {
+		return nil, nil, err
+	}
+
+	if probe != nil {
+		executionDB.SetState(probe.Address, probe.Slot, probe.Value)
+	}
+
+	chainCfg, evmCfg := e.cfg.ExecutionConfig(traceProvider.Hooks())
+	env := vm.NewEVM(e.blockContext(nil), executionDB, chainCfg, evmCfg)
+	value, _ := uint256.FromBig(tx.Value)
+	ret, _, err := env.Call(tx.From, *tx.To, tx.Data, tx.Gas, value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return executionDB, ret, nil
+}
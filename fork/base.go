@@ -0,0 +1,162 @@
+package fork
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/raul0ligma/smelter/entity"
+	"golang.org/x/sync/singleflight"
+)
+
+// Base is the read only layer of upstream values shared by the sessions of a
+// chain, every fork block has its own layer. Sessions keep their writes in their
+// own storage on top of it, concurrent misses of the same account or slot cost a
+// single upstream call.
+type Base struct {
+	stateReader entity.BatchedStateReader
+	mu          sync.RWMutex
+	layers      map[uint64]*baseLayer
+	group       singleflight.Group
+}
+
+// fetchTimeout bounds an upstream call shared by concurrent misses, it runs apart
+// from the request which started it.
+const fetchTimeout = 30 * time.Second
+
+type baseLayer struct {
+	accountStorage *entity.AccountsStorage
+	accountState   *entity.AccountsState
+}
+
+func NewBase(stateReader entity.BatchedStateReader) *Base {
+	return &Base{
+		stateReader: stateReader,
+		layers:      make(map[uint64]*baseLayer),
+	}
+}
+
+func (b *Base) layer(block uint64) *baseLayer {
+	b.mu.RLock()
+	layer, ok := b.layers[block]
+	b.mu.RUnlock()
+	if ok {
+		return layer
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if layer, ok = b.layers[block]; !ok {
+		layer = &baseLayer{
+			accountStorage: entity.NewAccountsStorage(),
+			accountState:   entity.NewAccountsState(),
+		}
+		b.layers[block] = layer
+	}
+
+	return layer
+}
+
+// Account returns the nonce, balance and code of the account at block, the code
+// is shared and must not be modified.
+func (b *Base) Account(ctx context.Context, block *big.Int, addr common.Address) (uint64, *big.Int, []byte, error) {
+	layer := b.layer(block.Uint64())
+	if !layer.accountState.Exists(addr) {
+		_, err := b.do(ctx, fmt.Sprintf("%d:%s", block.Uint64(), addr.Hex()), func(ctx context.Context) (any, error) {
+			if layer.accountState.Exists(addr) {
+				return nil, nil
+			}
+
+			nonce, balance, code, err := fetchAccount(ctx, b.stateReader, block, addr)
+			if err != nil {
+				return nil, err
+			}
+
+			// the storage goes first so an existing state implies an existing storage
+			layer.accountStorage.NewAccount(addr, code)
+			layer.accountState.NewAccount(addr, nonce, balance)
+			return nil, nil
+		})
+		if err != nil {
+			return 0, nil, nil, err
+		}
+	}
+
+	balance := new(big.Int).Set(layer.accountState.GetBalance(addr))
+	return layer.accountState.GetNonce(addr), balance, layer.accountStorage.GetCode(addr), nil
+}
+
+// Slot returns the value of a storage slot at block.
+func (b *Base) Slot(ctx context.Context, block *big.Int, addr common.Address, key common.Hash) (common.Hash, error) {
+	if _, _, _, err := b.Account(ctx, block, addr); err != nil {
+		return common.Hash{}, err
+	}
+
+	layer := b.layer(block.Uint64())
+	if value, ok := layer.accountStorage.LookupStorage(addr, key); ok {
+		return value, nil
+	}
+
+	v, err := b.do(ctx, fmt.Sprintf("%d:%s:%s", block.Uint64(), addr.Hex(), key.Hex()), func(ctx context.Context) (any, error) {
+		if value, ok := layer.accountStorage.LookupStorage(addr, key); ok {
+			return value, nil
+		}
+
+		raw, err := b.stateReader.StorageAt(ctx, addr, key, block)
+		if err != nil {
+			return nil, err
+		}
+
+		value := common.BytesToHash(raw)
+		layer.accountStorage.SetStorage(addr, key, value)
+		return value, nil
+	})
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	return v.(common.Hash), nil
+}
+
+// do runs fn once for the concurrent callers of key. The upstream call isn't tied
+// to the caller which started it so its cancellation doesn't fail the others,
+// every caller stops waiting once its own context is done.
+func (b *Base) do(ctx context.Context, key string, fn func(ctx context.Context) (any, error)) (any, error) {
+	ch := b.group.DoChan(key, func() (any, error) {
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), fetchTimeout)
+		defer cancel()
+		return fn(fetchCtx)
+	})
+
+	select {
+	case res := <-ch:
+		return res.Val, res.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Retain drops the layers of the fork blocks no session is forked from anymore.
+func (b *Base) Retain(blocks map[uint64]bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for block := range b.layers {
+		if !blocks[block] {
+			delete(b.layers, block)
+		}
+	}
+}
+
+// AddSize adds the accounts, slots and code of every layer to size.
+func (b *Base) AddSize(size *entity.SessionSize) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, layer := range b.layers {
+		size.AddState(layer.accountStorage, layer.accountState)
+	}
+}
@@ -13,9 +13,11 @@ import (
 
 type DB struct {
 	stateReader    entity.BatchedStateReader
+	base           *Base
 	config         entity.ForkConfig
 	accountStorage *entity.AccountsStorage
 	accountState   *entity.AccountsState
+	changes        *entity.Changes
 }
 
 func NewDB(
@@ -29,9 +31,23 @@ func NewDB(
 		config:         config,
 		accountStorage: accountStorage,
 		accountState:   accountState,
+		changes:        entity.NewChanges(),
 	}
 }
 
+// NewLayeredDB returns a db reading the upstream through base, only the accounts
+// the session touches and the slots it writes are kept in its own storage.
+func NewLayeredDB(
+	base *Base,
+	config entity.ForkConfig,
+	accountStorage *entity.AccountsStorage,
+	accountState *entity.AccountsState,
+) *DB {
+	db := NewDB(base.stateReader, config, accountStorage, accountState)
+	db.base = base
+	return db
+}
+
 func (db *DB) Config() entity.ForkConfig {
 	return db.config
 }
@@ -41,43 +57,91 @@ func (db *DB) CreateState(ctx context.Context, addr common.Address) error {
 		return nil
 	}
 
-	if db.stateReader.SupportsBatching() {
+	nonce, bal, code, err := db.fetch(ctx, addr)
+	if err != nil {
+		return err
+	}
+
+	db.CreateStateWithValues(addr, nonce, bal, code)
+	return nil
+}
+
+// fetch reads the account at the fork block, through the base layer when set.
+func (db *DB) fetch(ctx context.Context, addr common.Address) (uint64, *big.Int, []byte, error) {
+	if db.layered() {
+		return db.base.Account(ctx, db.config.ForkBlock, addr)
+	}
+
+	return fetchAccount(ctx, db.stateReader, db.config.ForkBlock, addr)
+}
+
+// Account returns the nonce, balance and code of the account without caching it,
+// the parts the db doesn't hold yet are read from the fork.
+func (db *DB) Account(ctx context.Context, addr common.Address) (uint64, *big.Int, []byte, error) {
+	code, hasCode := db.accountStorage.LookupCode(addr)
+	hasState := db.accountState.Exists(addr)
+	if !hasCode || !hasState {
+		nonce, bal, forkCode, err := db.fetch(ctx, addr)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if !hasCode {
+			code = forkCode
+		}
+		if !hasState {
+			return nonce, bal, code, nil
+		}
+	}
+
+	return db.accountState.GetNonce(addr), new(big.Int).Set(db.accountState.GetBalance(addr)), code, nil
+}
+
+func (db *DB) layered() bool {
+	return db.base != nil && db.config.ForkBlock != nil
+}
+
+// fetchAccount reads the code, balance and nonce of the account at block, in a
+// single batch when the reader supports it.
+func fetchAccount(
+	ctx context.Context,
+	stateReader entity.BatchedStateReader,
+	block *big.Int,
+	addr common.Address,
+) (uint64, *big.Int, []byte, error) {
+	if stateReader.SupportsBatching() {
 		requests := []entity.BatchReq{
-			{Method: provider.MethodCodeAt, Params: []any{addr, db.config.ForkBlock}},
-			{Method: provider.MethodBalanceAt, Params: []any{addr, db.config.ForkBlock}},
-			{Method: provider.MethodNonceAt, Params: []any{addr, db.config.ForkBlock}},
+			{Method: provider.MethodCodeAt, Params: []any{addr, block}},
+			{Method: provider.MethodBalanceAt, Params: []any{addr, block}},
+			{Method: provider.MethodNonceAt, Params: []any{addr, block}},
 		}
 
 		var code hexutil.Bytes
 		var balance hexutil.Big
 		var nonce hexutil.Uint64
 
-		if err := db.stateReader.BatchWithUnmarshal(context.Background(), requests, []any{&code, &balance, &nonce}); err != nil {
-			return err
+		if err := stateReader.BatchWithUnmarshal(ctx, requests, []any{&code, &balance, &nonce}); err != nil {
+			return 0, nil, nil, err
 		}
 
-		db.CreateStateWithValues(addr, uint64(nonce), (*big.Int)(&balance), code)
-		return nil
-
+		return uint64(nonce), (*big.Int)(&balance), code, nil
 	}
 
-	code, err := db.stateReader.CodeAt(ctx, addr, db.config.ForkBlock)
+	code, err := stateReader.CodeAt(ctx, addr, block)
 	if err != nil {
-		return err
+		return 0, nil, nil, err
 	}
 
-	bal, err := db.stateReader.BalanceAt(ctx, addr, db.config.ForkBlock)
+	bal, err := stateReader.BalanceAt(ctx, addr, block)
 	if err != nil {
-		return err
+		return 0, nil, nil, err
 	}
 
-	nonce, err := db.stateReader.NonceAt(ctx, addr, db.config.ForkBlock)
+	nonce, err := stateReader.NonceAt(ctx, addr, block)
 	if err != nil {
-		return err
+		return 0, nil, nil, err
 	}
 
-	db.CreateStateWithValues(addr, nonce, bal, code)
-	return nil
+	return nonce, bal, code, nil
 }
 
 func (db *DB) CreateStateWithValues(addr common.Address, nonce uint64, bal *big.Int, code []byte) {
@@ -93,6 +157,7 @@ func (db *DB) LoadSlots(ctx context.Context, slots entity.Slots) {
 
 func (db *DB) SetCode(ctx context.Context, addr common.Address, code []byte) {
 	db.accountStorage.NewAccount(addr, code)
+	db.changes.Account(addr)
 }
 
 func (db *DB) State(ctx context.Context, addr common.Address) (*entity.AccountState, *entity.AccountStorage, error) {
@@ -117,6 +182,7 @@ func (db *DB) SetBalance(ctx context.Context, addr common.Address, amount *big.I
 	}
 
 	db.accountState.SetBalance(addr, amount)
+	db.changes.Account(addr)
 	return nil
 }
 
@@ -133,6 +199,7 @@ func (db *DB) SetNonce(ctx context.Context, addr common.Address, nonce uint64) e
 		return err
 	}
 	db.accountState.SetNonce(addr, nonce)
+	db.changes.Account(addr)
 	return nil
 }
 
@@ -166,11 +233,15 @@ func (db *DB) GetState(ctx context.Context, addr common.Address, hash common.Has
 	if err := db.CreateState(ctx, addr); err != nil {
 		return common.Hash{}, err
 	}
-	emptyHash := common.Hash{}
-	val := db.accountStorage.ReadStorage(addr, hash)
-	if val != emptyHash {
+	// a slot zeroed locally is held as well, it must not be read from the fork
+	if val, ok := db.accountStorage.LookupStorage(addr, hash); ok {
 		return val, nil
 	}
+	if db.layered() {
+		// the session storage only holds the written slots, the rest is shared
+		return db.base.Slot(ctx, db.config.ForkBlock, addr, hash)
+	}
+
 	raw, err := db.stateReader.StorageAt(ctx, addr, hash, db.config.ForkBlock)
 	if err != nil {
 		return common.Hash{}, err
@@ -180,14 +251,48 @@ func (db *DB) GetState(ctx context.Context, addr common.Address, hash common.Has
 	return common.BytesToHash(raw), nil
 }
 
+// SetState writes the value of a storage slot, the account is loaded first so the
+// rest of its state is kept.
+func (db *DB) SetState(ctx context.Context, addr common.Address, key, value common.Hash) error {
+	if err := db.CreateState(ctx, addr); err != nil {
+		return err
+	}
+
+	db.accountStorage.SetStorage(addr, key, value)
+	db.changes.Slot(addr, key)
+	return nil
+}
+
 func (db *DB) ApplyState(s *entity.AccountsState) {
+	db.changes.State(s)
 	db.accountState.Apply(s)
 }
 
 func (db *DB) ApplyStorage(s *entity.AccountsStorage) {
+	db.changes.Storage(s)
 	db.accountStorage.Apply(s)
 }
 
+// Diff returns the accounts and slots written since the last call, a mined block
+// keeps them as its changes on top of the previous block.
+func (db *DB) Diff() (*entity.AccountsStorage, *entity.AccountsState) {
+	return db.changes.Take(db.accountStorage, db.accountState)
+}
+
 func (db *DB) Copy() (*entity.AccountsStorage, *entity.AccountsState) {
 	return entity.NewAccountsStorageWitStorage(db.accountStorage.Clone()), entity.NewAccountsStateWithStorage(db.accountState.Clone())
 }
+
+// Clone returns a db reading from the same fork with a copy of the cached state.
+func (db *DB) Clone() *DB {
+	storage, state := db.Copy()
+	clone := NewDB(db.stateReader, db.config, storage, state)
+	clone.base = db.base
+	clone.changes = db.changes.Clone()
+	return clone
+}
+
+// AddSize adds the cached accounts, slots and code to size.
+func (db *DB) AddSize(size *entity.SessionSize) {
+	size.AddState(db.accountStorage, db.accountState)
+}
@@ -0,0 +1,113 @@
+// Package hdwallet derives the keys of a BIP-39 mnemonic along BIP-32 paths.
+package hdwallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var errInvalidKey = errors.New("derived key is invalid")
+
+// Seed returns the BIP-39 seed of a mnemonic, the words are not checked against
+// the wordlist so only their count is validated.
+func Seed(mnemonic, passphrase string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	switch len(words) {
+	case 12, 15, 18, 21, 24:
+	default:
+		return nil, fmt.Errorf("mnemonic has %d words", len(words))
+	}
+
+	return pbkdf2.Key([]byte(strings.Join(words, " ")), []byte("mnemonic"+passphrase), 2048, 64, sha512.New), nil
+}
+
+// DeriveKeys returns the keys of the first count accounts of the mnemonic on the
+// default path m/44'/60'/0'/0/i.
+func DeriveKeys(mnemonic, passphrase string, count int) ([]*ecdsa.PrivateKey, error) {
+	seed, err := Seed(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*ecdsa.PrivateKey, 0, count)
+	for i := 0; i < count; i++ {
+		path := make(accounts.DerivationPath, len(accounts.DefaultBaseDerivationPath))
+		copy(path, accounts.DefaultBaseDerivationPath)
+		path[len(path)-1] = uint32(i)
+
+		key, err := Derive(seed, path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// Derive returns the private key of the seed at path.
+func Derive(seed []byte, path accounts.DerivationPath) (*ecdsa.PrivateKey, error) {
+	key, chainCode := split(hmacSHA512([]byte("Bitcoin seed"), seed))
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	n := crypto.S256().Params().N
+	for _, index := range path {
+		data := make([]byte, 0, 37)
+		if index >= 0x80000000 {
+			data = append(data, 0)
+			data = append(data, key...)
+		} else {
+			priv, err := crypto.ToECDSA(key)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, crypto.CompressPubkey(&priv.PublicKey)...)
+		}
+		data = binary.BigEndian.AppendUint32(data, index)
+
+		var tweak []byte
+		tweak, chainCode = split(hmacSHA512(chainCode, data))
+		if err := checkKey(tweak); err != nil {
+			return nil, err
+		}
+
+		child := new(big.Int).Add(new(big.Int).SetBytes(tweak), new(big.Int).SetBytes(key))
+		child.Mod(child, n)
+		key = child.FillBytes(make([]byte, 32))
+		if err := checkKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return crypto.ToECDSA(key)
+}
+
+func checkKey(key []byte) error {
+	k := new(big.Int).SetBytes(key)
+	if k.Sign() == 0 || k.Cmp(crypto.S256().Params().N) >= 0 {
+		return errInvalidKey
+	}
+	return nil
+}
+
+func hmacSHA512(key, data []byte) []byte {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func split(sum []byte) ([]byte, []byte) {
+	return sum[:32], sum[32:]
+}
@@ -0,0 +1,30 @@
+package hdwallet
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestDeriveKeys(t *testing.T) {
+	// default accounts of hardhat and anvil
+	keys, err := DeriveKeys("test test test test test test test test test test test junk", "", 2)
+	if err != nil {
+		t.Fatalf("failed to derive keys: %v", err)
+	}
+
+	expected := []common.Address{
+		common.HexToAddress("0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"),
+		common.HexToAddress("0x70997970C51812dc3A010C7d01b50e0d17dc79C8"),
+	}
+	for i, key := range keys {
+		if addr := crypto.PubkeyToAddress(key.PublicKey); addr != expected[i] {
+			t.Fatalf("expected %s for account %d, got %s", expected[i], i, addr)
+		}
+	}
+
+	if _, err = DeriveKeys("test test", "", 1); err == nil {
+		t.Fatalf("expected an error for a short mnemonic")
+	}
+}
@@ -30,11 +30,9 @@ func MineBlockWithSingleTransaction(
 	txStore transactionStorage,
 	blockStore blockStorage,
 ) (common.Hash, *big.Int, error) {
-	blockNumber := new(big.Int).Add(prevBlockNumber, new(big.Int).SetUint64(1))
 	receipt := &types.Receipt{
-		Type:              tx.Type(),
-		Status:            1,
-		CumulativeGasUsed: tx.Gas() - left,
+		Type:   tx.Type(),
+		Status: 1,
 		// TODO: create logs bloom
 		Bloom:             types.Bloom{},
 		Logs:              db.Logs(),
@@ -42,21 +40,52 @@ func MineBlockWithSingleTransaction(
 		ContractAddress:   *tx.To(),
 		GasUsed:           tx.Gas() - left,
 		EffectiveGasPrice: tx.GasPrice(),
-		BlockNumber:       blockNumber,
-		TransactionIndex:  0,
 	}
 
-	block := entity.NewBlock(prevBlockHash, blockNumber, types.Transactions{tx}, types.Receipts{receipt}, receipt.GasUsed)
-	receipt.BlockHash = block.Hash()
-	txStore.AddTransaction(tx)
-	txStore.AddReceipt(receipt)
+	return MineBlock(types.Transactions{tx}, types.Receipts{receipt}, prevBlockNumber, prevBlockHash, fork, txStore, blockStore)
+}
 
-	accounts, state := fork.Copy()
-	blockStore.AddBlock(&entity.BlockState{
-		Accounts: accounts,
-		State:    state,
-		Block:    block,
-	})
+// MineBlock mines the transactions as the block following prevBlockNumber, the
+// block fields of the receipts and of their logs are filled in here.
+func MineBlock(
+	txs types.Transactions,
+	receipts types.Receipts,
+	prevBlockNumber *big.Int,
+	prevBlockHash common.Hash,
+	fork forkDB,
+	txStore transactionStorage,
+	blockStore blockStorage,
+) (common.Hash, *big.Int, error) {
+	blockNumber := new(big.Int).Add(prevBlockNumber, new(big.Int).SetUint64(1))
+
+	var gasUsed uint64
+	for i, receipt := range receipts {
+		gasUsed += receipt.GasUsed
+		receipt.CumulativeGasUsed = gasUsed
+		receipt.BlockNumber = blockNumber
+		receipt.TransactionIndex = uint(i)
+	}
+
+	block := entity.NewBlock(prevBlockHash, blockNumber, txs, receipts, gasUsed)
+
+	var logIndex uint
+	for i, receipt := range receipts {
+		receipt.BlockHash = block.Hash()
+		for _, log := range receipt.Logs {
+			log.BlockNumber = blockNumber.Uint64()
+			log.BlockHash = block.Hash()
+			log.TxHash = receipt.TxHash
+			log.TxIndex = receipt.TransactionIndex
+			log.Index = logIndex
+			logIndex++
+		}
+
+		txStore.AddTransaction(txs[i])
+		txStore.AddReceipt(receipt)
+	}
+
+	accounts, state := fork.Diff()
+	blockStore.AddBlockChanges(block, accounts, state)
 
 	return block.Hash(), blockNumber, nil
 }
@@ -15,9 +15,9 @@ type postExecutionStateFetcher interface {
 }
 
 type blockStorage interface {
-	AddBlock(block *entity.BlockState)
+	AddBlockChanges(block *types.Block, accounts *entity.AccountsStorage, state *entity.AccountsState)
 }
 
 type forkDB interface {
-	Copy() (*entity.AccountsStorage, *entity.AccountsState)
+	Diff() (*entity.AccountsStorage, *entity.AccountsState)
 }
@@ -0,0 +1,281 @@
+package provider
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/raul0ligma/smelter/entity"
+)
+
+const cacheTmpPrefix = ".tmp-"
+
+type cacheKey struct {
+	chainID uint64
+	hash    string
+}
+
+type cacheEntry struct {
+	key  cacheKey
+	size int64
+}
+
+// DiskCache is an on disk cache of upstream responses shared by every chain, each
+// entry is a file under dir/<chainID>. The least recently used entries are
+// removed once the cache grows over maxBytes, 0 for no limit.
+type DiskCache struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	bytes    int64
+	lru      *list.List
+	entries  map[cacheKey]*list.Element
+	stats    map[uint64]*entity.CacheStats
+}
+
+// NewDiskCache opens the cache in dir, the entries left by a previous run are
+// indexed from the oldest to the newest and its unfinished writes are removed.
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create cache dir: %w", err)
+	}
+
+	c := &DiskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[cacheKey]*list.Element),
+		stats:    make(map[uint64]*entity.CacheStats),
+	}
+
+	type file struct {
+		key     cacheKey
+		size    int64
+		modTime int64
+	}
+	files := make([]file, 0)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		// files left half written by a crash are outside the byte budget
+		if strings.HasPrefix(d.Name(), cacheTmpPrefix) {
+			os.Remove(path)
+			return nil
+		}
+
+		chainID, err := strconv.ParseUint(filepath.Base(filepath.Dir(path)), 10, 64)
+		if err != nil {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		files = append(files, file{
+			key:     cacheKey{chainID: chainID, hash: d.Name()},
+			size:    info.Size(),
+			modTime: info.ModTime().UnixNano(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read cache dir: %w", err)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime < files[j].modTime
+	})
+	for _, f := range files {
+		c.entries[f.key] = c.lru.PushFront(&cacheEntry{key: f.key, size: f.size})
+		c.bytes += f.size
+	}
+
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// cacheHash hashes the method and params of a request, the chain is part of the
+// entry path. The params are lowercased as addresses are passed both checksummed
+// and not.
+func cacheHash(method string, params []any) (string, error) {
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(append([]byte(method+":"), bytes.ToLower(encoded)...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (c *DiskCache) path(key cacheKey) string {
+	return filepath.Join(c.dir, strconv.FormatUint(key.chainID, 10), key.hash)
+}
+
+func (c *DiskCache) chainStats(chainID uint64) *entity.CacheStats {
+	if _, ok := c.stats[chainID]; !ok {
+		c.stats[chainID] = &entity.CacheStats{ChainID: chainID}
+	}
+	return c.stats[chainID]
+}
+
+// Get reads the entry of a request, an entry which can't be read is dropped.
+func (c *DiskCache) Get(chainID uint64, hash string) ([]byte, bool) {
+	key := cacheKey{chainID: chainID, hash: hash}
+
+	c.mu.Lock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.chainStats(chainID).Misses++
+		c.mu.Unlock()
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	c.mu.Unlock()
+
+	data, err := os.ReadFile(c.path(key))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		if elem, ok := c.entries[key]; ok {
+			c.remove(elem)
+		}
+		c.chainStats(chainID).Misses++
+		return nil, false
+	}
+
+	c.chainStats(chainID).Hits++
+	return data, true
+}
+
+// Put writes the entry of a request, the file is renamed into place so a
+// concurrent read never sees it half written.
+func (c *DiskCache) Put(chainID uint64, hash string, data []byte) error {
+	size := int64(len(data))
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return nil
+	}
+
+	key := cacheKey{chainID: chainID, hash: hash}
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), cacheTmpPrefix)
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		c.bytes += size - entry.size
+		entry.size = size
+		c.lru.MoveToFront(elem)
+	} else {
+		c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, size: size})
+		c.bytes += size
+	}
+
+	c.evict()
+	return nil
+}
+
+// evict removes the least recently used entries until the cache fits maxBytes,
+// it's called with the lock held.
+func (c *DiskCache) evict() {
+	if c.maxBytes <= 0 {
+		return
+	}
+
+	for c.bytes > c.maxBytes {
+		elem := c.lru.Back()
+		if elem == nil {
+			return
+		}
+
+		entry := elem.Value.(*cacheEntry)
+		c.remove(elem)
+		os.Remove(c.path(entry.key))
+		c.chainStats(entry.key.chainID).Evicted++
+	}
+}
+
+func (c *DiskCache) remove(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size
+}
+
+// Stats returns the counters of a chain.
+func (c *DiskCache) Stats(chainID uint64) entity.CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := *c.chainStats(chainID)
+	stats.MaxBytes = c.maxBytes
+	for key, elem := range c.entries {
+		if key.chainID != chainID {
+			continue
+		}
+
+		stats.Entries++
+		stats.Bytes += elem.Value.(*cacheEntry).size
+	}
+
+	return stats
+}
+
+// Clear removes every entry of a chain.
+func (c *DiskCache) Clear(chainID uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, elem := range c.entries {
+		if key.chainID == chainID {
+			c.remove(elem)
+		}
+	}
+
+	err := os.RemoveAll(filepath.Join(c.dir, strconv.FormatUint(chainID, 10)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
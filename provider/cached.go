@@ -0,0 +1,269 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/raul0ligma/smelter/entity"
+)
+
+const (
+	cacheMethodHeader = "eth_getHeaderByNumber"
+	cacheMethodBlock  = "eth_getBlockByNumber.rlp"
+
+	headRefreshInterval = time.Second
+)
+
+// CachedProvider reads the state and blocks at a pinned block through the disk
+// cache, requests at the latest block or without a block go to the upstream.
+// Only blocks at least confirmations blocks below the head are cached so a reorg
+// never leaves stale responses behind.
+type CachedProvider struct {
+	entity.ChainReaderAndCaller
+	cache         *DiskCache
+	chainID       uint64
+	confirmations uint64
+
+	mu        sync.Mutex
+	refreshed time.Time
+	// unconfirmed is the first block not yet confirmed, 0 until the head is known
+	unconfirmed atomic.Uint64
+}
+
+func NewCachedProvider(
+	reader entity.ChainReaderAndCaller,
+	cache *DiskCache,
+	chainID uint64,
+	confirmations uint64,
+) *CachedProvider {
+	return &CachedProvider{
+		ChainReaderAndCaller: reader,
+		cache:                cache,
+		chainID:              chainID,
+		confirmations:        confirmations,
+	}
+}
+
+// confirmed reports whether block is deep enough below the head to be cached, the
+// head is polled at most once per headRefreshInterval when a newer block is read.
+func (p *CachedProvider) confirmed(ctx context.Context, block uint64) bool {
+	if block < p.unconfirmed.Load() {
+		return true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if block < p.unconfirmed.Load() {
+		return true
+	}
+	if time.Since(p.refreshed) < headRefreshInterval {
+		return false
+	}
+
+	p.refreshed = time.Now()
+	head, err := p.ChainReaderAndCaller.BlockNumber(ctx)
+	if err != nil || head < p.confirmations {
+		return false
+	}
+
+	p.unconfirmed.Store(head - p.confirmations + 1)
+	return block < p.unconfirmed.Load()
+}
+
+// hash returns the cache entry of a request, only requests with a confirmed block
+// number as their last param are cached as the response at such a block never
+// changes.
+func (p *CachedProvider) hash(ctx context.Context, method string, params []any) (string, bool) {
+	if len(params) == 0 {
+		return "", false
+	}
+
+	block, ok := params[len(params)-1].(*big.Int)
+	if !ok || block == nil || block.Sign() < 0 || !block.IsUint64() || !p.confirmed(ctx, block.Uint64()) {
+		return "", false
+	}
+
+	hash, err := cacheHash(method, params)
+	if err != nil {
+		return "", false
+	}
+
+	return hash, true
+}
+
+// cached fills out from the cache, on a miss fetch fills it from the upstream and
+// the response is written back.
+func (p *CachedProvider) cached(ctx context.Context, method string, params []any, out any, fetch func() error) error {
+	hash, ok := p.hash(ctx, method, params)
+	if !ok {
+		return fetch()
+	}
+
+	if data, ok := p.cache.Get(p.chainID, hash); ok {
+		if err := json.Unmarshal(data, out); err == nil {
+			return nil
+		}
+	}
+
+	if err := fetch(); err != nil {
+		return err
+	}
+
+	if data, err := json.Marshal(out); err == nil {
+		_ = p.cache.Put(p.chainID, hash, data)
+	}
+
+	return nil
+}
+
+func (p *CachedProvider) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	var code hexutil.Bytes
+	err := p.cached(ctx, MethodCodeAt, []any{account, blockNumber}, &code, func() (err error) {
+		code, err = p.ChainReaderAndCaller.CodeAt(ctx, account, blockNumber)
+		return err
+	})
+	return code, err
+}
+
+func (p *CachedProvider) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	var balance *hexutil.Big
+	err := p.cached(ctx, MethodBalanceAt, []any{account, blockNumber}, &balance, func() error {
+		value, err := p.ChainReaderAndCaller.BalanceAt(ctx, account, blockNumber)
+		balance = (*hexutil.Big)(value)
+		return err
+	})
+	return (*big.Int)(balance), err
+}
+
+func (p *CachedProvider) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	var nonce hexutil.Uint64
+	err := p.cached(ctx, MethodNonceAt, []any{account, blockNumber}, &nonce, func() error {
+		value, err := p.ChainReaderAndCaller.NonceAt(ctx, account, blockNumber)
+		nonce = hexutil.Uint64(value)
+		return err
+	})
+	return uint64(nonce), err
+}
+
+func (p *CachedProvider) StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) ([]byte, error) {
+	var value hexutil.Bytes
+	err := p.cached(ctx, MethodGetStorageAt, []any{account, key, blockNumber}, &value, func() (err error) {
+		value, err = p.ChainReaderAndCaller.StorageAt(ctx, account, key, blockNumber)
+		return err
+	})
+	return value, err
+}
+
+func (p *CachedProvider) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	var header *types.Header
+	err := p.cached(ctx, cacheMethodHeader, []any{number}, &header, func() (err error) {
+		header, err = p.ChainReaderAndCaller.HeaderByNumber(ctx, number)
+		return err
+	})
+	return header, err
+}
+
+func (p *CachedProvider) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	var encoded hexutil.Bytes
+	var block *types.Block
+	err := p.cached(ctx, cacheMethodBlock, []any{number}, &encoded, func() (err error) {
+		if block, err = p.ChainReaderAndCaller.BlockByNumber(ctx, number); err != nil {
+			return err
+		}
+		encoded, err = rlp.EncodeToBytes(block)
+		return err
+	})
+	if err != nil || block != nil {
+		return block, err
+	}
+
+	block = new(types.Block)
+	if err = rlp.DecodeBytes(encoded, block); err != nil {
+		return p.ChainReaderAndCaller.BlockByNumber(ctx, number)
+	}
+
+	return block, nil
+}
+
+// Batch serves the cached requests and sends the rest to the upstream in a single
+// batch.
+func (p *CachedProvider) Batch(ctx context.Context, requests []entity.BatchReq) ([]json.RawMessage, error) {
+	results := make([]json.RawMessage, len(requests))
+	hashes := make([]string, len(requests))
+	missing := make([]entity.BatchReq, 0, len(requests))
+	indexes := make([]int, 0, len(requests))
+	for i, req := range requests {
+		if hash, ok := p.hash(ctx, req.Method, req.Params); ok {
+			hashes[i] = hash
+			if data, ok := p.cache.Get(p.chainID, hash); ok {
+				results[i] = data
+				continue
+			}
+		}
+
+		missing = append(missing, req)
+		indexes = append(indexes, i)
+	}
+
+	if len(missing) == 0 {
+		return results, nil
+	}
+
+	responses, err := p.ChainReaderAndCaller.Batch(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	for j, resp := range responses {
+		if j >= len(indexes) {
+			break
+		}
+
+		i := indexes[j]
+		results[i] = resp
+		if hashes[i] != "" && len(resp) > 0 && string(resp) != "null" {
+			_ = p.cache.Put(p.chainID, hashes[i], resp)
+		}
+	}
+
+	return results, nil
+}
+
+func (p *CachedProvider) BatchWithUnmarshal(ctx context.Context, requests []entity.BatchReq, outputs []any) error {
+	if len(requests) != len(outputs) {
+		return fmt.Errorf("mismatch between requests and outputs count")
+	}
+
+	results, err := p.Batch(ctx, requests)
+	if err != nil {
+		return err
+	}
+
+	for i, result := range results {
+		if err := json.Unmarshal(result, outputs[i]); err != nil {
+			return fmt.Errorf("unmarshal result %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// CacheStats returns the cache counters of the chain.
+func (p *CachedProvider) CacheStats() entity.CacheStats {
+	return p.cache.Stats(p.chainID)
+}
+
+// ClearCache removes the cached responses of the chain.
+func (p *CachedProvider) ClearCache() error {
+	return p.cache.Clear(p.chainID)
+}
@@ -0,0 +1,193 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/raul0ligma/smelter/entity"
+)
+
+// batchTimeout bounds an upstream batch, the batch is shared by its callers so it
+// doesn't follow any of their contexts.
+const batchTimeout = 30 * time.Second
+
+type pendingCall struct {
+	req    entity.BatchReq
+	result json.RawMessage
+	err    error
+	done   chan struct{}
+}
+
+// CoalescingProvider collects the single state reads made within window into
+// upstream batches of at most maxBatch requests and fans the results back out to
+// the callers, a batch is sent early once it's full. Explicit batches and every
+// other call go to the upstream as they are.
+type CoalescingProvider struct {
+	entity.ChainReaderAndCaller
+	window   time.Duration
+	maxBatch int
+
+	mu      sync.Mutex
+	pending []*pendingCall
+	timer   *time.Timer
+}
+
+// NewCoalescingProvider wraps reader, a reader without batching support or a
+// window of 0 leaves the calls as they are. A maxBatch of 0 doesn't limit the
+// batch size.
+func NewCoalescingProvider(reader entity.ChainReaderAndCaller, window time.Duration, maxBatch int) *CoalescingProvider {
+	return &CoalescingProvider{
+		ChainReaderAndCaller: reader,
+		window:               window,
+		maxBatch:             maxBatch,
+	}
+}
+
+func (p *CoalescingProvider) enabled() bool {
+	return p.window > 0 && p.ChainReaderAndCaller.SupportsBatching()
+}
+
+// call queues a request for the next batch and waits for its result.
+func (p *CoalescingProvider) call(ctx context.Context, method string, params []any, out any) error {
+	call := &pendingCall{
+		req:  entity.BatchReq{Method: method, Params: params},
+		done: make(chan struct{}),
+	}
+
+	p.mu.Lock()
+	p.pending = append(p.pending, call)
+	if p.maxBatch > 0 && len(p.pending) >= p.maxBatch {
+		batch := p.take()
+		p.mu.Unlock()
+		go p.send(batch)
+	} else {
+		if p.timer == nil {
+			p.timer = time.AfterFunc(p.window, p.flush)
+		}
+		p.mu.Unlock()
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-call.done:
+	}
+
+	if call.err != nil {
+		return call.err
+	}
+	if len(call.result) == 0 {
+		return fmt.Errorf("%s: missing result", method)
+	}
+
+	return json.Unmarshal(call.result, out)
+}
+
+// take empties the pending batch, it's called with the lock held.
+func (p *CoalescingProvider) take() []*pendingCall {
+	batch := p.pending
+	p.pending = nil
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+
+	return batch
+}
+
+func (p *CoalescingProvider) flush() {
+	p.mu.Lock()
+	batch := p.take()
+	p.mu.Unlock()
+
+	p.send(batch)
+}
+
+// send sends a batch to the upstream, the batch fails as a whole when any request
+// fails so it's then split in halves which are retried concurrently until every
+// caller has its own result. A transport error fails every caller at once as a
+// retry would only add load to an upstream which is down or rate limiting. The
+// callers' contexts aren't shared by the batch, a cancelled caller stops waiting
+// instead.
+func (p *CoalescingProvider) send(batch []*pendingCall) {
+	if len(batch) == 0 {
+		return
+	}
+
+	requests := make([]entity.BatchReq, len(batch))
+	for i, call := range batch {
+		requests[i] = call.req
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), batchTimeout)
+	results, err := p.ChainReaderAndCaller.Batch(ctx, requests)
+	cancel()
+	var requestErr *RequestError
+	if errors.As(err, &requestErr) && len(batch) > 1 {
+		half := len(batch) / 2
+		go p.send(batch[:half])
+		go p.send(batch[half:])
+		return
+	}
+
+	for i, call := range batch {
+		switch {
+		case err != nil:
+			call.err = err
+		case i < len(results):
+			call.result = results[i]
+		default:
+			call.err = errors.New("missing batch result")
+		}
+		close(call.done)
+	}
+}
+
+func (p *CoalescingProvider) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	if !p.enabled() {
+		return p.ChainReaderAndCaller.CodeAt(ctx, account, blockNumber)
+	}
+
+	var code hexutil.Bytes
+	err := p.call(ctx, MethodCodeAt, []any{account, blockNumber}, &code)
+	return code, err
+}
+
+func (p *CoalescingProvider) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	if !p.enabled() {
+		return p.ChainReaderAndCaller.BalanceAt(ctx, account, blockNumber)
+	}
+
+	var balance hexutil.Big
+	if err := p.call(ctx, MethodBalanceAt, []any{account, blockNumber}, &balance); err != nil {
+		return nil, err
+	}
+	return (*big.Int)(&balance), nil
+}
+
+func (p *CoalescingProvider) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	if !p.enabled() {
+		return p.ChainReaderAndCaller.NonceAt(ctx, account, blockNumber)
+	}
+
+	var nonce hexutil.Uint64
+	err := p.call(ctx, MethodNonceAt, []any{account, blockNumber}, &nonce)
+	return uint64(nonce), err
+}
+
+func (p *CoalescingProvider) StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) ([]byte, error) {
+	if !p.enabled() {
+		return p.ChainReaderAndCaller.StorageAt(ctx, account, key, blockNumber)
+	}
+
+	var value hexutil.Bytes
+	err := p.call(ctx, MethodGetStorageAt, []any{account, key, blockNumber}, &value)
+	return value, err
+}
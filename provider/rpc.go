@@ -58,6 +58,21 @@ func NewBatchJSONRPcProvider(rpcURL string) (*BatchRpcProvider, error) {
 	}, nil
 }
 
+// RequestError is the error the upstream returned for a single request of a
+// batch, unlike a transport error the other requests may have succeeded.
+type RequestError struct {
+	ID      int
+	Message string
+}
+
+func (e *RequestError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("rpc error in request %d", e.ID)
+	}
+
+	return fmt.Sprintf("rpc error (request %d): %s", e.ID, e.Message)
+}
+
 func (p *BatchRpcProvider) SupportsBatching() bool {
 	return true
 }
@@ -79,7 +94,7 @@ func (p *BatchRpcProvider) Batch(ctx context.Context, requests []entity.BatchReq
 					if blockNum == nil {
 						rpcParams[j] = "latest"
 					} else {
-						rpcParams[j] = hexutil.Encode(blockNum.Bytes())
+						rpcParams[j] = hexutil.EncodeBig(blockNum)
 					}
 					continue
 				}
@@ -112,27 +127,33 @@ func (p *BatchRpcProvider) Batch(ctx context.Context, requests []entity.BatchReq
 	}
 	defer httpResp.Body.Close()
 
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream batch failed: %s", httpResp.Status)
+	}
+
 	var responses []map[string]json.RawMessage
 	if err := json.NewDecoder(httpResp.Body).Decode(&responses); err != nil {
 		return nil, err
 	}
 
-	results := make([]json.RawMessage, len(responses))
+	results := make([]json.RawMessage, len(requests))
 	for _, resp := range responses {
 		var id int
 		if err := json.Unmarshal(resp["id"], &id); err != nil {
 			return nil, err
 		}
+		if id < 1 || id > len(requests) {
+			return nil, fmt.Errorf("invalid batch response id %d", id)
+		}
 
 		if errResp, ok := resp["error"]; ok && len(errResp) > 0 {
 			var jsonErr struct {
 				Code    int    `json:"code"`
 				Message string `json:"message"`
 			}
-			if err := json.Unmarshal(errResp, &jsonErr); err == nil {
-				return nil, fmt.Errorf("rpc error (request %d): %s", id, jsonErr.Message)
-			}
-			return nil, fmt.Errorf("rpc error in request %d", id)
+			// an undecodable error still belongs to the request
+			_ = json.Unmarshal(errResp, &jsonErr)
+			return nil, &RequestError{ID: id, Message: jsonErr.Message}
 		}
 
 		results[id-1] = resp["result"]
@@ -159,3 +180,11 @@ func (p *BatchRpcProvider) BatchWithUnmarshal(ctx context.Context, requests []en
 
 	return nil
 }
+
+func (p *RpcProvider) CallContext(ctx context.Context, result any, method string, args ...any) error {
+	return p.Client.Client().CallContext(ctx, result, method, args...)
+}
+
+func (p *BatchRpcProvider) CallContext(ctx context.Context, result any, method string, args ...any) error {
+	return p.Client.Client().CallContext(ctx, result, method, args...)
+}
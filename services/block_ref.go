@@ -0,0 +1,142 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/raul0ligma/smelter/entity"
+)
+
+const (
+	earliestBlock  = "earliest"
+	pendingBlock   = "pending"
+	safeBlock      = "safe"
+	finalizedBlock = "finalized"
+)
+
+// blockRef is a block parameter of the state methods, it is either a tag, a hex
+// or decimal number, or an EIP-1898 object referencing a block by number or hash.
+type blockRef struct {
+	number           string
+	hash             *common.Hash
+	requireCanonical bool
+}
+
+func newBlockRef(number string) blockRef {
+	return blockRef{number: number}
+}
+
+func (b *blockRef) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var obj struct {
+			BlockNumber      *string      `json:"blockNumber"`
+			BlockHash        *common.Hash `json:"blockHash"`
+			RequireCanonical bool         `json:"requireCanonical"`
+		}
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return err
+		}
+
+		if (obj.BlockNumber == nil) == (obj.BlockHash == nil) {
+			return fmt.Errorf("exactly one of blockNumber and blockHash must be set")
+		}
+
+		if obj.BlockNumber != nil {
+			b.number = *obj.BlockNumber
+		}
+		b.hash = obj.BlockHash
+		b.requireCanonical = obj.RequireCanonical
+		return nil
+	}
+
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err == nil {
+		b.number = number.String()
+		return nil
+	}
+
+	return json.Unmarshal(data, &b.number)
+}
+
+func (b blockRef) String() string {
+	if b.hash != nil {
+		return b.hash.Hex()
+	}
+
+	return b.number
+}
+
+// resolveBlock maps a block reference onto a block number of the local chain, the
+// chain is made of the upstream blocks up to the fork block followed by the
+// blocks mined locally. Every block is final so safe, finalized and pending all
+// resolve to the latest block.
+func resolveBlock(
+	ctx context.Context,
+	exec executor,
+	reader readerAndCaller,
+	cfg entity.ForkConfig,
+	ref blockRef,
+) (*big.Int, error) {
+	_, latest := exec.Latest()
+	if ref.hash != nil {
+		return resolveBlockHash(ctx, exec, reader, cfg, *ref.hash, ref.requireCanonical)
+	}
+
+	switch ref.number {
+	case "", latestBlock, pendingBlock, safeBlock, finalizedBlock:
+		return new(big.Int).SetUint64(latest), nil
+	case earliestBlock:
+		return new(big.Int), nil
+	}
+
+	block, err := parseBigInt(ref.number)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse block number %s", ref.number)
+	}
+
+	if block.Uint64() > latest {
+		return nil, fmt.Errorf("invalid block height, received %d, current %d", block.Uint64(), latest)
+	}
+
+	return block, nil
+}
+
+func resolveBlockHash(
+	ctx context.Context,
+	exec executor,
+	reader readerAndCaller,
+	cfg entity.ForkConfig,
+	hash common.Hash,
+	requireCanonical bool,
+) (*big.Int, error) {
+	if storage := exec.BlockStorage().GetBlockByHash(hash); storage != nil {
+		return storage.Block.Number(), nil
+	}
+
+	header, err := reader.HeaderByHash(ctx, hash)
+	if err != nil {
+		return nil, fmt.Errorf("block %s not found: %w", hash.Hex(), err)
+	}
+
+	// upstream blocks past the fork block aren't part of the local chain
+	if header.Number.Cmp(cfg.ForkBlock) > 0 {
+		return nil, fmt.Errorf("block %s is not part of the fork", hash.Hex())
+	}
+
+	if requireCanonical {
+		canonical, err := reader.HeaderByNumber(ctx, header.Number)
+		if err != nil {
+			return nil, err
+		}
+
+		if canonical.Hash() != hash {
+			return nil, fmt.Errorf("block %s is not canonical", hash.Hex())
+		}
+	}
+
+	return header.Number, nil
+}
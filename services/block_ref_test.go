@@ -0,0 +1,53 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestBlockRefUnmarshal(t *testing.T) {
+	hash := common.HexToHash("0x1234")
+	cases := map[string]blockRef{
+		`"latest"`:               {number: "latest"},
+		`"0x10"`:                 {number: "0x10"},
+		`"16"`:                   {number: "16"},
+		`16`:                     {number: "16"},
+		`{"blockNumber":"safe"}`: {number: "safe"},
+		`{"blockHash":"` + hash.Hex() + `","requireCanonical":true}`: {hash: &hash, requireCanonical: true},
+	}
+
+	for input, expected := range cases {
+		var ref blockRef
+		if err := json.Unmarshal([]byte(input), &ref); err != nil {
+			t.Fatalf("failed to unmarshal %s: %v", input, err)
+		}
+
+		if ref.number != expected.number || ref.requireCanonical != expected.requireCanonical {
+			t.Fatalf("unexpected ref for %s: %+v", input, ref)
+		}
+
+		if (ref.hash == nil) != (expected.hash == nil) || (ref.hash != nil && *ref.hash != *expected.hash) {
+			t.Fatalf("unexpected hash for %s: %+v", input, ref)
+		}
+	}
+
+	var ref blockRef
+	if err := json.Unmarshal([]byte(`{"blockNumber":"0x1","blockHash":"`+hash.Hex()+`"}`), &ref); err == nil {
+		t.Fatalf("expected an error when both number and hash are set")
+	}
+}
+
+func TestParseBigInt(t *testing.T) {
+	for input, expected := range map[string]uint64{"0x10": 16, "0xa": 10, "16": 16, "7": 7} {
+		num, err := parseBigInt(input)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", input, err)
+		}
+
+		if num.Uint64() != expected {
+			t.Fatalf("expected %d for %s, got %d", expected, input, num.Uint64())
+		}
+	}
+}
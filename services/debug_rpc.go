@@ -0,0 +1,319 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/fork"
+	"github.com/raul0ligma/smelter/tracer"
+	"go.uber.org/zap"
+)
+
+const defaultTraceTimeout = 5 * time.Second
+
+var errTraceTimeout = errors.New("execution timeout")
+
+type DebugRpc struct {
+	execStorage     executionCtx
+	readerAndCaller readerAndCaller
+	cfg             entity.ForkConfig
+	logger          *zap.SugaredLogger
+}
+
+func NewDebugRpc(
+	storage executionCtx,
+	cfg entity.ForkConfig,
+	readerAndCaller readerAndCaller,
+) *DebugRpc {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync() // flushes buffer, if any
+
+	return &DebugRpc{
+		execStorage:     storage,
+		cfg:             cfg,
+		readerAndCaller: readerAndCaller,
+		logger:          logger.Sugar(),
+	}
+}
+
+// TraceTransaction replays a local transaction on top of its parent block state,
+// transactions which aren't known locally are forwarded to the upstream.
+func (d *DebugRpc) TraceTransaction(ctx context.Context, params jsonrpc.RawParams) (json.RawMessage, error) {
+	var (
+		txHash common.Hash
+		cfg    *entity.TraceConfig
+	)
+	if err := decodeRawParams(params, 1, &txHash, &cfg); err != nil {
+		return nil, err
+	}
+	d.logger.Debug("Called TraceTransaction", zap.String("txHash", txHash.Hex()))
+
+	execCtx, err := d.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	block, index, err := storedTxBlock(execCtx, txHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if block == nil {
+		return forwardRawParams(ctx, d.readerAndCaller, "debug_traceTransaction", params)
+	}
+
+	return d.traceStoredTx(ctx, execCtx, block, index, cfg)
+}
+
+// TraceCall traces a call on top of the state at the given block without persisting it.
+func (d *DebugRpc) TraceCall(ctx context.Context, params jsonrpc.RawParams) (json.RawMessage, error) {
+	var (
+		msg         jsonCallMsg
+		blockNumber blockRef
+		cfg         *entity.TraceCallConfig
+	)
+	if err := decodeRawParams(params, 1, &msg, &blockNumber, &cfg); err != nil {
+		return nil, err
+	}
+	d.logger.Debug("Called TraceCall", zap.Any("msg", msg), zap.Stringer("blockNumber", blockNumber))
+
+	execCtx, err := d.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, latest := execCtx.Executor.Latest()
+	block, err := resolveBlock(ctx, execCtx.Executor, d.readerAndCaller, execCtx.Fork, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	call, err := createEthCallMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var traceCfg *entity.TraceConfig
+	if cfg != nil {
+		traceCfg = &cfg.TraceConfig
+	}
+
+	if block.Uint64() == latest {
+		return d.trace(ctx, execCtx, call, nil, nil, traceCfg, &tracers.Context{BlockNumber: block})
+	}
+
+	if block.Uint64() > execCtx.Fork.ForkBlock.Uint64() {
+		db, err := forkDBAt(execCtx.Executor, d.readerAndCaller, execCtx.Fork, block.Uint64())
+		if err != nil {
+			return nil, err
+		}
+
+		return d.trace(ctx, execCtx, call, db, nil, traceCfg, &tracers.Context{BlockNumber: block})
+	}
+
+	return forwardRawParams(ctx, d.readerAndCaller, "debug_traceCall", params)
+}
+
+func (d *DebugRpc) TraceBlockByNumber(ctx context.Context, params jsonrpc.RawParams) ([]*entity.TxTraceResult, error) {
+	var (
+		number blockRef
+		cfg    *entity.TraceConfig
+	)
+	if err := decodeRawParams(params, 1, &number, &cfg); err != nil {
+		return nil, err
+	}
+	d.logger.Debug("Called TraceBlockByNumber", zap.Stringer("number", number))
+
+	execCtx, err := d.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := resolveBlock(ctx, execCtx.Executor, d.readerAndCaller, execCtx.Fork, number)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Uint64() <= execCtx.Fork.ForkBlock.Uint64() {
+		return d.forwardBlockTrace(ctx, "debug_traceBlockByNumber", params)
+	}
+
+	storage, err := getBlockStorage(execCtx.Executor, block.Uint64())
+	if err != nil {
+		return nil, err
+	}
+
+	return d.traceStoredBlock(ctx, execCtx, storage.Block, cfg)
+}
+
+func (d *DebugRpc) TraceBlockByHash(ctx context.Context, params jsonrpc.RawParams) ([]*entity.TxTraceResult, error) {
+	var (
+		hash common.Hash
+		cfg  *entity.TraceConfig
+	)
+	if err := decodeRawParams(params, 1, &hash, &cfg); err != nil {
+		return nil, err
+	}
+	d.logger.Debug("Called TraceBlockByHash", zap.String("hash", hash.Hex()))
+
+	execCtx, err := d.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	storage := execCtx.Executor.BlockStorage().GetBlockByHash(hash)
+	if storage == nil {
+		return d.forwardBlockTrace(ctx, "debug_traceBlockByHash", params)
+	}
+
+	return d.traceStoredBlock(ctx, execCtx, storage.Block, cfg)
+}
+
+func (d *DebugRpc) forwardBlockTrace(ctx context.Context, method string, params jsonrpc.RawParams) ([]*entity.TxTraceResult, error) {
+	raw, err := forwardRawParams(ctx, d.readerAndCaller, method, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*entity.TxTraceResult
+	if err := json.Unmarshal(raw, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// traceStoredBlock traces every transaction of a local block, blocks mined by the
+// executor hold a single transaction so each of them replays on the parent state.
+func (d *DebugRpc) traceStoredBlock(
+	ctx context.Context,
+	execCtx *ExecutionCtx,
+	block *types.Block,
+	cfg *entity.TraceConfig,
+) ([]*entity.TxTraceResult, error) {
+	results := make([]*entity.TxTraceResult, 0, len(block.Transactions()))
+	for i, tx := range block.Transactions() {
+		result := &entity.TxTraceResult{TxHash: tx.Hash()}
+		res, err := d.traceStoredTx(ctx, execCtx, block, i, cfg)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Result = res
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (d *DebugRpc) traceStoredTx(
+	ctx context.Context,
+	execCtx *ExecutionCtx,
+	block *types.Block,
+	index int,
+	cfg *entity.TraceConfig,
+) (json.RawMessage, error) {
+	r, err := prepareReplay(execCtx, d.readerAndCaller, execCtx.Fork, block, index)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.trace(ctx, execCtx, r.msg, r.db, r.header, cfg, r.txCtx)
+}
+
+func (d *DebugRpc) trace(
+	ctx context.Context,
+	execCtx *ExecutionCtx,
+	msg ethereum.CallMsg,
+	db *fork.DB,
+	header *types.Header,
+	cfg *entity.TraceConfig,
+	txCtx *tracers.Context,
+) (json.RawMessage, error) {
+	timeout := defaultTraceTimeout
+	if cfg != nil && cfg.Timeout != nil {
+		var err error
+		if timeout, err = time.ParseDuration(*cfg.Timeout); err != nil {
+			return nil, err
+		}
+	}
+
+	t, err := tracer.NewGethTracer(cfg, txCtx, execCtx.Executor.ChainConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	deadline := time.AfterFunc(timeout, func() {
+		t.Stop(errTraceTimeout)
+	})
+	defer deadline.Stop()
+
+	// reverts and other vm errors are part of the trace result
+	_, _, _ = execCtx.Executor.Trace(ctx, msg, t, db, header, nil)
+	return t.GetResult()
+}
+
+// replay holds everything needed to re-execute a local transaction.
+type replay struct {
+	msg    ethereum.CallMsg
+	db     *fork.DB
+	header *types.Header
+	txCtx  *tracers.Context
+}
+
+// prepareReplay sets up the re-execution of a local transaction on top of the
+// state of its parent block.
+func prepareReplay(
+	execCtx *ExecutionCtx,
+	reader readerAndCaller,
+	cfg entity.ForkConfig,
+	block *types.Block,
+	index int,
+) (*replay, error) {
+	txs := block.Transactions()
+	if index >= len(txs) {
+		return nil, fmt.Errorf("transaction index %d out of range", index)
+	}
+
+	tx := txs[index]
+	sender, ok := execCtx.Executor.TxnStorage().GetSender(tx.Hash())
+	if !ok {
+		return nil, fmt.Errorf("sender of %s not found", tx.Hash().Hex())
+	}
+
+	db, err := forkDBAt(execCtx.Executor, reader, cfg, block.NumberU64()-1)
+	if err != nil {
+		return nil, err
+	}
+
+	return &replay{
+		msg: ethereum.CallMsg{
+			From:     sender,
+			To:       tx.To(),
+			Gas:      tx.Gas(),
+			GasPrice: tx.GasPrice(),
+			Value:    tx.Value(),
+			Data:     tx.Data(),
+		},
+		db:     db,
+		header: block.Header(),
+		txCtx: &tracers.Context{
+			BlockHash:   block.Hash(),
+			BlockNumber: block.Number(),
+			TxIndex:     index,
+			TxHash:      tx.Hash(),
+		},
+	}, nil
+}
@@ -18,7 +18,7 @@ func NewErigonRpc(backend otterscanBackend) *ErigonRpc {
 }
 
 func (o *ErigonRpc) GetHeaderByNumber(ctx context.Context, blockNum uint64) (*entity.BlockData, error) {
-	b, err := o.backend.GetBlockByNumber(ctx, strconv.FormatUint(blockNum, 10), false)
+	b, err := o.backend.GetBlockByNumber(ctx, newBlockRef(strconv.FormatUint(blockNum, 10)), false)
 	if err != nil {
 		return nil, err
 	}
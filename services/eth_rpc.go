@@ -3,6 +3,7 @@ package services
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
@@ -12,6 +13,8 @@ import (
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/filecoin-project/go-jsonrpc"
 	"github.com/raul0ligma/smelter/entity"
 	"github.com/raul0ligma/smelter/fork"
 	"github.com/raul0ligma/smelter/pkg/server"
@@ -63,7 +66,7 @@ func (r *EthRpc) BlockNumber(ctx context.Context) (string, error) {
 
 	_, blockNum := execCtx.Executor.Latest()
 	if blockNum == 0 {
-		return hexutil.Encode(r.cfg.ForkBlock.Bytes()), nil
+		return hexutil.Encode(execCtx.Fork.ForkBlock.Bytes()), nil
 	}
 
 	return hexutil.Encode(new(big.Int).SetUint64(blockNum).Bytes()), nil
@@ -94,9 +97,9 @@ func (r *EthRpc) GetStorageAt(
 	ctx context.Context,
 	account common.Address,
 	slot common.Hash,
-	blockNumber string,
+	blockNumber blockRef,
 ) (string, error) {
-	r.logger.Debug("Called GetStorageAt", zap.String("account", account.Hex()), zap.String("slot", slot.Hex()), zap.String("blockNumber", blockNumber))
+	r.logger.Debug("Called GetStorageAt", zap.String("account", account.Hex()), zap.String("slot", slot.Hex()), zap.Stringer("blockNumber", blockNumber))
 
 	execCtx, err := r.execStorage.GetOrCreate(ctx)
 	if err != nil {
@@ -104,7 +107,7 @@ func (r *EthRpc) GetStorageAt(
 	}
 
 	_, latest := execCtx.Executor.Latest()
-	block, err := parseAndValidateBlockNumber(blockNumber, latest)
+	block, err := resolveBlock(ctx, execCtx.Executor, r.readerAndCaller, execCtx.Fork, blockNumber)
 	if err != nil {
 		return "0x", err
 	}
@@ -117,14 +120,11 @@ func (r *EthRpc) GetStorageAt(
 		return hash.Hex(), nil
 	}
 
-	if block.Uint64() > r.cfg.ForkBlock.Uint64() {
-		state, err := getStateFromBlockStorage(ctx, execCtx.Executor, r.cfg.ChainID, r.readerAndCaller, account, slot, block.Uint64())
+	if block.Uint64() > execCtx.Fork.ForkBlock.Uint64() {
+		state, err := getStateFromBlockStorage(ctx, execCtx.Executor, execCtx.Fork, r.readerAndCaller, account, slot, block.Uint64())
 		if err != nil {
 			return "0x", err
 		}
-		if state == common.HexToHash("") {
-			return getStateFromReader(ctx, r.readerAndCaller, account, slot, block)
-		}
 		return state.Hex(), nil
 	}
 
@@ -151,8 +151,8 @@ func (r *EthRpc) GetHeaderByHash(ctx context.Context, hash common.Hash) (*types.
 	return storage.Block.Header(), nil
 }
 
-func (r *EthRpc) GetHeaderByNumber(ctx context.Context, number string) (*types.Header, error) {
-	r.logger.Debug("Called GetHeaderByNumber", zap.String("number", number))
+func (r *EthRpc) GetHeaderByNumber(ctx context.Context, number blockRef) (*types.Header, error) {
+	r.logger.Debug("Called GetHeaderByNumber", zap.Stringer("number", number))
 
 	block, err := r.GetBlockByNumber(ctx, number, false)
 	if err != nil {
@@ -162,12 +162,20 @@ func (r *EthRpc) GetHeaderByNumber(ctx context.Context, number string) (*types.H
 	return block.Raw.Header(), nil
 }
 
-func (r *EthRpc) Call(
-	ctx context.Context,
-	msg jsonCallMsg,
-	blockNumber string,
-) (string, error) {
-	r.logger.Debug("Called Call", zap.Any("msg", msg), zap.String("blockNumber", blockNumber))
+// Call executes a call without persisting it, the optional third and fourth params
+// override the account state and the block env for this call only.
+func (r *EthRpc) Call(ctx context.Context, params jsonrpc.RawParams) (string, error) {
+	var (
+		msg            jsonCallMsg
+		blockNumber    = newBlockRef(latestBlock)
+		overrides      entity.StateOverrides
+		blockOverrides *entity.BlockOverrides
+	)
+	if err := decodeRawParams(params, 1, &msg, &blockNumber, &overrides, &blockOverrides); err != nil {
+		return "0x", err
+	}
+
+	r.logger.Debug("Called Call", zap.Any("msg", msg), zap.Stringer("blockNumber", blockNumber))
 
 	execCtx, err := r.execStorage.GetOrCreate(ctx)
 	if err != nil {
@@ -175,38 +183,88 @@ func (r *EthRpc) Call(
 	}
 
 	_, latest := execCtx.Executor.Latest()
-	block, err := parseAndValidateBlockNumber(blockNumber, latest)
+	block, err := resolveBlock(ctx, execCtx.Executor, r.readerAndCaller, execCtx.Fork, blockNumber)
 	if err != nil {
 		return "0x", err
 	}
 
 	call, err := createEthCallMsg(msg)
-	t := tracer.NewTracer(false)
-	if block.Uint64() == latest {
-		ret, _, err := execCtx.Executor.Call(ctx, call, t, entity.StateOverrides{})
-		if err != nil {
-			return "0x", err
-		}
-
-		return hexutil.Encode(ret), nil
+	if err != nil {
+		return "0x", err
 	}
 
-	if block.Uint64() > r.cfg.ForkBlock.Uint64() {
+	var db *fork.DB
+	switch {
+	case block.Uint64() == latest:
+	case block.Uint64() > execCtx.Fork.ForkBlock.Uint64():
 		storage, err := getBlockStorage(execCtx.Executor, block.Uint64())
 		if err != nil {
 			return "0x", err
 		}
 
-		db := fork.NewDB(r.readerAndCaller, r.cfg, storage.Accounts, storage.State)
-		ret, _, err := execCtx.Executor.CallWithDB(ctx, call, t, db, entity.StateOverrides{})
-		if err != nil {
-			return "0x", err
-		}
+		accounts, state := storage.Flatten()
+		db = fork.NewDB(r.readerAndCaller, execCtx.Fork, accounts, state)
+	case len(overrides) == 0 && blockOverrides == nil:
+		return callOnReader(ctx, r.readerAndCaller, call, block)
+	default:
+		// overrides can't be sent along the upstream call, so the call runs locally on
+		// a db reading the upstream state at the requested block
+		cfg := execCtx.Fork
+		cfg.ForkBlock = block
+		db = fork.NewDB(r.readerAndCaller, cfg, entity.NewAccountsStorage(), entity.NewAccountsState())
+	}
 
-		return hexutil.Encode(ret), nil
+	ret, _, err := execCtx.Executor.CallWithDB(ctx, call, tracer.NewTracer(false), db, overrides, blockOverrides)
+	if err != nil {
+		return "0x", err
 	}
 
-	return callOnReader(ctx, r.readerAndCaller, call, block)
+	return hexutil.Encode(ret), nil
+}
+
+// SimulateV1 executes blocks of calls on top of the given block without touching
+// the session, the simulation runs on a throwaway copy of the state.
+func (r *EthRpc) SimulateV1(ctx context.Context, params jsonrpc.RawParams) ([]*entity.SimulatedBlock, error) {
+	var (
+		opts        entity.SimulateOpts
+		blockNumber = newBlockRef(latestBlock)
+	)
+	if err := decodeRawParams(params, 1, &opts, &blockNumber); err != nil {
+		return nil, err
+	}
+
+	r.logger.Debug("Called SimulateV1", zap.Int("blocks", len(opts.BlockStateCalls)), zap.Stringer("blockNumber", blockNumber))
+
+	execCtx, err := r.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := resolveBlock(ctx, execCtx.Executor, r.readerAndCaller, execCtx.Fork, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	db, parent, err := simulationBase(ctx, execCtx, r.readerAndCaller, execCtx.Fork, block.Uint64())
+	if err != nil {
+		return nil, err
+	}
+
+	return execCtx.Executor.Simulate(ctx, db, parent, opts)
+}
+
+// CallBundle simulates an ordered bundle of signed transactions on top of the
+// session, the bundle is never committed.
+func (r *EthRpc) CallBundle(ctx context.Context, args entity.BundleArgs) (*entity.BundleResult, error) {
+	r.logger.Debug("Called CallBundle", zap.Int("txs", len(args.Txs)))
+
+	execCtx, err := r.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	args.Commit = false
+	return callBundle(ctx, execCtx, args)
 }
 
 func (r *EthRpc) SendRawTransaction(
@@ -231,7 +289,7 @@ func (r *EthRpc) SendRawTransaction(
 		return "0x", err
 	}
 
-	caller := execCtx.Impersonator
+	caller := execCtx.Sender()
 	if caller == common.HexToAddress("") {
 		from, ok := ctx.Value(server.Caller{}).(common.Address)
 		if !ok {
@@ -249,7 +307,7 @@ func (r *EthRpc) SendRawTransaction(
 		Data:     tx.Data(),
 	}
 
-	txHash, _, _, err := execCtx.Executor.CallAndPersist(ctx, msg, t, execCtx.Overrides)
+	txHash, _, _, err := execCtx.Executor.CallAndPersist(ctx, msg, t, execCtx.StateOverrides())
 	fmt.Println(t.Fmt())
 	if err != nil {
 		return "0x", err
@@ -258,6 +316,89 @@ func (r *EthRpc) SendRawTransaction(
 	return txHash.Hex(), nil
 }
 
+// SendTransaction executes an unsigned transaction as its sender, the sender has
+// to be a dev account or impersonated unless the session auto impersonates.
+func (r *EthRpc) SendTransaction(ctx context.Context, args entity.TransactionArgs) (string, error) {
+	r.logger.Debug("Called SendTransaction", zap.Any("args", args))
+
+	execCtx, err := r.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return "0x", err
+	}
+
+	if args.From == nil {
+		return "0x", errors.New("missing from")
+	}
+
+	if !execCtx.IsImpersonated(*args.From) && !execCtx.Accounts.Has(*args.From) {
+		return "0x", fmt.Errorf("account %s is not impersonated", args.From.Hex())
+	}
+
+	msg, err := sendTransactionMsg(args)
+	if err != nil {
+		return "0x", err
+	}
+
+	txHash, _, _, err := execCtx.Executor.CallAndPersist(ctx, msg, tracer.NewTracer(false), execCtx.StateOverrides())
+	if err != nil {
+		return "0x", err
+	}
+
+	return txHash.Hex(), nil
+}
+
+// Accounts returns the dev accounts managed by the node.
+func (r *EthRpc) Accounts(ctx context.Context) ([]common.Address, error) {
+	r.logger.Debug("Called Accounts")
+
+	execCtx, err := r.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return execCtx.Accounts.Addresses(), nil
+}
+
+// Sign signs data with the EIP-191 personal message prefix using a dev account.
+func (r *EthRpc) Sign(ctx context.Context, address common.Address, data hexutil.Bytes) (hexutil.Bytes, error) {
+	r.logger.Debug("Called Sign", zap.String("address", address.Hex()))
+
+	execCtx, err := r.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return execCtx.Accounts.SignText(address, data)
+}
+
+// SignTypedData_v4 signs EIP-712 typed data using a dev account, the typed data is
+// accepted both as an object and as a JSON encoded string.
+func (r *EthRpc) SignTypedData_v4(ctx context.Context, address common.Address, raw json.RawMessage) (hexutil.Bytes, error) {
+	r.logger.Debug("Called SignTypedData_v4", zap.String("address", address.Hex()))
+
+	var encoded string
+	if err := json.Unmarshal(raw, &encoded); err == nil {
+		raw = json.RawMessage(encoded)
+	}
+
+	var typedData apitypes.TypedData
+	if err := json.Unmarshal(raw, &typedData); err != nil {
+		return nil, fmt.Errorf("invalid typed data: %w", err)
+	}
+
+	hash, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		return nil, err
+	}
+
+	execCtx, err := r.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return execCtx.Accounts.SignHash(address, hash)
+}
+
 func (r *EthRpc) GetTransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
 	r.logger.Debug("Called GetTransactionReceipt", zap.String("txHash", txHash.Hex()))
 
@@ -295,7 +436,7 @@ func (r *EthRpc) GetTransactionByHash(ctx context.Context, txHash common.Hash) (
 		return nil, err
 	}
 
-	return entity.SerializeTransaction(txn, receipt), nil
+	return entity.SerializeTransaction(txn, receipt, txSender(execCtx.Executor, txn)), nil
 }
 
 func (r *EthRpc) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error) {
@@ -310,17 +451,17 @@ func (r *EthRpc) GasPrice(ctx context.Context) (string, error) {
 
 func (r *EthRpc) GetBlockByNumber(
 	ctx context.Context,
-	number string,
+	number blockRef,
 	_ bool,
 ) (*entity.SerializedBlock, error) {
-	r.logger.Debug("Called GetBlockByNumber", zap.String("number", number))
+	r.logger.Debug("Called GetBlockByNumber", zap.Stringer("number", number))
 
 	execCtx, err := r.execStorage.GetOrCreate(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	num, err := parseBigInt(number)
+	num, err := resolveBlock(ctx, execCtx.Executor, r.readerAndCaller, execCtx.Fork, number)
 	if err != nil {
 		return nil, err
 	}
@@ -328,8 +469,8 @@ func (r *EthRpc) GetBlockByNumber(
 	return getBlockFromStorageOrReader(execCtx.Executor, r.readerAndCaller, num.Uint64())
 }
 
-func (r *EthRpc) GetBalance(ctx context.Context, account common.Address, blockNumber string) (string, error) {
-	r.logger.Debug("Called GetBalance", zap.String("account", account.Hex()), zap.String("blockNumber", blockNumber))
+func (r *EthRpc) GetBalance(ctx context.Context, account common.Address, blockNumber blockRef) (string, error) {
+	r.logger.Debug("Called GetBalance", zap.String("account", account.Hex()), zap.Stringer("blockNumber", blockNumber))
 
 	execCtx, err := r.execStorage.GetOrCreate(ctx)
 	if err != nil {
@@ -337,7 +478,7 @@ func (r *EthRpc) GetBalance(ctx context.Context, account common.Address, blockNu
 	}
 
 	_, latest := execCtx.Executor.Latest()
-	block, err := parseAndValidateBlockNumber(blockNumber, latest)
+	block, err := resolveBlock(ctx, execCtx.Executor, r.readerAndCaller, execCtx.Fork, blockNumber)
 	if err != nil {
 		return hexPrefix, err
 	}
@@ -346,15 +487,15 @@ func (r *EthRpc) GetBalance(ctx context.Context, account common.Address, blockNu
 		return getBalanceFromForkDB(ctx, execCtx.Db, account)
 	}
 
-	if block.Uint64() > r.cfg.ForkBlock.Uint64() {
-		return getBalanceFromBlockStorage(ctx, execCtx.Executor, r.cfg.ChainID, r.readerAndCaller, account, block.Uint64())
+	if block.Uint64() > execCtx.Fork.ForkBlock.Uint64() {
+		return getBalanceFromBlockStorage(ctx, execCtx.Executor, execCtx.Fork, r.readerAndCaller, account, block.Uint64())
 	}
 
 	return getBalanceFromReader(ctx, r.readerAndCaller, account, block)
 }
 
-func (r *EthRpc) GetCode(ctx context.Context, account common.Address, blockNumber string) (string, error) {
-	r.logger.Debug("Called GetCode", zap.String("account", account.Hex()), zap.String("blockNumber", blockNumber))
+func (r *EthRpc) GetCode(ctx context.Context, account common.Address, blockNumber blockRef) (string, error) {
+	r.logger.Debug("Called GetCode", zap.String("account", account.Hex()), zap.Stringer("blockNumber", blockNumber))
 
 	execCtx, err := r.execStorage.GetOrCreate(ctx)
 	if err != nil {
@@ -362,7 +503,7 @@ func (r *EthRpc) GetCode(ctx context.Context, account common.Address, blockNumbe
 	}
 
 	_, latest := execCtx.Executor.Latest()
-	block, err := parseAndValidateBlockNumber(blockNumber, latest)
+	block, err := resolveBlock(ctx, execCtx.Executor, r.readerAndCaller, execCtx.Fork, blockNumber)
 	if err != nil {
 		return "0x", err
 	}
@@ -375,8 +516,8 @@ func (r *EthRpc) GetCode(ctx context.Context, account common.Address, blockNumbe
 		return hexutil.Encode(code), nil
 	}
 
-	if block.Uint64() > r.cfg.ForkBlock.Uint64() {
-		return getCodeFromBlockStorage(execCtx.Executor, account, block.Uint64())
+	if block.Uint64() > execCtx.Fork.ForkBlock.Uint64() {
+		return getCodeFromBlockStorage(ctx, execCtx.Executor, execCtx.Fork, r.readerAndCaller, account, block.Uint64())
 	}
 
 	return getCodeFromReader(ctx, r.readerAndCaller, account, block)
@@ -398,7 +539,36 @@ func (r *EthRpc) SetBalance(ctx context.Context, account common.Address, balance
 	return execCtx.Db.SetBalance(ctx, account, amount)
 }
 
-func (r *EthRpc) GetTransactionCount(_ context.Context, account common.Address, block string) (string, error) {
-	r.logger.Debug("Called GetTransactionCount", zap.String("account", account.Hex()), zap.String("block", block))
-	return "0x0", nil
+func (r *EthRpc) GetTransactionCount(ctx context.Context, account common.Address, blockNumber blockRef) (string, error) {
+	r.logger.Debug("Called GetTransactionCount", zap.String("account", account.Hex()), zap.Stringer("blockNumber", blockNumber))
+
+	execCtx, err := r.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	_, latest := execCtx.Executor.Latest()
+	block, err := resolveBlock(ctx, execCtx.Executor, r.readerAndCaller, execCtx.Fork, blockNumber)
+	if err != nil {
+		return hexPrefix, err
+	}
+
+	var nonce uint64
+	switch {
+	case block.Uint64() == latest:
+		nonce, err = execCtx.Db.GetNonce(ctx, account)
+	case block.Uint64() > execCtx.Fork.ForkBlock.Uint64():
+		db, dbErr := forkDBAt(execCtx.Executor, r.readerAndCaller, execCtx.Fork, block.Uint64())
+		if dbErr != nil {
+			return hexPrefix, dbErr
+		}
+		nonce, err = db.GetNonce(ctx, account)
+	default:
+		nonce, err = r.readerAndCaller.NonceAt(ctx, account, block)
+	}
+	if err != nil {
+		return hexPrefix, err
+	}
+
+	return hexutil.EncodeUint64(nonce), nil
 }
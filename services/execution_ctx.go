@@ -2,58 +2,424 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/raul0ligma/smelter/config"
 	"github.com/raul0ligma/smelter/entity"
 	executorPkg "github.com/raul0ligma/smelter/executor"
 	"github.com/raul0ligma/smelter/fork"
+	"github.com/raul0ligma/smelter/pkg/log"
 	"github.com/raul0ligma/smelter/pkg/server"
+	"go.uber.org/zap"
 )
 
 type ExecutionCtx struct {
 	Impersonator common.Address
-	Overrides    entity.StateOverrides
-	CreatedAt    time.Time
-	Executor     executor
-	Db           forkDB
+	// Impersonated are the accounts eth_sendTransaction executes as without a
+	// signature, with AutoImpersonate any account is accepted.
+	Impersonated    map[common.Address]bool
+	AutoImpersonate bool
+	Overrides       entity.StateOverrides
+	Accounts        *entity.DevAccounts `json:"-"`
+	// Fork is the upstream block the session is forked from.
+	Fork      entity.ForkConfig
+	CreatedAt time.Time
+	Executor  executor
+	Db        forkDB
+	mu        sync.RWMutex
+	// lastUsed is the unix nano time of the last request, the ttl slides with it
+	lastUsed atomic.Int64
+	// rolling sessions are forked anew at the default fork block when the storage
+	// rolls it, they opt in on creation
+	rolling bool
 }
 
+// LastUsed returns when the session was last accessed.
+func (e *ExecutionCtx) LastUsed() time.Time {
+	return time.Unix(0, e.lastUsed.Load())
+}
+
+func (e *ExecutionCtx) touch() {
+	e.lastUsed.Store(time.Now().UnixNano())
+}
+
+// Size estimates the memory held by the session state and its blocks.
+func (e *ExecutionCtx) Size() entity.SessionSize {
+	var size entity.SessionSize
+	e.Db.AddSize(&size)
+	size.AddBlocks(e.Executor.BlockStorage())
+	size.Estimate()
+	return size
+}
+
+// Impersonate adds addr to the impersonated accounts and makes it the sender of
+// raw transactions.
+func (e *ExecutionCtx) Impersonate(addr common.Address) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.Impersonator = addr
+	if e.Impersonated == nil {
+		e.Impersonated = make(map[common.Address]bool)
+	}
+	e.Impersonated[addr] = true
+}
+
+// StopImpersonating removes addr from the impersonated accounts.
+func (e *ExecutionCtx) StopImpersonating(addr common.Address) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.Impersonator == addr {
+		e.Impersonator = common.Address{}
+	}
+	delete(e.Impersonated, addr)
+}
+
+// StopImpersonatingAll removes every impersonated account.
+func (e *ExecutionCtx) StopImpersonatingAll() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.Impersonator = common.Address{}
+	e.Impersonated = nil
+}
+
+// Sender returns the impersonated sender of raw transactions, the zero address
+// when there is none.
+func (e *ExecutionCtx) Sender() common.Address {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.Impersonator
+}
+
+// IsImpersonated reports whether transactions of addr are executed unsigned.
+func (e *ExecutionCtx) IsImpersonated(addr common.Address) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.AutoImpersonate || e.Impersonated[addr]
+}
+
+// SetAutoImpersonate toggles accepting any sender for unsigned transactions.
+func (e *ExecutionCtx) SetAutoImpersonate(enabled bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.AutoImpersonate = enabled
+}
+
+// StateOverrides returns the overrides applied to the transactions and calls of
+// the session.
+func (e *ExecutionCtx) StateOverrides() entity.StateOverrides {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.Overrides
+}
+
+// SetStateOverrides replaces the overrides of the session.
+func (e *ExecutionCtx) SetStateOverrides(overrides entity.StateOverrides) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.Overrides = overrides
+}
+
+// info describes the session without counting as an access.
+func (e *ExecutionCtx) info(key string) entity.SessionInfo {
+	_, latest := e.Executor.Latest()
+	return entity.SessionInfo{
+		Key:         key,
+		CreatedAt:   e.CreatedAt,
+		LastUsed:    e.LastUsed(),
+		ForkBlock:   hexutil.Uint64(e.Fork.ForkBlock.Uint64()),
+		BlockNumber: hexutil.Uint64(latest),
+		Size:        e.Size(),
+	}
+}
+
+// clone copies the session along with its state, blocks and transactions.
+func (e *ExecutionCtx) clone() *ExecutionCtx {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	exec, db := e.Executor.Clone()
+	clone := &ExecutionCtx{
+		Impersonator:    e.Impersonator,
+		Impersonated:    maps.Clone(e.Impersonated),
+		AutoImpersonate: e.AutoImpersonate,
+		Overrides:       e.Overrides.Clone(),
+		Accounts:        e.Accounts,
+		Fork:            e.Fork,
+		CreatedAt:       time.Now(),
+		Executor:        exec,
+		Db:              db,
+		rolling:         e.rolling,
+	}
+	clone.touch()
+
+	return clone
+}
+
+const (
+	stateFileExt   = ".json"
+	stateTmpPrefix = ".tmp-"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionExists   = errors.New("session already exists")
+)
+
 type ExecutionCtxStorage struct {
 	cfg             entity.ForkConfig
 	reader          entity.ChainStateAndTransactionReader
+	base            *fork.Base
 	mu              sync.RWMutex
 	storage         map[string]*ExecutionCtx
 	executionCtxTTL time.Duration
+	maxSessions     int
+	accounts        *entity.DevAccounts
+	logger          log.Logger
+	stateDir        string
+	chainName       string
+	rollEvery       uint64
+	rollInterval    time.Duration
+	flattenEvery    int
+	onReset         ResetHook
+	created         uint64
+	expired         uint64
+	evicted         uint64
+	evictions       []eviction
+}
+
+// eviction is a dropped session waiting to be reported once the lock is released.
+type eviction struct {
+	key     string
+	execCtx *ExecutionCtx
+	reason  string
+}
+
+type StorageOption func(*ExecutionCtxStorage)
+
+// ResetHook is called after the session of key is forked anew, it's meant to
+// re-apply the setup the session had before.
+type ResetHook func(ctx context.Context, key string, execCtx *ExecutionCtx) error
+
+// WithRollingFork moves the default fork block to the upstream head once the head
+// is every blocks ahead, the head is polled on every interval. The sessions at the
+// default fork block are forked anew.
+func WithRollingFork(every uint64, interval time.Duration) StorageOption {
+	return func(e *ExecutionCtxStorage) {
+		e.rollEvery = every
+		e.rollInterval = interval
+	}
+}
+
+// WithFlattenEvery makes every n-th mined block of a session keep a full copy of
+// the state instead of only its changes, 0 never flattens.
+func WithFlattenEvery(n int) StorageOption {
+	return func(e *ExecutionCtxStorage) {
+		e.flattenEvery = n
+	}
+}
+
+// WithStateDir sets the directory the sessions are persisted to, see Persist and Restore.
+func WithStateDir(dir string) StorageOption {
+	return func(e *ExecutionCtxStorage) {
+		e.stateDir = dir
+	}
+}
+
+// WithChainName names the chain of the storage, its sessions are persisted under
+// the name so chains forked from the same upstream chain don't share their files.
+func WithChainName(name string) StorageOption {
+	return func(e *ExecutionCtxStorage) {
+		e.chainName = name
+	}
+}
+
+// WithResetHook sets the hook called after a session is forked anew.
+func WithResetHook(hook ResetHook) StorageOption {
+	return func(e *ExecutionCtxStorage) {
+		e.onReset = hook
+	}
+}
+
+// WithResetState loads the accounts of dump into every session forked anew, the
+// blocks and transactions of the dump are left out as they belong to another fork.
+func WithResetState(dump *entity.StateDump) StorageOption {
+	return WithResetHook(func(ctx context.Context, key string, execCtx *ExecutionCtx) error {
+		return execCtx.Executor.Load(ctx, &entity.StateDump{Accounts: dump.Accounts})
+	})
+}
+
+// WithDevAccounts funds the dev accounts in every new session.
+func WithDevAccounts(accounts *entity.DevAccounts) StorageOption {
+	return func(e *ExecutionCtxStorage) {
+		e.accounts = accounts
+	}
+}
+
+// WithMaxSessions caps the number of sessions, the least recently used session is
+// evicted to make room for a new one. Zero means no limit.
+func WithMaxSessions(n int) StorageOption {
+	return func(e *ExecutionCtxStorage) {
+		e.maxSessions = n
+	}
+}
+
+// WithLogger sets the logger the evictions are reported to.
+func WithLogger(logger log.Logger) StorageOption {
+	return func(e *ExecutionCtxStorage) {
+		e.logger = logger
+	}
 }
 
 func NewExecutionStorage(
 	cfg entity.ForkConfig,
 	reader entity.ChainStateAndTransactionReader,
 	executionCtxTTL time.Duration,
+	opts ...StorageOption,
 ) *ExecutionCtxStorage {
-	return &ExecutionCtxStorage{
+	e := &ExecutionCtxStorage{
 		cfg:             cfg,
 		reader:          reader,
+		base:            fork.NewBase(reader),
 		executionCtxTTL: executionCtxTTL,
 		storage:         make(map[string]*ExecutionCtx),
+		logger:          zap.NewNop(),
+	}
+
+	for _, opt := range opts {
+		opt(e)
 	}
+
+	return e
 }
 
 func (e *ExecutionCtxStorage) cleanup() {
+	defer e.logEvictions()
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	for k, v := range e.storage {
-		if time.Now().After(v.CreatedAt.Add(e.executionCtxTTL)) {
-			delete(e.storage, k)
+		if time.Now().After(v.LastUsed().Add(e.executionCtxTTL)) {
+			e.evict(k, v, "expired")
+			e.expired++
+		}
+	}
+	e.retainBase()
+}
+
+// retainBase drops the base layers of the fork blocks no session is forked from,
+// the caller holds the lock.
+func (e *ExecutionCtxStorage) retainBase() {
+	blocks := map[uint64]bool{e.cfg.ForkBlock.Uint64(): true}
+	for _, v := range e.storage {
+		blocks[v.Fork.ForkBlock.Uint64()] = true
+	}
+
+	e.base.Retain(blocks)
+}
+
+// evictLRU drops the least recently used sessions until there is room for one more.
+func (e *ExecutionCtxStorage) evictLRU() {
+	for e.maxSessions > 0 && len(e.storage) >= e.maxSessions {
+		var (
+			oldestKey string
+			oldest    *ExecutionCtx
+		)
+		for k, v := range e.storage {
+			if oldest == nil || v.lastUsed.Load() < oldest.lastUsed.Load() {
+				oldestKey, oldest = k, v
+			}
 		}
+
+		e.evict(oldestKey, oldest, "lru")
+		e.evicted++
 	}
 }
 
+// evict drops the session of key, the caller holds the lock and reports the
+// eviction with logEvictions once it's released, which also removes its file.
+func (e *ExecutionCtxStorage) evict(key string, execCtx *ExecutionCtx, reason string) {
+	delete(e.storage, key)
+	e.evictions = append(e.evictions, eviction{key: key, execCtx: execCtx, reason: reason})
+}
+
+// logEvictions reports the pending evictions, sizing a session walks all of its
+// state so it's done without holding the lock.
+func (e *ExecutionCtxStorage) logEvictions() {
+	e.mu.Lock()
+	evictions := e.evictions
+	e.evictions = nil
+	e.mu.Unlock()
+
+	for _, v := range evictions {
+		e.removeSessionFile(v.key)
+
+		size := v.execCtx.Size()
+		e.logger.Info(
+			"evicted execution session",
+			zap.String("key", v.key),
+			zap.String("reason", v.reason),
+			zap.Duration("idle", time.Since(v.execCtx.LastUsed())),
+			zap.Duration("age", time.Since(v.execCtx.CreatedAt)),
+			zap.Int("accounts", size.Accounts),
+			zap.Int("slots", size.Slots),
+			zap.Int("blocks", size.Blocks),
+			zap.Uint64("bytes", size.Bytes),
+		)
+	}
+}
+
+// Metrics returns the session counters along with the estimated memory of the
+// live sessions.
+func (e *ExecutionCtxStorage) Metrics() entity.SessionMetrics {
+	e.mu.RLock()
+	metrics := entity.SessionMetrics{
+		Sessions:    len(e.storage),
+		MaxSessions: e.maxSessions,
+		Created:     e.created,
+		Expired:     e.expired,
+		Evicted:     e.evicted,
+	}
+	sessions := make([]*ExecutionCtx, 0, len(e.storage))
+	for _, v := range e.storage {
+		sessions = append(sessions, v)
+	}
+	e.mu.RUnlock()
+
+	// the sessions are sized outside the lock as it walks all of their state
+	for _, v := range sessions {
+		metrics.Bytes += v.Size().Bytes
+	}
+
+	base := entity.SessionSize{}
+	e.base.AddSize(&base)
+	base.Estimate()
+	metrics.BaseBytes = base.Bytes
+
+	return metrics
+}
+
 func (e *ExecutionCtxStorage) Watcher(ctx context.Context, interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
@@ -69,28 +435,332 @@ func (e *ExecutionCtxStorage) Watcher(ctx context.Context, interval time.Duratio
 }
 
 func (e *ExecutionCtxStorage) create(ctx context.Context, key string) (*ExecutionCtx, error) {
+	defer e.logEvictions()
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	db := fork.NewDB(e.reader, e.cfg, entity.NewAccountsStorage(), entity.NewAccountsState())
+	// another request of the same caller may have won the race for the lock
+	if execCtx, ok := e.storage[key]; ok {
+		execCtx.touch()
+		return execCtx, nil
+	}
+
+	execCtx, err := e.newExecutionCtx(ctx, e.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	e.add(key, execCtx)
+	return execCtx, nil
+}
+
+// CreateSession creates the session of key forked at forkBlock, a nil forkBlock
+// uses the fork block of the storage. A rolling session is forked anew every time
+// the storage rolls its fork block, it can't be pinned to a block.
+func (e *ExecutionCtxStorage) CreateSession(
+	ctx context.Context,
+	key string,
+	forkBlock *big.Int,
+	rolling bool,
+) (*ExecutionCtx, error) {
+	if rolling && forkBlock != nil {
+		return nil, errors.New("a rolling session can't be pinned to a fork block")
+	}
+
+	defer e.logEvictions()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.storage[key]; ok {
+		return nil, ErrSessionExists
+	}
+
+	forkCfg := e.cfg
+	if forkBlock != nil {
+		forkCfg.ForkBlock = forkBlock
+	}
+
+	execCtx, err := e.newExecutionCtx(ctx, forkCfg)
+	if err != nil {
+		return nil, err
+	}
+	execCtx.rolling = rolling
+
+	e.add(key, execCtx)
+	return execCtx, nil
+}
+
+// ResetFork forks the session of the caller anew at forkBlock dropping its state,
+// a nil forkBlock forks at the upstream head.
+func (e *ExecutionCtxStorage) ResetFork(ctx context.Context, forkBlock *big.Int) (*ExecutionCtx, error) {
+	if forkBlock == nil {
+		head, err := e.reader.BlockNumber(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("upstream head error: %w", err)
+		}
+		forkBlock = new(big.Int).SetUint64(head)
+	}
+
+	e.mu.RLock()
+	forkCfg := e.cfg
+	e.mu.RUnlock()
+	forkCfg.ForkBlock = forkBlock
+
+	return e.resetFork(ctx, sessionKey(ctx), forkCfg, false)
+}
+
+// resetFork replaces the session of key with a new one forked at forkCfg and runs
+// the reset hook on it, rolling resets skip the sessions dropped meanwhile.
+func (e *ExecutionCtxStorage) resetFork(
+	ctx context.Context,
+	key string,
+	forkCfg entity.ForkConfig,
+	rolling bool,
+) (*ExecutionCtx, error) {
+	execCtx, err := e.newExecutionCtx(ctx, forkCfg)
+	if err != nil {
+		return nil, err
+	}
+	execCtx.rolling = rolling
+
+	defer e.logEvictions()
+	e.mu.Lock()
+	if _, ok := e.storage[key]; ok {
+		e.storage[key] = execCtx
+	} else if rolling {
+		e.mu.Unlock()
+		return nil, ErrSessionNotFound
+	} else {
+		e.add(key, execCtx)
+	}
+	e.mu.Unlock()
+
+	e.logger.Info(
+		"forked execution session",
+		zap.String("key", key),
+		zap.Uint64("forkBlock", forkCfg.ForkBlock.Uint64()),
+		zap.Bool("rolling", rolling),
+	)
+
+	if e.onReset != nil {
+		if err = e.onReset(ctx, key, execCtx); err != nil {
+			return nil, fmt.Errorf("reset hook error: %w", err)
+		}
+	}
+
+	return execCtx, nil
+}
+
+// Roller moves the default fork block forward, see WithRollingFork.
+func (e *ExecutionCtxStorage) Roller(ctx context.Context) {
+	if e.rollEvery == 0 || e.rollInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(e.rollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := e.roll(ctx); err != nil {
+				e.logger.Error("failed to roll fork", zap.Error(err))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (e *ExecutionCtxStorage) roll(ctx context.Context) error {
+	head, err := e.reader.BlockNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("upstream head error: %w", err)
+	}
+
+	e.mu.Lock()
+	if head < e.cfg.ForkBlock.Uint64()+e.rollEvery {
+		e.mu.Unlock()
+		return nil
+	}
+
+	e.cfg.ForkBlock = new(big.Int).SetUint64(head)
+	forkCfg := e.cfg
+	keys := make([]string, 0)
+	for k, v := range e.storage {
+		if v.rolling {
+			keys = append(keys, k)
+		}
+	}
+	e.mu.Unlock()
+
+	for _, key := range keys {
+		if _, err = e.resetFork(ctx, key, forkCfg, true); err != nil && !errors.Is(err, ErrSessionNotFound) {
+			e.logger.Error("failed to roll session", zap.String("key", key), zap.Error(err))
+		}
+	}
+
+	e.mu.Lock()
+	e.retainBase()
+	e.mu.Unlock()
+	return nil
+}
+
+// newExecutionCtx creates a session at the fork block with the dev accounts funded,
+// the upstream values are read through the base layer shared by the sessions.
+func (e *ExecutionCtxStorage) newExecutionCtx(ctx context.Context, forkCfg entity.ForkConfig) (*ExecutionCtx, error) {
+	db := fork.NewLayeredDB(e.base, forkCfg, entity.NewAccountsStorage(), entity.NewAccountsState())
 	cfg := config.NewConfigWithDefaults()
-	cfg.ForkConfig = &e.cfg
+	cfg.ForkConfig = &forkCfg
+	cfg.ChainConfig.ChainID = new(big.Int).SetUint64(forkCfg.ChainID)
 
-	exec, err := executorPkg.NewExecutor(ctx, cfg, db, e.reader)
+	exec, err := executorPkg.NewExecutor(ctx, cfg, db, e.reader, executorPkg.WithFlattenEvery(e.flattenEvery))
 	if err != nil {
 		return nil, fmt.Errorf("new executor error: %w", err)
 	}
 
+	for _, addr := range e.accounts.Addresses() {
+		if err = db.SetBalance(ctx, addr, e.accounts.Balance); err != nil {
+			return nil, fmt.Errorf("fund dev account error: %w", err)
+		}
+	}
+
 	execCtx := &ExecutionCtx{
 		CreatedAt: time.Now(),
-		Executor:  exec,
+		Executor:  serialExecutor{exec},
 		Db:        db,
+		Accounts:  e.accounts,
+		Fork:      forkCfg,
 	}
+	execCtx.touch()
 
-	e.storage[key] = execCtx
 	return execCtx, nil
 }
 
+// serialExecutor returns the clones of the executor as the executor interface.
+type serialExecutor struct {
+	*executorPkg.SerialExecutor
+}
+
+func (s serialExecutor) Clone() (executor, forkDB) {
+	exec := s.SerialExecutor.Clone()
+	return serialExecutor{exec}, exec.DB()
+}
+
+// add stores a new session, the caller holds the lock.
+func (e *ExecutionCtxStorage) add(key string, execCtx *ExecutionCtx) {
+	e.evictLRU()
+	e.storage[key] = execCtx
+	e.created++
+}
+
+// List describes every session ordered by key.
+func (e *ExecutionCtxStorage) List() []entity.SessionInfo {
+	e.mu.RLock()
+	live := maps.Clone(e.storage)
+	e.mu.RUnlock()
+
+	// the sessions are sized outside the lock as it walks all of their state
+	sessions := make([]entity.SessionInfo, 0, len(live))
+	for k, v := range live {
+		sessions = append(sessions, v.info(k))
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Key < sessions[j].Key
+	})
+	return sessions
+}
+
+// Info describes the session of key.
+func (e *ExecutionCtxStorage) Info(key string) (entity.SessionInfo, error) {
+	e.mu.RLock()
+	execCtx, ok := e.storage[key]
+	e.mu.RUnlock()
+	if !ok {
+		return entity.SessionInfo{}, ErrSessionNotFound
+	}
+
+	return execCtx.info(key), nil
+}
+
+// Reset replaces the session of key with a new one at its fork block, a rolling
+// session stays rolling and the reset hook runs on the new one.
+func (e *ExecutionCtxStorage) Reset(ctx context.Context, key string) error {
+	e.mu.RLock()
+	current, ok := e.storage[key]
+	e.mu.RUnlock()
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	execCtx, err := e.newExecutionCtx(ctx, current.Fork)
+	if err != nil {
+		return err
+	}
+	execCtx.rolling = current.rolling
+
+	e.mu.Lock()
+	if _, ok := e.storage[key]; !ok {
+		e.mu.Unlock()
+		return ErrSessionNotFound
+	}
+	e.storage[key] = execCtx
+	e.mu.Unlock()
+
+	if e.onReset != nil {
+		if err = e.onReset(ctx, key, execCtx); err != nil {
+			return fmt.Errorf("reset hook error: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Delete drops the session of key.
+func (e *ExecutionCtxStorage) Delete(key string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.storage[key]; !ok {
+		return ErrSessionNotFound
+	}
+
+	delete(e.storage, key)
+	e.removeSessionFile(key)
+	return nil
+}
+
+// Clone copies the session of src into the new session dst, the copy is taken
+// under the lock of the source session so the storage stays available meanwhile.
+func (e *ExecutionCtxStorage) Clone(src, dst string) error {
+	e.mu.RLock()
+	execCtx, ok := e.storage[src]
+	_, exists := e.storage[dst]
+	e.mu.RUnlock()
+	if !ok {
+		return ErrSessionNotFound
+	}
+	if exists {
+		return ErrSessionExists
+	}
+
+	// the source counts as used so it isn't the one evicted to make room
+	execCtx.touch()
+	clone := execCtx.clone()
+
+	defer e.logEvictions()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// another request may have taken dst while the source was copied
+	if _, ok = e.storage[dst]; ok {
+		return ErrSessionExists
+	}
+
+	e.add(dst, clone)
+	return nil
+}
+
 func (e *ExecutionCtxStorage) getOrCreate(ctx context.Context, key string) (*ExecutionCtx, error) {
 	e.mu.RLock()
 	execCtx, ok := e.storage[key]
@@ -99,6 +769,7 @@ func (e *ExecutionCtxStorage) getOrCreate(ctx context.Context, key string) (*Exe
 		return e.create(ctx, key)
 	}
 
+	execCtx.touch()
 	return execCtx, nil
 }
 
@@ -107,17 +778,196 @@ func (e *ExecutionCtxStorage) Get(key string) (*ExecutionCtx, error) {
 	defer e.mu.RUnlock()
 	execCtx, ok := e.storage[key]
 	if !ok {
-		return nil, errors.New("not found")
+		return nil, ErrSessionNotFound
 	}
 
+	execCtx.touch()
 	return execCtx, nil
 }
 
 func (e *ExecutionCtxStorage) GetOrCreate(ctx context.Context) (*ExecutionCtx, error) {
+	return e.getOrCreate(ctx, sessionKey(ctx))
+}
+
+// sessionKey returns the session key of the request.
+func sessionKey(ctx context.Context) string {
 	caller, ok := ctx.Value(server.Key{}).(string)
 	if !ok {
 		caller = "default"
 	}
 
-	return e.getOrCreate(ctx, caller)
+	return caller
+}
+
+// sessionsDir is the directory of the sessions of the chain, chains are told
+// apart by their name and by their id when they have none.
+func (e *ExecutionCtxStorage) sessionsDir() string {
+	if e.chainName != "" {
+		return filepath.Join(e.stateDir, url.PathEscape(e.chainName))
+	}
+
+	return filepath.Join(e.stateDir, strconv.FormatUint(e.cfg.ChainID, 10))
+}
+
+// sessionFile is the file the session of key is persisted to.
+func (e *ExecutionCtxStorage) sessionFile(key string) string {
+	return filepath.Join(e.sessionsDir(), url.PathEscape(key)+stateFileExt)
+}
+
+// removeSessionFile removes the persisted file of the session of key so a dropped
+// session isn't restored on the next start.
+func (e *ExecutionCtxStorage) removeSessionFile(key string) {
+	if e.stateDir == "" {
+		return
+	}
+
+	if err := os.Remove(e.sessionFile(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		e.logger.Error("failed to remove session file", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// Persist writes a dump of every session to the state dir, one file per session,
+// and removes the files of the sessions dropped since. The files are renamed into
+// place so a crash never leaves a session half written.
+func (e *ExecutionCtxStorage) Persist(ctx context.Context) error {
+	if e.stateDir == "" {
+		return nil
+	}
+
+	dir := e.sessionsDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("state dir error: %w", err)
+	}
+
+	e.mu.RLock()
+	sessions := maps.Clone(e.storage)
+	e.mu.RUnlock()
+
+	for key, execCtx := range sessions {
+		dump, err := execCtx.Executor.Dump(ctx)
+		if err != nil {
+			return fmt.Errorf("dump session %s: %w", key, err)
+		}
+
+		data, err := json.Marshal(dump)
+		if err != nil {
+			return fmt.Errorf("encode session %s: %w", key, err)
+		}
+
+		if err = writeFileAtomic(e.sessionFile(key), data); err != nil {
+			return fmt.Errorf("write session %s: %w", key, err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("state dir error: %w", err)
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), stateFileExt)
+		if entry.IsDir() || !ok {
+			continue
+		}
+
+		if key, err := url.PathUnescape(name); err == nil {
+			if _, live := sessions[key]; live {
+				continue
+			}
+		}
+		if err = os.Remove(filepath.Join(dir, entry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove stale session %s: %w", name, err)
+		}
+	}
+
+	e.logger.Info("persisted execution sessions", zap.String("dir", dir), zap.Int("sessions", len(sessions)))
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it to path.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), stateTmpPrefix)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+// Restore recreates the sessions persisted to the state dir at their fork block.
+func (e *ExecutionCtxStorage) Restore(ctx context.Context) error {
+	if e.stateDir == "" {
+		return nil
+	}
+
+	dir := e.sessionsDir()
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("state dir error: %w", err)
+	}
+
+	var restored int
+	for _, entry := range entries {
+		// a session left half written by a crash is dropped
+		if strings.HasPrefix(entry.Name(), stateTmpPrefix) {
+			os.Remove(filepath.Join(dir, entry.Name()))
+			continue
+		}
+
+		name, ok := strings.CutSuffix(entry.Name(), stateFileExt)
+		if entry.IsDir() || !ok {
+			continue
+		}
+
+		key, err := url.PathUnescape(name)
+		if err != nil {
+			return fmt.Errorf("invalid session file %s: %w", entry.Name(), err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("read session %s: %w", key, err)
+		}
+
+		dump, err := entity.DecodeStateDump(data)
+		if err != nil {
+			return fmt.Errorf("decode session %s: %w", key, err)
+		}
+
+		// sessions at the default fork block are created without pinning it
+		var forkBlock *big.Int
+		e.mu.RLock()
+		if dump.Fork != nil && dump.Fork.ForkBlock != nil && dump.Fork.ForkBlock.Cmp(e.cfg.ForkBlock) != 0 {
+			forkBlock = dump.Fork.ForkBlock
+		}
+		e.mu.RUnlock()
+
+		execCtx, err := e.CreateSession(ctx, key, forkBlock, false)
+		if err != nil {
+			return fmt.Errorf("restore session %s: %w", key, err)
+		}
+
+		if err = execCtx.Executor.Load(ctx, dump); err != nil {
+			return fmt.Errorf("load session %s: %w", key, err)
+		}
+		restored++
+	}
+
+	e.logger.Info("restored execution sessions", zap.String("dir", dir), zap.Int("sessions", restored))
+	return nil
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/raul0ligma/smelter/entity"
 	"github.com/raul0ligma/smelter/fork"
 )
@@ -30,10 +31,53 @@ type executor interface {
 		tracer entity.TraceProvider,
 		db *fork.DB,
 		overrides entity.StateOverrides,
+		blockOverrides *entity.BlockOverrides,
 	) (ret []byte, leftOverGas uint64, err error)
+	Trace(
+		ctx context.Context,
+		tx ethereum.CallMsg,
+		tracer entity.TraceProvider,
+		db *fork.DB,
+		header *types.Header,
+		overrides entity.StateOverrides,
+	) (ret []byte, leftOverGas uint64, err error)
+	Simulate(
+		ctx context.Context,
+		db *fork.DB,
+		parent *types.Header,
+		opts entity.SimulateOpts,
+	) ([]*entity.SimulatedBlock, error)
+	CallBundle(
+		ctx context.Context,
+		txs []*types.Transaction,
+		overrides entity.StateOverrides,
+		blockOverrides *entity.BlockOverrides,
+		commit bool,
+	) (*entity.BundleResult, error)
+	CallWithDiff(
+		ctx context.Context,
+		tx ethereum.CallMsg,
+		overrides entity.StateOverrides,
+	) (*entity.SimulationDiff, error)
+	StorageSlot(
+		ctx context.Context,
+		tx ethereum.CallMsg,
+		overrides entity.StateOverrides,
+	) (*entity.StorageSlot, error)
+	FindStorageSlots(
+		ctx context.Context,
+		tx ethereum.CallMsg,
+		overrides entity.StateOverrides,
+	) ([]*entity.SlotCandidate, error)
+	SetStorageAt(ctx context.Context, addr common.Address, slot, value common.Hash) error
+	ChainConfig() *params.ChainConfig
 	TxnStorage() *entity.TransactionStorage
 	BlockStorage() *entity.BlockStorage
 	Latest() (common.Hash, uint64)
+	// Clone returns an executor with a copy of the session along with its state.
+	Clone() (executor, forkDB)
+	Dump(ctx context.Context) (*entity.StateDump, error)
+	Load(ctx context.Context, dump *entity.StateDump) error
 }
 
 type forkDB interface {
@@ -49,15 +93,20 @@ type forkDB interface {
 	GetState(ctx context.Context, addr common.Address, hash common.Hash) (common.Hash, error)
 	ApplyState(s *entity.AccountsState)
 	ApplyStorage(s *entity.AccountsStorage)
+	Copy() (*entity.AccountsStorage, *entity.AccountsState)
+	AddSize(size *entity.SessionSize)
 }
 
 type executionCtx interface {
 	GetOrCreate(ctx context.Context) (*ExecutionCtx, error)
+	CreateSession(ctx context.Context, key string, forkBlock *big.Int, rolling bool) (*ExecutionCtx, error)
+	ResetFork(ctx context.Context, forkBlock *big.Int) (*ExecutionCtx, error)
 }
 
 type otterscanBackend interface {
-	GetCode(ctx context.Context, account common.Address, blockNumber string) (string, error)
-	GetBlockByNumber(ctx context.Context, number string, transactionDetailFlag bool) (*entity.SerializedBlock, error)
+	GetCode(ctx context.Context, account common.Address, blockNumber blockRef) (string, error)
+	GetBlockByNumber(ctx context.Context, number blockRef, transactionDetailFlag bool) (*entity.SerializedBlock, error)
+	GetBlockByHash(ctx context.Context, hash common.Hash) (*entity.SerializedBlock, error)
 	GetTransactionByHash(ctx context.Context, txHash common.Hash) (*entity.SerializedTransaction, error)
 	GetTransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
 }
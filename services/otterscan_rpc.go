@@ -2,77 +2,238 @@ package services
 
 import (
 	"context"
-	"errors"
+	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/tracer"
+	"go.uber.org/zap"
 )
 
+// OtterscanRPC serves the otterscan ots_* namespace, local transactions are
+// answered from the transaction storage while pre-fork history is forwarded to
+// the upstream.
 type OtterscanRPC struct {
-	execStorage executionCtx
-	backend     otterscanBackend
+	execStorage     executionCtx
+	backend         otterscanBackend
+	readerAndCaller readerAndCaller
+	cfg             entity.ForkConfig
+	logger          *zap.SugaredLogger
 }
 
-func NewOtterscanRpc(backend otterscanBackend, execStorage executionCtx) *OtterscanRPC {
-	return &OtterscanRPC{backend: backend, execStorage: execStorage}
+func NewOtterscanRpc(
+	backend otterscanBackend,
+	execStorage executionCtx,
+	cfg entity.ForkConfig,
+	readerAndCaller readerAndCaller,
+) *OtterscanRPC {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync() // flushes buffer, if any
+
+	return &OtterscanRPC{
+		backend:         backend,
+		execStorage:     execStorage,
+		cfg:             cfg,
+		readerAndCaller: readerAndCaller,
+		logger:          logger.Sugar(),
+	}
 }
 
 func (o *OtterscanRPC) GetApiLevel(_ context.Context) (int, error) {
 	return 8, nil
 }
 
-func (o *OtterscanRPC) HasCode(ctx context.Context, address common.Address, block interface{}) (bool, error) {
-	blockTag, ok := block.(string)
-	if !ok {
-		blockNum, ok := block.(uint64)
-		if !ok {
-			return false, errors.New("invalid block value")
-		}
-		blockTag = fmt.Sprintf("%d", blockNum)
+func (o *OtterscanRPC) HasCode(ctx context.Context, address common.Address, block blockRef) (bool, error) {
+	code, err := o.backend.GetCode(ctx, address, block)
+	if err != nil {
+		return false, err
 	}
 
-	code, err := o.backend.GetCode(ctx, address, blockTag)
+	return len(code) > len(hexPrefix), nil
+}
+
+// GetContractCreator returns the deployment transaction and deployer of a contract,
+// null is returned for accounts without code.
+func (o *OtterscanRPC) GetContractCreator(ctx context.Context, address common.Address) (*entity.ContractCreator, error) {
+	o.logger.Debug("Called GetContractCreator", zap.String("address", address.Hex()))
+
+	execCtx, err := o.execStorage.GetOrCreate(ctx)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+
+	if creator := execCtx.Executor.TxnStorage().GetContractCreator(address); creator != nil {
+		return creator, nil
+	}
+
+	code, err := execCtx.Db.GetCode(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(code) == 0 {
+		return nil, nil
+	}
+
+	var creator *entity.ContractCreator
+	if err := o.readerAndCaller.CallContext(ctx, &creator, "ots_getContractCreator", address); err != nil {
+		return nil, err
 	}
 
-	return len(code) > 0, nil
+	return creator, nil
 }
 
-func (o *OtterscanRPC) GetContractCreator(_ context.Context, _ common.Address) (common.Address, error) {
-	return common.HexToAddress(""), nil
+func (o *OtterscanRPC) GetTransactionBySenderAndNonce(
+	ctx context.Context,
+	sender common.Address,
+	nonce uint64,
+) (*common.Hash, error) {
+	o.logger.Debug("Called GetTransactionBySenderAndNonce", zap.String("sender", sender.Hex()), zap.Uint64("nonce", nonce))
+
+	execCtx, err := o.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if hash, ok := execCtx.Executor.TxnStorage().GetBySenderAndNonce(sender, nonce); ok {
+		return &hash, nil
+	}
+
+	var hash *common.Hash
+	if err := o.readerAndCaller.CallContext(ctx, &hash, "ots_getTransactionBySenderAndNonce", sender, nonce); err != nil {
+		return nil, err
+	}
+
+	return hash, nil
 }
 
+// SearchTransactionsBefore returns a page of the transactions touching address in
+// blocks before blockNumber, newest first. A zero blockNumber starts at the tip.
+// Pages which run past the fork block are completed from the upstream.
 func (o *OtterscanRPC) SearchTransactionsBefore(
 	ctx context.Context,
 	address common.Address,
-	from int,
-	to int,
+	blockNumber uint64,
+	pageSize int,
 ) (*entity.TransactionSearchResponse, error) {
-	storage, err := o.execStorage.GetOrCreate(ctx)
+	o.logger.Debug("Called SearchTransactionsBefore", zap.String("address", address.Hex()), zap.Uint64("blockNumber", blockNumber), zap.Int("pageSize", pageSize))
+
+	execCtx, err := o.execStorage.GetOrCreate(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	txStorage := storage.Executor.TxnStorage()
-	resp := &entity.TransactionSearchResponse{
-		Txs:      make([]*entity.SerializedTransaction, 0),
-		Receipts: make([]*entity.SerializedReceipt, 0),
+	resp := entity.NewTransactionSearchResponse()
+	resp.FirstPage = blockNumber == 0
+
+	bound := uint64(math.MaxUint64)
+	var cursor *entity.TxPosition
+	if blockNumber != 0 {
+		bound = blockNumber
+		cursor = &entity.TxPosition{BlockNumber: hexutil.Uint64(blockNumber)}
+	}
+
+	txStorage := execCtx.Executor.TxnStorage()
+	if err := addIndexedTxs(resp, execCtx.Executor, accountPage(txStorage, address, cursor, pageSize, true)); err != nil {
+		return nil, err
 	}
 
-	for _, tx := range txStorage.All() {
-		receipt := txStorage.GetReceipt(tx.Hash())
-		resp.Txs = append(resp.Txs, entity.SerializeTransaction(tx, receipt))
-		resp.Receipts = append(resp.Receipts, entity.SerializeReceipt(receipt))
+	if len(resp.Txs) >= pageSize {
+		return resp, nil
 	}
 
+	upstream, err := o.searchUpstream(
+		ctx, "ots_searchTransactionsBefore", address, min(bound, execCtx.Fork.ForkBlock.Uint64()+1), pageSize-len(resp.Txs),
+	)
+	if err != nil {
+		if len(resp.Txs) == 0 {
+			return nil, err
+		}
+
+		o.logger.Warn("failed to search upstream transactions", zap.Error(err))
+		resp.LastPage = true
+		return resp, nil
+	}
+
+	resp.Txs = append(resp.Txs, upstream.Txs...)
+	resp.Receipts = append(resp.Receipts, upstream.Receipts...)
+	resp.LastPage = upstream.LastPage
+	return resp, nil
+}
+
+// SearchTransactionsAfter returns a page of the transactions touching address in
+// blocks after blockNumber, the page itself is ordered newest first.
+func (o *OtterscanRPC) SearchTransactionsAfter(
+	ctx context.Context,
+	address common.Address,
+	blockNumber uint64,
+	pageSize int,
+) (*entity.TransactionSearchResponse, error) {
+	o.logger.Debug("Called SearchTransactionsAfter", zap.String("address", address.Hex()), zap.Uint64("blockNumber", blockNumber), zap.Int("pageSize", pageSize))
+
+	execCtx, err := o.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := entity.NewTransactionSearchResponse()
+	resp.LastPage = blockNumber == 0
+
+	forkBlock := execCtx.Fork.ForkBlock.Uint64()
+	upstream := entity.NewTransactionSearchResponse()
+	if blockNumber < forkBlock {
+		if upstream, err = o.searchUpstream(ctx, "ots_searchTransactionsAfter", address, blockNumber, pageSize); err != nil {
+			return nil, err
+		}
+
+		// the upstream chain continues past the fork block
+		if upstream, err = truncateSearch(upstream, forkBlock); err != nil {
+			return nil, err
+		}
+
+		if len(upstream.Txs) >= pageSize {
+			return upstream, nil
+		}
+	}
+
+	txStorage := execCtx.Executor.TxnStorage()
+	cursor := &entity.TxPosition{BlockNumber: hexutil.Uint64(blockNumber), TransactionIndex: math.MaxUint}
+	page := accountPage(txStorage, address, cursor, pageSize-len(upstream.Txs), false)
+
+	resp.FirstPage = true
+	if len(page) > 0 {
+		newer := txStorage.AccountTransactions(address, &page[len(page)-1].Position, 1, false)
+		resp.FirstPage = len(newer) == 0
+	}
+
+	for i, j := 0, len(page)-1; i < j; i, j = i+1, j-1 {
+		page[i], page[j] = page[j], page[i]
+	}
+
+	if err := addIndexedTxs(resp, execCtx.Executor, page); err != nil {
+		return nil, err
+	}
+
+	resp.Txs = append(resp.Txs, upstream.Txs...)
+	resp.Receipts = append(resp.Receipts, upstream.Receipts...)
 	return resp, nil
 }
 
 func (o *OtterscanRPC) GetBlockDetails(ctx context.Context, block uint64) (*entity.BlockDetailResponse, error) {
-	b, err := o.backend.GetBlockByNumber(ctx, strconv.FormatUint(block, 10), false)
+	b, err := o.backend.GetBlockByNumber(ctx, newBlockRef(strconv.FormatUint(block, 10)), false)
+	if err != nil {
+		return nil, err
+	}
+
+	return entity.SerializeBlockDetail(b.Raw), nil
+}
+
+func (o *OtterscanRPC) GetBlockDetailsByHash(ctx context.Context, hash common.Hash) (*entity.BlockDetailResponse, error) {
+	b, err := o.backend.GetBlockByHash(ctx, hash)
 	if err != nil {
 		return nil, err
 	}
@@ -80,17 +241,58 @@ func (o *OtterscanRPC) GetBlockDetails(ctx context.Context, block uint64) (*enti
 	return entity.SerializeBlockDetail(b.Raw), nil
 }
 
-func (o *OtterscanRPC) GetTransactionError(_ context.Context, _ common.Hash) (string, error) {
-	return "0x", nil
+// GetTransactionError returns the revert data of a failed transaction and 0x for
+// successful ones.
+func (o *OtterscanRPC) GetTransactionError(ctx context.Context, hash common.Hash) (string, error) {
+	o.logger.Debug("Called GetTransactionError", zap.String("hash", hash.Hex()))
+
+	execCtx, err := o.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	block, index, err := storedTxBlock(execCtx, hash)
+	if err != nil {
+		return "", err
+	}
+
+	if block == nil {
+		var data string
+		if err := o.readerAndCaller.CallContext(ctx, &data, "ots_getTransactionError", hash); err != nil {
+			return "", err
+		}
+		return data, nil
+	}
+
+	r, err := prepareReplay(execCtx, o.readerAndCaller, execCtx.Fork, block, index)
+	if err != nil {
+		return "", err
+	}
+
+	ret, _, err := execCtx.Executor.Trace(ctx, r.msg, tracer.NewTracer(false), r.db, r.header, nil)
+	if err == nil {
+		return hexPrefix, nil
+	}
+
+	return hexutil.Encode(ret), nil
 }
 
+// GetBlockTransactions returns a page of the transactions of a block, pages are
+// counted from the end of the block like otterscan expects.
 func (o *OtterscanRPC) GetBlockTransactions(
 	ctx context.Context,
 	block uint64,
-	from int,
-	to int,
+	pageNumber int,
+	pageSize int,
 ) (*entity.BlockTransactionsResponse, error) {
-	b, err := o.backend.GetBlockByNumber(ctx, strconv.FormatUint(block, 10), false)
+	o.logger.Debug("Called GetBlockTransactions", zap.Uint64("block", block), zap.Int("pageNumber", pageNumber), zap.Int("pageSize", pageSize))
+
+	execCtx, err := o.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := o.backend.GetBlockByNumber(ctx, newBlockRef(strconv.FormatUint(block, 10)), false)
 	if err != nil {
 		return nil, err
 	}
@@ -104,24 +306,134 @@ func (o *OtterscanRPC) GetBlockTransactions(
 	}
 
 	txns := b.Raw.Transactions()
-	for i := 0; i < 2 && i < len(b.Raw.Transactions()); i++ {
-		receipt, err := o.backend.GetTransactionReceipt(ctx, txns[i].Hash())
+	pageEnd := max(len(txns)-pageNumber*pageSize, 0)
+	pageStart := max(pageEnd-pageSize, 0)
+	for _, txn := range txns[pageStart:pageEnd] {
+		receipt, err := o.backend.GetTransactionReceipt(ctx, txn.Hash())
 		if err != nil {
 			return nil, err
 		}
 
-		resp.FullBlock.Transactions = append(resp.FullBlock.Transactions, entity.SerializeTransaction(txns[i], receipt))
-		resp.Receipts = append(resp.Receipts, entity.SerializeReceipt(receipt))
+		sender := txSender(execCtx.Executor, txn)
+		resp.FullBlock.Transactions = append(resp.FullBlock.Transactions, entity.SerializeTransaction(txn, receipt, sender))
+		resp.Receipts = append(resp.Receipts, entity.SerializeReceipt(receipt, sender, b.Raw.Time()))
 	}
 
 	return resp, nil
 }
 
+// GetInternalOperations returns the value transfers, creations and self destructs
+// which happened inside a transaction.
+func (o *OtterscanRPC) GetInternalOperations(ctx context.Context, hash common.Hash) ([]*entity.InternalOperation, error) {
+	o.logger.Debug("Called GetInternalOperations", zap.String("hash", hash.Hex()))
+
+	execCtx, err := o.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if execCtx.Executor.TxnStorage().GetTransaction(hash) != nil {
+		return execCtx.Executor.TxnStorage().GetTrace(hash).InternalOperations(), nil
+	}
+
+	var ops []*entity.InternalOperation
+	if err := o.readerAndCaller.CallContext(ctx, &ops, "ots_getInternalOperations", hash); err != nil {
+		return nil, err
+	}
+
+	return ops, nil
+}
+
 func (o *OtterscanRPC) TraceTransaction(ctx context.Context, hash common.Hash) (entity.TransactionTraces, error) {
+	o.logger.Debug("Called TraceTransaction", zap.String("hash", hash.Hex()))
+
 	exec, err := o.execStorage.GetOrCreate(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return exec.Executor.TxnStorage().GetTrace(hash), nil
+	if exec.Executor.TxnStorage().GetTransaction(hash) != nil {
+		return exec.Executor.TxnStorage().GetTrace(hash), nil
+	}
+
+	var traces entity.TransactionTraces
+	if err := o.readerAndCaller.CallContext(ctx, &traces, "ots_traceTransaction", hash); err != nil {
+		return nil, err
+	}
+
+	return traces, nil
+}
+
+func (o *OtterscanRPC) searchUpstream(
+	ctx context.Context,
+	method string,
+	address common.Address,
+	blockNumber uint64,
+	pageSize int,
+) (*entity.TransactionSearchResponse, error) {
+	resp := entity.NewTransactionSearchResponse()
+	if err := o.readerAndCaller.CallContext(ctx, resp, method, address, blockNumber, pageSize); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Txs) != len(resp.Receipts) {
+		return nil, fmt.Errorf("%s returned %d txs and %d receipts", method, len(resp.Txs), len(resp.Receipts))
+	}
+
+	return resp, nil
+}
+
+// truncateSearch drops the upstream transactions mined after the fork block.
+func truncateSearch(resp *entity.TransactionSearchResponse, forkBlock uint64) (*entity.TransactionSearchResponse, error) {
+	truncated := entity.NewTransactionSearchResponse()
+	truncated.LastPage = resp.LastPage
+	for i, raw := range resp.Txs {
+		var tx struct {
+			BlockNumber hexutil.Uint64 `json:"blockNumber"`
+		}
+		if err := json.Unmarshal(raw, &tx); err != nil {
+			return nil, err
+		}
+
+		if uint64(tx.BlockNumber) > forkBlock {
+			continue
+		}
+
+		truncated.Txs = append(truncated.Txs, raw)
+		truncated.Receipts = append(truncated.Receipts, resp.Receipts[i])
+	}
+
+	return truncated, nil
+}
+
+// accountPage returns up to pageSize local transactions of address following
+// cursor, the page is extended past pageSize so that it never splits a block.
+func accountPage(
+	txStorage *entity.TransactionStorage,
+	address common.Address,
+	cursor *entity.TxPosition,
+	pageSize int,
+	desc bool,
+) []*entity.IndexedTransaction {
+	page := txStorage.AccountTransactions(address, cursor, pageSize, desc)
+	for len(page) > 0 {
+		last := page[len(page)-1]
+		next := txStorage.AccountTransactions(address, &last.Position, 1, desc)
+		if len(next) == 0 || next[0].Position.BlockNumber != last.Position.BlockNumber {
+			break
+		}
+		page = append(page, next[0])
+	}
+
+	return page
+}
+
+func addIndexedTxs(resp *entity.TransactionSearchResponse, exec executor, txs []*entity.IndexedTransaction) error {
+	for _, tx := range txs {
+		if err := resp.Add(serializeLocalTx(exec, tx.Hash)); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/filecoin-project/go-jsonrpc"
+)
+
+type PersonalRpc struct {
+	execStorage executionCtx
+}
+
+func NewPersonalRpc(exec executionCtx) *PersonalRpc {
+	return &PersonalRpc{execStorage: exec}
+}
+
+// Sign signs data with the EIP-191 personal message prefix using a dev account,
+// the optional password is ignored.
+func (p *PersonalRpc) Sign(ctx context.Context, params jsonrpc.RawParams) (hexutil.Bytes, error) {
+	var (
+		data     hexutil.Bytes
+		address  common.Address
+		password string
+	)
+	if err := decodeRawParams(params, 2, &data, &address, &password); err != nil {
+		return nil, err
+	}
+
+	execCtx, err := p.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return execCtx.Accounts.SignText(address, data)
+}
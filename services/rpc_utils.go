@@ -2,14 +2,17 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
-	"strconv"
+	"strings"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/filecoin-project/go-jsonrpc"
 	"github.com/raul0ligma/smelter/entity"
 	"github.com/raul0ligma/smelter/fork"
 )
@@ -19,26 +22,6 @@ const (
 	latestBlock = "latest"
 )
 
-func parseAndValidateBlockNumber(blockNumber string, latest uint64) (*big.Int, error) {
-	if blockNumber == "" || blockNumber == latestBlock {
-		return new(big.Int).SetUint64(latest), nil
-	}
-
-	if len(blockNumber) > 2 && blockNumber[:2] == hexPrefix {
-		block, err := parseBigInt(blockNumber)
-		if err != nil {
-			return nil, err
-		}
-
-		if block.Uint64() > latest {
-			return nil, fmt.Errorf("invalid block height, received %d, current %d", block.Uint64(), latest)
-		}
-		return block, nil
-	}
-
-	return nil, fmt.Errorf("failed to parse block number %s", blockNumber)
-}
-
 func getBlockFromStorageOrReader(
 	exec executor,
 	reader entity.ChainStateAndTransactionReader,
@@ -63,21 +46,25 @@ func getBalanceFromForkDB(ctx context.Context, forkDB forkDB, account common.Add
 		return "0x", err
 	}
 
-	if bal.Uint64() == 0 {
-		return "0x0", nil
+	return encodeBalance(bal), nil
+}
+
+func encodeBalance(bal *big.Int) string {
+	if bal.Sign() == 0 {
+		return "0x0"
 	}
 
-	return hexutil.Encode(bal.Bytes()), nil
+	return hexutil.Encode(bal.Bytes())
 }
 
 func parseBigInt(blockNumber string) (*big.Int, error) {
-	if len(blockNumber) > 2 && blockNumber[:2] != "0x" {
-		num, err := strconv.ParseUint(blockNumber, 10, 64)
-		if err != nil {
-			return nil, err
+	if !strings.HasPrefix(blockNumber, hexPrefix) {
+		num, ok := new(big.Int).SetString(blockNumber, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid number %s", blockNumber)
 		}
 
-		return new(big.Int).SetUint64(num), nil
+		return num, nil
 	}
 
 	if len(blockNumber)%2 != 0 {
@@ -92,10 +79,12 @@ func parseBigInt(blockNumber string) (*big.Int, error) {
 	return new(big.Int).SetBytes(numBytes), nil
 }
 
+// getBalanceFromBlockStorage walks the block states from blockNum down, an account
+// no block changed is read at the fork block.
 func getBalanceFromBlockStorage(
 	ctx context.Context,
 	executor executor,
-	chainID uint64,
+	cfg entity.ForkConfig,
 	reader readerAndCaller,
 	account common.Address,
 	blockNum uint64,
@@ -105,17 +94,11 @@ func getBalanceFromBlockStorage(
 		return "0x", fmt.Errorf("block %d not found in block storage", blockNum)
 	}
 
-	db := fork.NewDB(reader, entity.ForkConfig{
-		ChainID:   chainID,
-		ForkBlock: new(big.Int).SetUint64(blockNum),
-	}, b.Accounts, b.State)
-
-	balAt, err := db.GetBalance(ctx, account)
-	if err != nil {
-		return "0x0", err
+	if state, ok := b.Account(account); ok {
+		return encodeBalance(state.Balance), nil
 	}
 
-	return hexutil.Encode(balAt.Bytes()), nil
+	return getBalanceFromForkDB(ctx, fork.NewDB(reader, cfg, entity.NewAccountsStorage(), entity.NewAccountsState()), account)
 }
 
 func getBalanceFromReader(
@@ -136,23 +119,39 @@ func getBalanceFromReader(
 	return hexutil.Encode(at.Bytes()), nil
 }
 
-func getCodeFromBlockStorage(executor executor, account common.Address, blockNum uint64) (string, error) {
+// getCodeFromBlockStorage walks the block states from blockNum down, an account no
+// block changed is read at the fork block.
+func getCodeFromBlockStorage(
+	ctx context.Context,
+	executor executor,
+	cfg entity.ForkConfig,
+	reader readerAndCaller,
+	account common.Address,
+	blockNum uint64,
+) (string, error) {
 	b := executor.BlockStorage().GetBlockByNumber(blockNum)
 	if b == nil {
 		return "0x", fmt.Errorf("block %d not found in block storage", blockNum)
 	}
-	code := b.Accounts.GetCode(account)
-	codeStr := "0x"
-	if code != nil {
-		codeStr = hexutil.Encode(code)
+
+	code, ok := b.Code(account)
+	if !ok {
+		var err error
+		db := fork.NewDB(reader, cfg, entity.NewAccountsStorage(), entity.NewAccountsState())
+		if code, err = db.GetCode(ctx, account); err != nil {
+			return "0x", err
+		}
 	}
-	return codeStr, nil
+
+	return hexutil.Encode(code), nil
 }
 
+// getStateFromBlockStorage walks the block states from blockNum down, a slot no
+// block wrote is read at the fork block.
 func getStateFromBlockStorage(
 	ctx context.Context,
 	executor executor,
-	chainID uint64,
+	cfg entity.ForkConfig,
 	reader readerAndCaller,
 	account common.Address,
 	slot common.Hash,
@@ -163,17 +162,12 @@ func getStateFromBlockStorage(
 		return common.Hash{}, fmt.Errorf("block %d not found in block storage", blockNum)
 	}
 
-	db := fork.NewDB(reader, entity.ForkConfig{
-		ChainID:   chainID,
-		ForkBlock: new(big.Int).SetUint64(blockNum),
-	}, b.Accounts, b.State)
-
-	storage, err := db.GetState(ctx, account, slot)
-	if err != nil {
-		return [32]byte{}, err
+	if value, ok := b.Slot(account, slot); ok {
+		return value, nil
 	}
 
-	return storage, nil
+	db := fork.NewDB(reader, cfg, entity.NewAccountsStorage(), entity.NewAccountsState())
+	return db.GetState(ctx, account, slot)
 }
 
 func getStateFromReader(
@@ -273,6 +267,175 @@ func createEthCallMsg(msg jsonCallMsg) (ethereum.CallMsg, error) {
 	return call, nil
 }
 
+// forkDBAt returns a fork db holding the state right after block num, the fork
+// block itself maps to a fresh db reading straight from the upstream.
+func forkDBAt(
+	exec executor,
+	reader readerAndCaller,
+	cfg entity.ForkConfig,
+	num uint64,
+) (*fork.DB, error) {
+	if num == cfg.ForkBlock.Uint64() {
+		return fork.NewDB(reader, cfg, entity.NewAccountsStorage(), entity.NewAccountsState()), nil
+	}
+
+	storage, err := getBlockStorage(exec, num)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts, state := storage.Flatten()
+	return fork.NewDB(reader, cfg, accounts, state), nil
+}
+
+// simulationBase returns a copy of the state at block along with its header, the
+// copy can be written to without affecting the session or the stored blocks.
+func simulationBase(
+	ctx context.Context,
+	execCtx *ExecutionCtx,
+	reader readerAndCaller,
+	cfg entity.ForkConfig,
+	num uint64,
+) (*fork.DB, *types.Header, error) {
+	var (
+		header  *types.Header
+		storage *entity.BlockState
+		err     error
+	)
+	if num > cfg.ForkBlock.Uint64() {
+		if storage, err = getBlockStorage(execCtx.Executor, num); err != nil {
+			return nil, nil, err
+		}
+		header = storage.Block.Header()
+	} else if header, err = reader.HeaderByNumber(ctx, new(big.Int).SetUint64(num)); err != nil {
+		return nil, nil, err
+	}
+
+	_, latest := execCtx.Executor.Latest()
+	switch {
+	case num == latest:
+		accounts, state := execCtx.Db.Copy()
+		return fork.NewDB(reader, cfg, accounts, state), header, nil
+	case storage != nil:
+		accounts, state := storage.Flatten()
+		return fork.NewDB(reader, cfg, accounts, state), header, nil
+	default:
+		cfg.ForkBlock = header.Number
+		return fork.NewDB(reader, cfg, entity.NewAccountsStorage(), entity.NewAccountsState()), header, nil
+	}
+}
+
+// callBundle decodes the signed bundle transactions and executes them on top of
+// the session state, the session overrides apply to the bundle as well.
+func callBundle(ctx context.Context, execCtx *ExecutionCtx, args entity.BundleArgs) (*entity.BundleResult, error) {
+	if len(args.Txs) == 0 {
+		return nil, errors.New("bundle has no transactions")
+	}
+
+	txs := make([]*types.Transaction, 0, len(args.Txs))
+	for i, encoded := range args.Txs {
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(encoded); err != nil {
+			return nil, fmt.Errorf("failed to decode transaction %d: %w", i, err)
+		}
+		txs = append(txs, tx)
+	}
+
+	var blockOverrides *entity.BlockOverrides
+	if args.Coinbase != nil || args.Timestamp != nil {
+		blockOverrides = &entity.BlockOverrides{FeeRecipient: args.Coinbase, Time: args.Timestamp}
+	}
+
+	return execCtx.Executor.CallBundle(ctx, txs, execCtx.StateOverrides(), blockOverrides, args.Commit)
+}
+
+// sendTransactionMsg converts the args of eth_sendTransaction into the message
+// executed by CallAndPersist, which does not deploy contracts.
+func sendTransactionMsg(args entity.TransactionArgs) (ethereum.CallMsg, error) {
+	if args.To == nil {
+		return ethereum.CallMsg{}, errors.New("contract creation is not supported")
+	}
+
+	msg := ethereum.CallMsg{
+		From:     *args.From,
+		To:       args.To,
+		Gas:      30e6,
+		GasPrice: new(big.Int),
+		Value:    new(big.Int),
+		Data:     args.CallData(),
+	}
+	if args.Gas != nil {
+		msg.Gas = uint64(*args.Gas)
+	}
+	if args.GasPrice != nil {
+		msg.GasPrice = args.GasPrice.ToInt()
+	}
+	if args.Value != nil {
+		msg.Value = args.Value.ToInt()
+	}
+
+	return msg, nil
+}
+
+// mergeOverrides returns a new set of overrides holding base with the accounts of
+// extra replacing those of base.
+func mergeOverrides(base, extra entity.StateOverrides) entity.StateOverrides {
+	merged := make(entity.StateOverrides, len(base)+len(extra))
+	for addr, override := range base {
+		merged[addr] = override
+	}
+	for addr, override := range extra {
+		merged[addr] = override
+	}
+
+	return merged
+}
+
+// decodeRawParams unmarshals positional params into outs, params which were not
+// sent leave their outs untouched so they act as defaults for optional params.
+func decodeRawParams(raw jsonrpc.RawParams, required int, outs ...any) error {
+	var params []json.RawMessage
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return err
+		}
+	}
+
+	if len(params) < required || len(params) > len(outs) {
+		return fmt.Errorf("wrong param count: %d", len(params))
+	}
+
+	for i, param := range params {
+		if err := json.Unmarshal(param, outs[i]); err != nil {
+			return fmt.Errorf("invalid param %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// forwardRawParams relays a request to the upstream node with the params as received.
+func forwardRawParams(ctx context.Context, rpc entity.RawRpc, method string, raw jsonrpc.RawParams) (json.RawMessage, error) {
+	var params []json.RawMessage
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, err
+		}
+	}
+
+	args := make([]any, len(params))
+	for i, param := range params {
+		args[i] = param
+	}
+
+	var res json.RawMessage
+	if err := rpc.CallContext(ctx, &res, method, args...); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
 func getBlockStorage(
 	executor executor,
 	blockNum uint64,
@@ -299,3 +462,30 @@ func callOnReader(
 
 	return hexutil.Encode(ret), nil
 }
+
+// txSender returns the sender of a transaction, local transactions are unsigned
+// so their sender is taken from the transaction storage.
+func txSender(exec executor, tx *types.Transaction) common.Address {
+	if sender, ok := exec.TxnStorage().GetSender(tx.Hash()); ok {
+		return sender
+	}
+
+	return entity.RecoverSender(tx)
+}
+
+// serializeLocalTx serializes a transaction mined by the executor along with its receipt.
+func serializeLocalTx(exec executor, hash common.Hash) (*entity.SerializedTransaction, *entity.SerializedReceipt) {
+	tx := exec.TxnStorage().GetTransaction(hash)
+	receipt := exec.TxnStorage().GetReceipt(hash)
+	if tx == nil || receipt == nil {
+		return nil, nil
+	}
+
+	var timestamp uint64
+	if block := exec.BlockStorage().GetBlockByHash(receipt.BlockHash); block != nil {
+		timestamp = block.Block.Time()
+	}
+
+	sender := txSender(exec, tx)
+	return entity.SerializeTransaction(tx, receipt, sender), entity.SerializeReceipt(receipt, sender, timestamp)
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/tracer"
+)
+
+const safeABI = `[
+	{"name":"getOwners","type":"function","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"address[]"}]},
+	{"name":"execTransaction","type":"function","stateMutability":"payable","inputs":[
+		{"name":"to","type":"address"},
+		{"name":"value","type":"uint256"},
+		{"name":"data","type":"bytes"},
+		{"name":"operation","type":"uint8"},
+		{"name":"safeTxGas","type":"uint256"},
+		{"name":"baseGas","type":"uint256"},
+		{"name":"gasPrice","type":"uint256"},
+		{"name":"gasToken","type":"address"},
+		{"name":"refundReceiver","type":"address"},
+		{"name":"signatures","type":"bytes"}
+	],"outputs":[{"name":"success","type":"bool"}]}
+]`
+
+const (
+	safeOperationCall         uint8 = 0
+	safeOperationDelegateCall uint8 = 1
+)
+
+// threshold slot of the Safe singleton, see handleGnosisSafeExecTransaction
+var safeThresholdSlot = common.HexToHash("0x0000000000000000000000000000000000000000000000000000000000000004")
+
+var parsedSafeABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(safeABI))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// safeOwners returns the owners of the Safe on top of the session state.
+func safeOwners(ctx context.Context, exec executor, safe common.Address, overrides entity.StateOverrides) ([]common.Address, error) {
+	data, err := parsedSafeABI.Pack("getOwners")
+	if err != nil {
+		return nil, err
+	}
+
+	ret, _, err := exec.Call(ctx, ethereum.CallMsg{
+		From:  safe,
+		To:    &safe,
+		Gas:   30e6,
+		Value: new(big.Int),
+		Data:  data,
+	}, tracer.NewTracer(false), overrides)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read owners of safe %s: %w", safe.Hex(), err)
+	}
+
+	var owners []common.Address
+	if err = parsedSafeABI.UnpackIntoInterface(&owners, "getOwners", ret); err != nil {
+		return nil, fmt.Errorf("%s is not a safe: %w", safe.Hex(), err)
+	}
+
+	return owners, nil
+}
+
+// packSafeExecTransaction encodes an execTransaction call signed by owner with the
+// approved hash flow, the Safe accepts the v=1 signature of an owner which is the
+// sender of the transaction. No gas refund is paid.
+func packSafeExecTransaction(owner, to common.Address, value *big.Int, data []byte, operation uint8) ([]byte, error) {
+	if operation != safeOperationCall && operation != safeOperationDelegateCall {
+		return nil, errors.New("operation must be 0 (call) or 1 (delegatecall)")
+	}
+
+	signature := make([]byte, 65)
+	copy(signature[12:32], owner.Bytes())
+	signature[64] = 1
+
+	return parsedSafeABI.Pack("execTransaction",
+		to,
+		value,
+		data,
+		operation,
+		new(big.Int),
+		new(big.Int),
+		new(big.Int),
+		common.Address{},
+		common.Address{},
+		signature,
+	)
+}
+
+// withSafeThreshold sets the threshold of the Safe to one in overrides, the slots
+// of an existing override of the Safe are copied and not modified.
+func withSafeThreshold(overrides entity.StateOverrides, safe common.Address) {
+	override := overrides[safe]
+	slots := make(entity.Storage)
+	target := override.StateDiff
+	if override.State != nil {
+		target = override.State
+	}
+	for slot, value := range target {
+		slots[slot] = value
+	}
+	slots[safeThresholdSlot] = common.BigToHash(big.NewInt(1))
+
+	if override.State != nil {
+		override.State = slots
+	} else {
+		override.StateDiff = slots
+	}
+	overrides[safe] = override
+}
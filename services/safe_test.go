@@ -0,0 +1,59 @@
+package services
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/raul0ligma/smelter/entity"
+)
+
+func TestPackSafeExecTransaction(t *testing.T) {
+	owner := common.HexToAddress("0x6")
+	to := common.HexToAddress("0x7")
+	input, err := packSafeExecTransaction(owner, to, big.NewInt(1), []byte{0x1}, safeOperationDelegateCall)
+	if err != nil {
+		t.Fatalf("failed to pack: %v", err)
+	}
+
+	if !bytes.Equal(input[:4], common.FromHex("0x6a761202")) {
+		t.Fatalf("unexpected selector %x", input[:4])
+	}
+
+	args, err := parsedSafeABI.Methods["execTransaction"].Inputs.Unpack(input[4:])
+	if err != nil {
+		t.Fatalf("failed to unpack: %v", err)
+	}
+
+	if args[0].(common.Address) != to || args[3].(uint8) != safeOperationDelegateCall {
+		t.Fatalf("unexpected args %v", args)
+	}
+
+	signature := args[9].([]byte)
+	if len(signature) != 65 || common.BytesToAddress(signature[:32]) != owner || signature[64] != 1 {
+		t.Fatalf("unexpected signature %x", signature)
+	}
+
+	if _, err = packSafeExecTransaction(owner, to, new(big.Int), nil, 2); err == nil {
+		t.Fatalf("expected an error for an unknown operation")
+	}
+}
+
+func TestWithSafeThreshold(t *testing.T) {
+	safe := common.HexToAddress("0x5afe")
+	slot := common.HexToHash("0x1")
+	session := entity.StateOverrides{safe: {StateDiff: entity.Storage{slot: common.HexToHash("0x2")}}}
+
+	overrides := mergeOverrides(session, nil)
+	withSafeThreshold(overrides, safe)
+
+	diff := overrides[safe].StateDiff
+	if diff[slot] != common.HexToHash("0x2") || diff[safeThresholdSlot] != common.BigToHash(big.NewInt(1)) {
+		t.Fatalf("unexpected state diff %v", diff)
+	}
+
+	if _, ok := session[safe].StateDiff[safeThresholdSlot]; ok {
+		t.Fatalf("session overrides modified")
+	}
+}
@@ -3,9 +3,25 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/filecoin-project/go-jsonrpc"
 	"github.com/raul0ligma/smelter/entity"
+	executorPkg "github.com/raul0ligma/smelter/executor"
+	"github.com/raul0ligma/smelter/tracer"
+)
+
+const (
+	defaultHistoryLimit = 25
+	maxHistoryLimit     = 1000
+
+	balanceOfSelector   = "0x70a08231"
+	totalSupplySelector = "0x18160ddd"
 )
 
 type SmelterRpc struct {
@@ -16,23 +32,150 @@ func NewSmelterRpc(exec executionCtx) *SmelterRpc {
 	return &SmelterRpc{execStorage: exec}
 }
 
+// CreateSession creates the session of key forked at forkBlock, the requests to
+// /v1/rpc/key then execute on top of it. Without forkBlock the session is forked
+// at the default fork block, with follow it's forked anew whenever the default
+// fork block rolls to the head.
+func (s *SmelterRpc) CreateSession(ctx context.Context, params jsonrpc.RawParams) (*entity.SessionInfo, error) {
+	var (
+		key       string
+		forkBlock *blockRef
+		follow    bool
+	)
+	if err := decodeRawParams(params, 1, &key, &forkBlock, &follow); err != nil {
+		return nil, err
+	}
+
+	if key == "" {
+		return nil, errors.New("session key is required")
+	}
+
+	var block *big.Int
+	if forkBlock != nil {
+		if forkBlock.hash != nil {
+			return nil, errors.New("fork block must be a block number")
+		}
+
+		var err error
+		if block, err = parseBigInt(forkBlock.number); err != nil {
+			return nil, fmt.Errorf("invalid fork block %s", forkBlock.number)
+		}
+	}
+
+	execCtx, err := s.execStorage.CreateSession(ctx, key, block, follow)
+	if err != nil {
+		return nil, err
+	}
+
+	info := execCtx.info(key)
+	return &info, nil
+}
+
+// ResetFork forks the session anew at the given upstream block dropping its state,
+// without a block or with a block tag it forks at the upstream head.
+func (s *SmelterRpc) ResetFork(ctx context.Context, params jsonrpc.RawParams) (*entity.SessionInfo, error) {
+	var forkBlock *blockRef
+	if err := decodeRawParams(params, 0, &forkBlock); err != nil {
+		return nil, err
+	}
+
+	var block *big.Int
+	if forkBlock != nil {
+		switch {
+		case forkBlock.hash != nil:
+			return nil, errors.New("fork block must be a block number")
+		case forkBlock.number == "", forkBlock.number == latestBlock, forkBlock.number == pendingBlock,
+			forkBlock.number == safeBlock, forkBlock.number == finalizedBlock:
+		default:
+			var err error
+			if block, err = parseBigInt(forkBlock.number); err != nil {
+				return nil, fmt.Errorf("invalid fork block %s", forkBlock.number)
+			}
+		}
+	}
+
+	execCtx, err := s.execStorage.ResetFork(ctx, block)
+	if err != nil {
+		return nil, err
+	}
+
+	info := execCtx.info(sessionKey(ctx))
+	return &info, nil
+}
+
+// DumpState returns the session state in the anvil format along with the local
+// blocks and transactions.
+func (s *SmelterRpc) DumpState(ctx context.Context) (*entity.StateDump, error) {
+	execCtx, err := s.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return execCtx.Executor.Dump(ctx)
+}
+
+// LoadState merges a dump into the session state, the dump is either json or the
+// hex encoded gzipped json anvil_dumpState returns.
+func (s *SmelterRpc) LoadState(ctx context.Context, state json.RawMessage) (bool, error) {
+	dump, err := entity.DecodeStateDump(state)
+	if err != nil {
+		return false, err
+	}
+
+	execCtx, err := s.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if err = execCtx.Executor.Load(ctx, dump); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ImpersonateAccount makes address the sender of raw transactions and adds it to
+// the accounts eth_sendTransaction accepts unsigned transactions from.
 func (s *SmelterRpc) ImpersonateAccount(ctx context.Context, address common.Address) error {
 	execCtx, err := s.execStorage.GetOrCreate(ctx)
 	if err != nil {
 		return err
 	}
 
-	execCtx.Impersonator = address
+	execCtx.Impersonate(address)
 	return nil
 }
 
-func (s *SmelterRpc) StopImpersonatingAccount(ctx context.Context) error {
+// StopImpersonatingAccount stops impersonating the given account, without an
+// address every account is dropped.
+func (s *SmelterRpc) StopImpersonatingAccount(ctx context.Context, params jsonrpc.RawParams) error {
+	var address *common.Address
+	if err := decodeRawParams(params, 0, &address); err != nil {
+		return err
+	}
+
 	execCtx, err := s.execStorage.GetOrCreate(ctx)
 	if err != nil {
 		return err
 	}
 
-	execCtx.Impersonator = common.HexToAddress("")
+	if address == nil {
+		execCtx.StopImpersonatingAll()
+		return nil
+	}
+
+	execCtx.StopImpersonating(*address)
+	return nil
+}
+
+// AutoImpersonateAccount toggles accepting unsigned transactions from any account.
+func (s *SmelterRpc) AutoImpersonateAccount(ctx context.Context, enabled bool) error {
+	execCtx, err := s.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return err
+	}
+
+	execCtx.SetAutoImpersonate(enabled)
 	return nil
 }
 
@@ -56,6 +199,244 @@ func (s *SmelterRpc) SetStateOverrides(ctx context.Context, overrides entity.Sta
 		return err
 	}
 
-	execCtx.Overrides = overrides
+	execCtx.SetStateOverrides(overrides)
 	return nil
 }
+
+// GetAccountHistory returns the local transactions which touched an account newest
+// first, the returned cursor fetches the next page.
+func (s *SmelterRpc) GetAccountHistory(ctx context.Context, params jsonrpc.RawParams) (*entity.AccountHistory, error) {
+	var (
+		address common.Address
+		cursor  *entity.TxPosition
+		limit   = defaultHistoryLimit
+	)
+	if err := decodeRawParams(params, 1, &address, &cursor, &limit); err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 || limit > maxHistoryLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %d", maxHistoryLimit)
+	}
+
+	execCtx, err := s.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	txStorage := execCtx.Executor.TxnStorage()
+	txs := txStorage.AccountTransactions(address, cursor, limit, true)
+	history := &entity.AccountHistory{
+		Transactions: make([]*entity.SerializedTransaction, 0, len(txs)),
+	}
+
+	for _, tx := range txs {
+		serialized, _ := serializeLocalTx(execCtx.Executor, tx.Hash)
+		history.Transactions = append(history.Transactions, serialized)
+	}
+
+	if len(txs) > 0 {
+		last := txs[len(txs)-1].Position
+		if len(txStorage.AccountTransactions(address, &last, 1, true)) > 0 {
+			history.Cursor = &last
+		}
+	}
+
+	return history, nil
+}
+
+// SimulateBundle executes an ordered bundle of signed transactions on top of the
+// session, the bundle is only mined as a block when commit is set and every
+// transaction succeeded.
+func (s *SmelterRpc) SimulateBundle(ctx context.Context, args entity.BundleArgs) (*entity.BundleResult, error) {
+	execCtx, err := s.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return callBundle(ctx, execCtx, args)
+}
+
+// SimulateWithDiff executes a call on top of the session without persisting it and
+// reports the assets it moved, the approvals it changed and the raw state diff.
+// The overrides are applied on top of the session overrides.
+func (s *SmelterRpc) SimulateWithDiff(ctx context.Context, params jsonrpc.RawParams) (*entity.SimulationDiff, error) {
+	var (
+		msg       jsonCallMsg
+		overrides entity.StateOverrides
+	)
+	if err := decodeRawParams(params, 1, &msg, &overrides); err != nil {
+		return nil, err
+	}
+
+	execCtx, err := s.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	call, err := createEthCallMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if call.From == (common.Address{}) {
+		call.From = execCtx.Sender()
+	}
+
+	return execCtx.Executor.CallWithDiff(ctx, call, mergeOverrides(execCtx.StateOverrides(), overrides))
+}
+
+// SimulateSafeTransaction executes a Safe transaction without owner signatures, the
+// threshold is overridden to one and the first owner signs with the approved hash
+// flow by sending execTransaction. The Safe guard runs as it would onchain.
+func (s *SmelterRpc) SimulateSafeTransaction(ctx context.Context, params jsonrpc.RawParams) (*entity.SimulationDiff, error) {
+	var (
+		safe      common.Address
+		to        common.Address
+		value     = new(hexutil.Big)
+		data      hexutil.Bytes
+		operation uint8
+	)
+	if err := decodeRawParams(params, 2, &safe, &to, &value, &data, &operation); err != nil {
+		return nil, err
+	}
+
+	if value == nil {
+		value = new(hexutil.Big)
+	}
+
+	execCtx, err := s.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := mergeOverrides(execCtx.StateOverrides(), nil)
+	owners, err := safeOwners(ctx, execCtx.Executor, safe, overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(owners) == 0 {
+		return nil, fmt.Errorf("safe %s has no owners", safe.Hex())
+	}
+
+	input, err := packSafeExecTransaction(owners[0], to, value.ToInt(), data, operation)
+	if err != nil {
+		return nil, err
+	}
+
+	withSafeThreshold(overrides, safe)
+	return execCtx.Executor.CallWithDiff(ctx, ethereum.CallMsg{
+		From:  owners[0],
+		To:    &safe,
+		Gas:   30e6,
+		Value: new(big.Int),
+		Data:  input,
+	}, overrides)
+}
+
+// SetErc20Balance sets the token balance of holder by writing the slot which
+// balanceOf returns, with adjustSupply the totalSupply slot is moved by the same
+// delta. When no slot is returned as is the slot most likely backing balanceOf
+// is written and kept only if balanceOf returns the amount. The returned slot
+// holds the previous balance as value.
+func (s *SmelterRpc) SetErc20Balance(ctx context.Context, params jsonrpc.RawParams) (*entity.StorageSlot, error) {
+	var (
+		token        common.Address
+		holder       common.Address
+		amount       hexutil.Big
+		adjustSupply bool
+	)
+	if err := decodeRawParams(params, 3, &token, &holder, &amount, &adjustSupply); err != nil {
+		return nil, err
+	}
+
+	if amount.ToInt().Sign() < 0 || amount.ToInt().BitLen() > 256 {
+		return nil, errors.New("amount must be an uint256")
+	}
+
+	execCtx, err := s.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	balanceOf := getterCall(token, append(common.FromHex(balanceOfSelector), common.LeftPadBytes(holder.Bytes(), 32)...))
+	candidates, err := execCtx.Executor.FindStorageSlots(ctx, balanceOf, execCtx.StateOverrides())
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the balance slot of %s: %w", token.Hex(), err)
+	}
+
+	// the candidates are ranked, a slot changing the return is the fallback when
+	// none is returned as is
+	if len(candidates) == 0 || candidates[0].Probe == nil {
+		return nil, fmt.Errorf("failed to find the balance slot of %s: %w", token.Hex(), executorPkg.ErrSlotNotFound)
+	}
+	slot := &candidates[0].StorageSlot
+
+	if err = execCtx.Executor.SetStorageAt(ctx, slot.Address, slot.Slot, common.BigToHash(amount.ToInt())); err != nil {
+		return nil, err
+	}
+
+	ret, _, err := execCtx.Executor.Call(ctx, balanceOf, tracer.NewTracer(false), execCtx.StateOverrides())
+	if err == nil && new(big.Int).SetBytes(ret).Cmp(amount.ToInt()) != 0 {
+		err = fmt.Errorf(
+			"balanceOf of %s returns %s after writing the amount into slot %s of %s, the token doesn't return its balance slot as is",
+			token.Hex(), new(big.Int).SetBytes(ret), slot.Slot.Hex(), slot.Address.Hex(),
+		)
+	}
+	if err != nil {
+		if restoreErr := execCtx.Executor.SetStorageAt(ctx, slot.Address, slot.Slot, slot.Value); restoreErr != nil {
+			return nil, errors.Join(err, restoreErr)
+		}
+		return nil, err
+	}
+
+	if adjustSupply {
+		supply, err := execCtx.Executor.StorageSlot(ctx, getterCall(token, common.FromHex(totalSupplySelector)), execCtx.StateOverrides())
+		if err != nil {
+			return nil, fmt.Errorf("failed to find the total supply slot of %s: %w", token.Hex(), err)
+		}
+
+		total := new(big.Int).Sub(supply.Value.Big(), slot.Value.Big())
+		total.Add(total, amount.ToInt())
+		if total.Sign() < 0 {
+			total.SetUint64(0)
+		}
+
+		if err = execCtx.Executor.SetStorageAt(ctx, supply.Address, supply.Slot, common.BigToHash(total)); err != nil {
+			return nil, err
+		}
+	}
+
+	return slot, nil
+}
+
+// FindStorageSlot returns the slots read by the getter call ranked by how likely
+// they back its return value, each with a probe value which changes the return.
+func (s *SmelterRpc) FindStorageSlot(ctx context.Context, address common.Address, calldata hexutil.Bytes) ([]*entity.SlotCandidate, error) {
+	execCtx, err := s.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return execCtx.Executor.FindStorageSlots(ctx, getterCall(address, calldata), execCtx.StateOverrides())
+}
+
+// SetStorageAt writes a storage slot of an account in the session state.
+func (s *SmelterRpc) SetStorageAt(ctx context.Context, address common.Address, slot, value common.Hash) error {
+	execCtx, err := s.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return err
+	}
+
+	return execCtx.Executor.SetStorageAt(ctx, address, slot, value)
+}
+
+func getterCall(token common.Address, data []byte) ethereum.CallMsg {
+	return ethereum.CallMsg{
+		To:    &token,
+		Gas:   30e6,
+		Value: new(big.Int),
+		Data:  data,
+	}
+}
@@ -0,0 +1,474 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/fork"
+	"github.com/raul0ligma/smelter/tracer"
+	"go.uber.org/zap"
+)
+
+const (
+	flatCallTracer = "flatCallTracer"
+	prestateTracer = "prestateTracer"
+)
+
+// TraceRpc serves the parity style trace_* namespace, local blocks are replayed
+// through the executor while pre-fork history is forwarded to the upstream.
+type TraceRpc struct {
+	execStorage     executionCtx
+	readerAndCaller readerAndCaller
+	cfg             entity.ForkConfig
+	logger          *zap.SugaredLogger
+}
+
+func NewTraceRpc(
+	storage executionCtx,
+	cfg entity.ForkConfig,
+	readerAndCaller readerAndCaller,
+) *TraceRpc {
+	logger, _ := zap.NewProduction()
+	defer logger.Sync() // flushes buffer, if any
+
+	return &TraceRpc{
+		execStorage:     storage,
+		cfg:             cfg,
+		readerAndCaller: readerAndCaller,
+		logger:          logger.Sugar(),
+	}
+}
+
+func (t *TraceRpc) Call(ctx context.Context, params jsonrpc.RawParams) (json.RawMessage, error) {
+	var (
+		msg         jsonCallMsg
+		traceTypes  []entity.TraceType
+		blockNumber blockRef
+	)
+	if err := decodeRawParams(params, 2, &msg, &traceTypes, &blockNumber); err != nil {
+		return nil, err
+	}
+	t.logger.Debug("Called Call", zap.Any("msg", msg), zap.Any("traceTypes", traceTypes), zap.Stringer("blockNumber", blockNumber))
+
+	execCtx, err := t.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, latest := execCtx.Executor.Latest()
+	block, err := resolveBlock(ctx, execCtx.Executor, t.readerAndCaller, execCtx.Fork, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	call, err := createEthCallMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var db *fork.DB
+	switch {
+	case block.Uint64() == latest:
+	case block.Uint64() > execCtx.Fork.ForkBlock.Uint64():
+		if db, err = forkDBAt(execCtx.Executor, t.readerAndCaller, execCtx.Fork, block.Uint64()); err != nil {
+			return nil, err
+		}
+	default:
+		return forwardRawParams(ctx, t.readerAndCaller, "trace_call", params)
+	}
+
+	results, err := t.traceResults(ctx, execCtx, call, db, nil, &tracers.Context{BlockNumber: block}, traceTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(results)
+}
+
+func (t *TraceRpc) ReplayTransaction(
+	ctx context.Context,
+	txHash common.Hash,
+	traceTypes []entity.TraceType,
+) (json.RawMessage, error) {
+	t.logger.Debug("Called ReplayTransaction", zap.String("txHash", txHash.Hex()), zap.Any("traceTypes", traceTypes))
+
+	execCtx, err := t.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	block, index, err := storedTxBlock(execCtx, txHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if block == nil {
+		var res json.RawMessage
+		if err := t.readerAndCaller.CallContext(ctx, &res, "trace_replayTransaction", txHash, traceTypes); err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+
+	r, err := prepareReplay(execCtx, t.readerAndCaller, execCtx.Fork, block, index)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := t.traceResults(ctx, execCtx, r.msg, r.db, r.header, r.txCtx, traceTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	results.TransactionHash = &txHash
+	return json.Marshal(results)
+}
+
+func (t *TraceRpc) Transaction(ctx context.Context, txHash common.Hash) ([]*entity.FlatTrace, error) {
+	t.logger.Debug("Called Transaction", zap.String("txHash", txHash.Hex()))
+
+	execCtx, err := t.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	block, index, err := storedTxBlock(execCtx, txHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if block == nil {
+		return t.forwardFlatTraces(ctx, "trace_transaction", txHash)
+	}
+
+	return t.flatTraces(ctx, execCtx, block, index)
+}
+
+func (t *TraceRpc) Block(ctx context.Context, number blockRef) ([]*entity.FlatTrace, error) {
+	t.logger.Debug("Called Block", zap.Stringer("number", number))
+
+	execCtx, err := t.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := t.parseBlock(ctx, execCtx, number)
+	if err != nil {
+		return nil, err
+	}
+
+	if block <= execCtx.Fork.ForkBlock.Uint64() {
+		return t.forwardFlatTraces(ctx, "trace_block", hexutil.EncodeUint64(block))
+	}
+
+	return t.blockFlatTraces(ctx, execCtx, block)
+}
+
+// Filter returns the traces matching the filter, the part of the range before
+// the fork is served by the upstream and the rest by replaying local blocks.
+func (t *TraceRpc) Filter(ctx context.Context, filter entity.TraceFilter) ([]*entity.FlatTrace, error) {
+	t.logger.Debug("Called Filter", zap.Any("filter", filter))
+
+	execCtx, err := t.execStorage.GetOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// without a lower bound the range starts at the first local block, the whole
+	// upstream history is never traced
+	_, latest := execCtx.Executor.Latest()
+	forkBlock := execCtx.Fork.ForkBlock.Uint64()
+	fromBlock, toBlock := forkBlock+1, latest
+	if filter.FromBlock != nil {
+		if fromBlock, err = t.parseBlock(ctx, execCtx, newBlockRef(*filter.FromBlock)); err != nil {
+			return nil, err
+		}
+	}
+
+	if filter.ToBlock != nil {
+		if toBlock, err = t.parseBlock(ctx, execCtx, newBlockRef(*filter.ToBlock)); err != nil {
+			return nil, err
+		}
+	}
+
+	traces := make([]*entity.FlatTrace, 0)
+	if fromBlock > toBlock {
+		if filter.FromBlock == nil {
+			return traces, nil
+		}
+		return nil, fmt.Errorf("invalid block range %d > %d", fromBlock, toBlock)
+	}
+
+	if fromBlock <= forkBlock {
+		upstream := entity.TraceFilter{
+			FromBlock:   filter.FromBlock,
+			ToBlock:     filter.ToBlock,
+			FromAddress: filter.FromAddress,
+			ToAddress:   filter.ToAddress,
+		}
+		upstreamTo := hexutil.EncodeUint64(min(toBlock, forkBlock))
+		upstream.ToBlock = &upstreamTo
+		if filter.Count != nil {
+			count := *filter.Count
+			if filter.After != nil {
+				count += *filter.After
+			}
+			upstream.Count = &count
+		}
+
+		remote, err := t.forwardFlatTraces(ctx, "trace_filter", upstream)
+		if err != nil {
+			return nil, err
+		}
+		traces = append(traces, remote...)
+	}
+
+	for num := max(fromBlock, forkBlock+1); num <= toBlock; num++ {
+		local, err := t.blockFlatTraces(ctx, execCtx, num)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, trace := range local {
+			if matchesTraceFilter(trace, filter) {
+				traces = append(traces, trace)
+			}
+		}
+	}
+
+	if filter.After != nil {
+		traces = traces[min(*filter.After, uint64(len(traces))):]
+	}
+
+	if filter.Count != nil {
+		traces = traces[:min(*filter.Count, uint64(len(traces)))]
+	}
+
+	return traces, nil
+}
+
+func matchesTraceFilter(trace *entity.FlatTrace, filter entity.TraceFilter) bool {
+	participants := trace.Participants()
+	return matchesAddress(participants.From, filter.FromAddress) && matchesAddress(participants.To, filter.ToAddress)
+}
+
+func matchesAddress(addr *common.Address, addrs []common.Address) bool {
+	if len(addrs) == 0 {
+		return true
+	}
+
+	if addr == nil {
+		return false
+	}
+
+	for _, a := range addrs {
+		if a == *addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *TraceRpc) parseBlock(ctx context.Context, execCtx *ExecutionCtx, ref blockRef) (uint64, error) {
+	block, err := resolveBlock(ctx, execCtx.Executor, t.readerAndCaller, execCtx.Fork, ref)
+	if err != nil {
+		return 0, err
+	}
+
+	return block.Uint64(), nil
+}
+
+func (t *TraceRpc) forwardFlatTraces(ctx context.Context, method string, args ...any) ([]*entity.FlatTrace, error) {
+	var traces []*entity.FlatTrace
+	if err := t.readerAndCaller.CallContext(ctx, &traces, method, args...); err != nil {
+		return nil, err
+	}
+
+	return traces, nil
+}
+
+func (t *TraceRpc) blockFlatTraces(ctx context.Context, execCtx *ExecutionCtx, number uint64) ([]*entity.FlatTrace, error) {
+	storage, err := getBlockStorage(execCtx.Executor, number)
+	if err != nil {
+		return nil, err
+	}
+
+	traces := make([]*entity.FlatTrace, 0)
+	for i := range storage.Block.Transactions() {
+		txTraces, err := t.flatTraces(ctx, execCtx, storage.Block, i)
+		if err != nil {
+			return nil, err
+		}
+		traces = append(traces, txTraces...)
+	}
+
+	return traces, nil
+}
+
+func (t *TraceRpc) flatTraces(
+	ctx context.Context,
+	execCtx *ExecutionCtx,
+	block *types.Block,
+	index int,
+) ([]*entity.FlatTrace, error) {
+	r, err := prepareReplay(execCtx, t.readerAndCaller, execCtx.Fork, block, index)
+	if err != nil {
+		return nil, err
+	}
+
+	res, _, err := t.run(ctx, execCtx, r.msg, r.db, r.header, flatCallTracerConfig(), r.txCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	var traces []*entity.FlatTrace
+	if err := json.Unmarshal(res, &traces); err != nil {
+		return nil, err
+	}
+
+	return traces, nil
+}
+
+// traceResults executes msg once for every requested trace type, the output is
+// taken from the first execution.
+func (t *TraceRpc) traceResults(
+	ctx context.Context,
+	execCtx *ExecutionCtx,
+	msg ethereum.CallMsg,
+	db *fork.DB,
+	header *types.Header,
+	txCtx *tracers.Context,
+	traceTypes []entity.TraceType,
+) (*entity.TraceResults, error) {
+	var (
+		providers = make([]entity.TraceProvider, 0, len(traceTypes))
+		flat      *tracer.GethTracer
+		diff      *tracer.GethTracer
+		vmTracer  *tracer.VmTracer
+		err       error
+	)
+	// the transaction is executed once with a tracer per requested trace type
+	for _, traceType := range traceTypes {
+		switch traceType {
+		case entity.TraceTypeTrace:
+			if flat != nil {
+				continue
+			}
+			if flat, err = tracer.NewGethTracer(flatCallTracerConfig(), txCtx, execCtx.Executor.ChainConfig()); err != nil {
+				return nil, err
+			}
+			providers = append(providers, flat)
+
+		case entity.TraceTypeStateDiff:
+			if diff != nil {
+				continue
+			}
+			name := prestateTracer
+			diff, err = tracer.NewGethTracer(&entity.TraceConfig{
+				Tracer:       &name,
+				TracerConfig: json.RawMessage(`{"diffMode":true}`),
+			}, txCtx, execCtx.Executor.ChainConfig())
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, diff)
+
+		case entity.TraceTypeVmTrace:
+			if vmTracer != nil {
+				continue
+			}
+			vmTracer = tracer.NewVmTracer()
+			providers = append(providers, vmTracer)
+
+		default:
+			return nil, fmt.Errorf("unknown trace type %s", traceType)
+		}
+	}
+
+	// vm errors such as reverts are part of the trace result
+	output, _, _ := execCtx.Executor.Trace(ctx, msg, tracer.NewMuxTracer(providers...), db, header, nil)
+
+	results := &entity.TraceResults{Output: output}
+	if results.Output == nil {
+		results.Output = []byte{}
+	}
+
+	if flat != nil {
+		if results.Trace, err = flat.GetResult(); err != nil {
+			return nil, err
+		}
+	}
+
+	if diff != nil {
+		res, err := diff.GetResult()
+		if err != nil {
+			return nil, err
+		}
+		if results.StateDiff, err = tracer.ParityStateDiff(res); err != nil {
+			return nil, err
+		}
+	}
+
+	if vmTracer != nil {
+		results.VmTrace = vmTracer.Result()
+	}
+
+	return results, nil
+}
+
+func (t *TraceRpc) run(
+	ctx context.Context,
+	execCtx *ExecutionCtx,
+	msg ethereum.CallMsg,
+	db *fork.DB,
+	header *types.Header,
+	cfg *entity.TraceConfig,
+	txCtx *tracers.Context,
+) (json.RawMessage, []byte, error) {
+	gethTracer, err := tracer.NewGethTracer(cfg, txCtx, execCtx.Executor.ChainConfig())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// vm errors such as reverts are part of the trace result
+	ret, _, _ := execCtx.Executor.Trace(ctx, msg, gethTracer, db, header, nil)
+	res, err := gethTracer.GetResult()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return res, ret, nil
+}
+
+func flatCallTracerConfig() *entity.TraceConfig {
+	name := flatCallTracer
+	return &entity.TraceConfig{
+		Tracer:       &name,
+		TracerConfig: json.RawMessage(`{"convertParityErrors":true}`),
+	}
+}
+
+// storedTxBlock looks up the local block holding a transaction, a nil block is
+// returned when the transaction isn't known locally.
+func storedTxBlock(execCtx *ExecutionCtx, txHash common.Hash) (*types.Block, int, error) {
+	receipt := execCtx.Executor.TxnStorage().GetReceipt(txHash)
+	if receipt == nil {
+		return nil, 0, nil
+	}
+
+	storage, err := getBlockStorage(execCtx.Executor, receipt.BlockNumber.Uint64())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return storage.Block, int(receipt.TransactionIndex), nil
+}
@@ -0,0 +1,111 @@
+package statedb
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/raul0ligma/smelter/entity"
+)
+
+// Checkpoint is a copy of the dirty state, StateDiff reports the changes made
+// after it was taken.
+type Checkpoint struct {
+	snapshot
+}
+
+func (s *StateDB) Checkpoint() *Checkpoint {
+	return &Checkpoint{snapshot{
+		storage: s.dirty.GetAccountStorage().Clone(),
+		state:   s.dirty.GetAccountState().Clone(),
+	}}
+}
+
+// StateDiff compares the dirty state against the state at the checkpoint, a nil
+// checkpoint compares against the db. Accounts which were only read are left out.
+func (s *StateDB) StateDiff(since *Checkpoint) (entity.StateDiff, error) {
+	diff := make(entity.StateDiff)
+	storage := s.dirty.GetAccountStorage().Clone()
+	for addr, post := range s.dirty.GetAccountState().Clone() {
+		pre, preStorage, err := s.preState(since, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		account := &entity.AccountDiff{Storage: make(map[common.Hash]entity.Diff)}
+		if post.Balance.Cmp(pre.Balance) != 0 {
+			account.Balance = entity.Diff{From: (*hexutil.Big)(pre.Balance), To: (*hexutil.Big)(post.Balance)}
+		}
+
+		if post.Nonce != pre.Nonce {
+			account.Nonce = entity.Diff{From: hexutil.Uint64(pre.Nonce), To: hexutil.Uint64(post.Nonce)}
+		}
+
+		postStorage := storage[addr]
+		if postStorage == nil {
+			postStorage = &entity.AccountStorage{}
+		}
+
+		if !bytes.Equal(postStorage.Code, preStorage.Code) {
+			account.Code = entity.Diff{From: hexutil.Bytes(preStorage.Code), To: hexutil.Bytes(postStorage.Code)}
+		}
+
+		for slot, value := range postStorage.Slots {
+			from, err := s.preSlot(preStorage, addr, slot)
+			if err != nil {
+				return nil, err
+			}
+
+			if from != value {
+				account.Storage[slot] = entity.Diff{From: from, To: value}
+			}
+		}
+
+		if account.Balance.From != nil || account.Nonce.From != nil || account.Code.From != nil || len(account.Storage) > 0 {
+			diff[addr] = account
+		}
+	}
+
+	return diff, nil
+}
+
+// preState returns the account as it was at the checkpoint, accounts loaded after
+// it are read from the db which is never written by the execution.
+func (s *StateDB) preState(since *Checkpoint, addr common.Address) (*entity.AccountState, *entity.AccountStorage, error) {
+	if since != nil {
+		if state, ok := since.state[addr]; ok {
+			storage := since.storage[addr]
+			if storage == nil {
+				storage = &entity.AccountStorage{Slots: map[common.Hash]common.Hash{}}
+			}
+			return state, storage, nil
+		}
+	}
+
+	state, storage, err := s.db.State(s.ctx, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if state == nil {
+		state = &entity.AccountState{Address: addr, Balance: new(big.Int)}
+	}
+	if storage == nil {
+		storage = &entity.AccountStorage{Slots: map[common.Hash]common.Hash{}}
+	}
+
+	return state, storage, nil
+}
+
+func (s *StateDB) preSlot(pre *entity.AccountStorage, addr common.Address, slot common.Hash) (common.Hash, error) {
+	if value, ok := pre.Slots[slot]; ok {
+		return value, nil
+	}
+
+	if _, ok := s.cleared[addr]; ok {
+		return common.Hash{}, nil
+	}
+
+	return s.db.GetState(s.ctx, addr, slot)
+}
@@ -39,6 +39,10 @@ type StateDB struct {
 	errorStack []error
 	snapshots  map[uint64]snapshot
 	counter    uint64
+	// cleared holds the accounts whose storage was replaced by an override, their
+	// missing slots are empty instead of being read from the db
+	cleared map[common.Address]struct{}
+	onLog   tracing.LogHook
 }
 
 func NewDB(ctx context.Context, db forkDB) *StateDB {
@@ -48,6 +52,7 @@ func NewDB(ctx context.Context, db forkDB) *StateDB {
 		dirty:      entity.NewDirtyState(),
 		errorStack: make([]error, 0),
 		snapshots:  map[uint64]snapshot{},
+		cleared:    map[common.Address]struct{}{},
 	}
 }
 
@@ -190,11 +195,15 @@ func (s *StateDB) GetState(addr common.Address, hash common.Hash) common.Hash {
 		return common.Hash{}
 	}
 
-	storage := s.dirty.GetAccountStorage().ReadStorage(addr, hash)
-	if storage != emptyHash {
+	// a slot set to zero shadows the fork as well, so presence is what counts
+	if storage, ok := s.dirty.GetAccountStorage().LookupStorage(addr, hash); ok {
 		return storage
 	}
 
+	if _, ok := s.cleared[addr]; ok {
+		return emptyHash
+	}
+
 	slot, err := s.db.GetState(s.ctx, addr, hash)
 	if err != nil {
 		s.errorStack = append(s.errorStack, fmt.Errorf("GetStateSlot: %w", err))
@@ -301,6 +310,15 @@ func (s *StateDB) Snapshot() int {
 
 func (s *StateDB) AddLog(log *types.Log) {
 	s.dirty.AddLog(log)
+	if s.onLog != nil {
+		s.onLog(log)
+	}
+}
+
+// SetLogHook registers a hook called with every log emitted by the execution,
+// the vm only reports logs to the state so tracers collecting them are wired here.
+func (s *StateDB) SetLogHook(hook tracing.LogHook) {
+	s.onLog = hook
 }
 
 func (s *StateDB) AddPreimage(hash common.Hash, data []byte) {
@@ -334,12 +352,27 @@ func (s *StateDB) ApplyOverrides(overrides entity.StateOverrides) error {
 			s.dirty.GetAccountState().SetBalance(addr, override.Balance)
 		}
 
+		if override.Nonce != nil {
+			s.dirty.GetAccountState().SetNonce(addr, uint64(*override.Nonce))
+		}
+
 		if len(override.Code) != 0 {
 			s.dirty.GetAccountStorage().SetCode(addr, override.Code)
 		}
 
-		for k, v := range override.Storage {
-			s.dirty.GetAccountStorage().SetStorage(addr, k, v)
+		if override.State != nil && override.StateDiff != nil {
+			return fmt.Errorf("account %s has both state and stateDiff overrides", addr.Hex())
+		}
+
+		if override.State != nil {
+			s.dirty.GetAccountStorage().ClearStorage(addr)
+			s.cleared[addr] = struct{}{}
+		}
+
+		for _, slots := range []entity.Storage{override.Storage, override.State, override.StateDiff} {
+			for k, v := range slots {
+				s.dirty.GetAccountStorage().SetStorage(addr, k, v)
+			}
 		}
 	}
 
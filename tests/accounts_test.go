@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/pkg/hdwallet"
+	"github.com/raul0ligma/smelter/services"
+	"github.com/raul0ligma/smelter/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDevAccounts(t *testing.T) {
+	ctx := context.Background()
+	reader := mockProvider{}
+	forkCfg := testForkConfig()
+	keys, err := hdwallet.DeriveKeys("test test test test test test test test test test test junk", "", 3)
+	require.NoError(t, err, "failed to derive keys")
+	balance := new(big.Int).Mul(big.NewInt(100), big.NewInt(params.Ether))
+	devAccounts := entity.NewDevAccounts(keys, balance)
+
+	storage := services.NewExecutionStorage(forkCfg, &reader, time.Hour, services.WithDevAccounts(devAccounts))
+	eth := services.NewRpcService(storage, forkCfg, &reader)
+	personal := services.NewPersonalRpc(storage)
+
+	addrs, err := eth.Accounts(ctx)
+	require.NoError(t, err, "failed to list accounts")
+	require.Len(t, addrs, 3)
+	dev := addrs[0]
+	require.Equal(t, common.HexToAddress("0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"), dev, "invalid first account")
+
+	execCtx, err := storage.GetOrCreate(ctx)
+	require.NoError(t, err)
+	funded, err := execCtx.Db.GetBalance(ctx, dev)
+	require.NoError(t, err)
+	require.Equal(t, balance, funded, "dev account not funded")
+
+	// dev accounts send without being impersonated
+	target := types.Address0x69
+	deposit := hexutil.Bytes(hexutil.MustDecode("0xd0e30db0"))
+	hash, err := eth.SendTransaction(ctx, entity.TransactionArgs{From: &dev, To: &target, Value: (*hexutil.Big)(big.NewInt(1000)), Data: &deposit})
+	require.NoError(t, err, "dev account transaction failed")
+	require.NotNil(t, execCtx.Executor.TxnStorage().GetReceipt(common.HexToHash(hash)), "transaction not mined")
+
+	stranger := common.HexToAddress("0x0000000000000000000000000000000000000006")
+	_, err = eth.SendTransaction(ctx, entity.TransactionArgs{From: &stranger, To: &target})
+	require.Error(t, err, "unknown account accepted")
+
+	message := []byte("smelter")
+	sig, err := eth.Sign(ctx, dev, message)
+	require.NoError(t, err, "failed to sign")
+	require.Equal(t, dev, recoverSigner(t, accounts.TextHash(message), sig), "invalid eth_sign signer")
+
+	signParams, err := json.Marshal([]any{hexutil.Bytes(message), dev})
+	require.NoError(t, err)
+	personalSig, err := personal.Sign(ctx, jsonrpc.RawParams(signParams))
+	require.NoError(t, err, "failed to personal sign")
+	require.Equal(t, sig, personalSig, "personal_sign differs from eth_sign")
+
+	_, err = eth.Sign(ctx, stranger, message)
+	require.ErrorIs(t, err, entity.ErrUnknownAccount)
+
+	typedData := `{
+		"types": {
+			"EIP712Domain": [{"name": "name", "type": "string"}, {"name": "chainId", "type": "uint256"}],
+			"Mail": [{"name": "to", "type": "address"}, {"name": "contents", "type": "string"}]
+		},
+		"primaryType": "Mail",
+		"domain": {"name": "smelter", "chainId": 69},
+		"message": {"to": "0x0000000000000000000000000000000000000006", "contents": "gm"}
+	}`
+	var parsed apitypes.TypedData
+	require.NoError(t, json.Unmarshal([]byte(typedData), &parsed))
+	typedHash, _, err := apitypes.TypedDataAndHash(parsed)
+	require.NoError(t, err)
+
+	encoded, err := json.Marshal(typedData)
+	require.NoError(t, err)
+	for _, raw := range []json.RawMessage{json.RawMessage(typedData), encoded} {
+		typedSig, err := eth.SignTypedData_v4(ctx, dev, raw)
+		require.NoError(t, err, "failed to sign typed data")
+		require.Equal(t, dev, recoverSigner(t, typedHash, typedSig), "invalid typed data signer")
+	}
+}
+
+func recoverSigner(t *testing.T, hash []byte, sig []byte) common.Address {
+	require.Len(t, sig, crypto.SignatureLength)
+	normalized := append([]byte{}, sig...)
+	normalized[crypto.RecoveryIDOffset] -= 27
+	pub, err := crypto.SigToPub(hash, normalized)
+	require.NoError(t, err, "failed to recover signer")
+	return crypto.PubkeyToAddress(*pub)
+}
@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/raul0ligma/smelter/fork"
+	"github.com/raul0ligma/smelter/pkg/server"
+	"github.com/raul0ligma/smelter/services"
+	"github.com/raul0ligma/smelter/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedBaseLayer(t *testing.T) {
+	ctx := context.Background()
+	reader := &countingProvider{}
+	forkCfg := testForkConfig()
+	storage := services.NewExecutionStorage(forkCfg, reader, time.Hour)
+	smelter := services.NewSmelterRpc(storage)
+
+	keys := make([]context.Context, 20)
+	sessions := make([]*services.ExecutionCtx, len(keys))
+	for i := range sessions {
+		keys[i] = context.WithValue(ctx, server.Key{}, fmt.Sprintf("session-%d", i))
+		sessions[i] = mustSession(t, keys[i], storage)
+	}
+	reader.calls.Store(0)
+
+	// concurrent misses of the same account and slot cost one upstream call each
+	slot := common.HexToHash("0x1337")
+	upstream := common.BigToHash(big.NewInt(42))
+	var wg sync.WaitGroup
+	for _, execCtx := range sessions {
+		wg.Add(1)
+		go func(execCtx *services.ExecutionCtx) {
+			defer wg.Done()
+			code, err := execCtx.Db.GetCode(ctx, types.Address0x69)
+			require.NoError(t, err)
+			require.NotEmpty(t, code)
+			value, err := execCtx.Db.GetState(ctx, types.Address0x69, slot)
+			require.NoError(t, err)
+			require.Equal(t, upstream, value)
+		}(execCtx)
+	}
+	wg.Wait()
+	// code, balance and nonce of the account along with the slot
+	require.Equal(t, int64(4), reader.calls.Load())
+
+	// writes stay in the session which made them, a zero write shadows the base
+	written := common.HexToHash("0xbeef")
+	require.NoError(t, smelter.SetStorageAt(keys[0], types.Address0x69, slot, written))
+	require.NoError(t, sessions[1].Db.SetBalance(ctx, types.Address0x69, big.NewInt(0)))
+
+	value, err := sessions[0].Db.GetState(ctx, types.Address0x69, slot)
+	require.NoError(t, err)
+	require.Equal(t, written, value)
+	value, err = sessions[2].Db.GetState(ctx, types.Address0x69, slot)
+	require.NoError(t, err)
+	require.Equal(t, upstream, value)
+
+	require.NoError(t, smelter.SetStorageAt(keys[0], types.Address0x69, slot, common.Hash{}))
+	value, err = sessions[0].Db.GetState(ctx, types.Address0x69, slot)
+	require.NoError(t, err)
+	require.Equal(t, common.Hash{}, value)
+
+	balance, err := sessions[1].Db.GetBalance(ctx, types.Address0x69)
+	require.NoError(t, err)
+	require.Zero(t, balance.Sign())
+	balance, err = sessions[2].Db.GetBalance(ctx, types.Address0x69)
+	require.NoError(t, err)
+	require.Equal(t, int64(42), balance.Int64())
+	require.Equal(t, int64(4), reader.calls.Load())
+
+	require.NotZero(t, storage.Metrics().BaseBytes)
+}
+
+// blockingProvider holds the storage reads until released.
+type blockingProvider struct {
+	mockProvider
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingProvider) StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) ([]byte, error) {
+	close(b.started)
+	select {
+	case <-b.release:
+		return common.BigToHash(big.NewInt(42)).Bytes(), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestSharedBaseCancel(t *testing.T) {
+	reader := &blockingProvider{started: make(chan struct{}), release: make(chan struct{})}
+	base := fork.NewBase(reader)
+	block := big.NewInt(1)
+	slot := common.HexToHash("0x1337")
+
+	// the caller which starts the upstream read gives up on it
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := base.Slot(first, block, types.Address0x69, slot)
+		firstErr <- err
+	}()
+	<-reader.started
+
+	value := make(chan common.Hash, 1)
+	go func() {
+		v, err := base.Slot(context.Background(), block, types.Address0x69, slot)
+		require.NoError(t, err)
+		value <- v
+	}()
+
+	cancel()
+	require.ErrorIs(t, <-firstErr, context.Canceled)
+
+	// the read goes on for the callers still waiting
+	close(reader.release)
+	require.Equal(t, common.BigToHash(big.NewInt(42)), <-value)
+}
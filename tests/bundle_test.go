@@ -0,0 +1,213 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	types2 "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/services"
+	"github.com/raul0ligma/smelter/tracer"
+	"github.com/raul0ligma/smelter/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallBundle(t *testing.T) {
+	ctx := context.Background()
+	exec := newTestExecutor(t)
+
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err, "failed to generate key")
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	target := types.Address0x69
+	coinbase := common.HexToAddress("0x00000000000000000000000000000000000000c0")
+	balanceOf := common.FromHex("0x70a08231000000000000000000000000" + sender.Hex()[2:])
+
+	signer := types2.LatestSignerForChainID(new(big.Int).SetUint64(testForkConfig().ChainID))
+	sign := func(nonce uint64, to common.Address, value *big.Int, data []byte) *types2.Transaction {
+		tx, err := types2.SignNewTx(key, signer, &types2.LegacyTx{
+			Nonce:    nonce,
+			To:       &to,
+			Value:    value,
+			Gas:      100_000,
+			GasPrice: big.NewInt(params.GWei),
+			Data:     data,
+		})
+		require.NoError(t, err, "failed to sign tx")
+		return tx
+	}
+
+	deposit := sign(0, target, big.NewInt(1000), hexutil.MustDecode("0xd0e30db0"))
+	read := sign(1, target, new(big.Int), balanceOf)
+	payment := sign(2, coinbase, big.NewInt(5), nil)
+	txs := []*types2.Transaction{deposit, read, payment}
+	overrides := entity.StateOverrides{sender: {Balance: abi.MaxUint256}}
+	blockOverrides := &entity.BlockOverrides{FeeRecipient: &coinbase}
+
+	result, err := exec.CallBundle(ctx, txs, overrides, blockOverrides, false)
+	require.NoError(t, err, "failed to call bundle")
+	require.False(t, result.Committed, "bundle committed without commit")
+	require.Len(t, result.Results, 3)
+	require.Equal(t, crypto.Keccak256Hash(deposit.Hash().Bytes(), read.Hash().Bytes(), payment.Hash().Bytes()), result.BundleHash, "invalid bundle hash")
+
+	first := result.Results[0]
+	require.True(t, first.Success, "deposit failed")
+	require.Equal(t, sender, first.FromAddress, "invalid sender")
+	require.Len(t, first.Logs, 1, "missing deposit log")
+	require.Equal(t, target, first.Logs[0].Address, "invalid log address")
+	require.Contains(t, first.StateDiff, target, "deposit missing from state diff")
+	require.NotEmpty(t, first.StateDiff[target].Storage, "deposit storage missing from state diff")
+
+	require.Equal(t, int64(1000), new(big.Int).SetBytes(result.Results[1].ReturnData).Int64(), "state not chained between txs")
+	require.NotContains(t, result.Results[1].StateDiff, target, "read only call changed the target")
+
+	last := result.Results[2]
+	fees := new(big.Int).Mul(big.NewInt(params.GWei), new(big.Int).SetUint64(uint64(last.GasUsed)))
+	require.Equal(t, fees, last.GasFees.ToInt(), "invalid gas fees")
+	require.GreaterOrEqual(t, uint64(last.GasUsed), params.TxGas, "intrinsic gas not charged")
+	require.Equal(t, int64(5), last.EthSentToCoinbase.ToInt().Int64(), "invalid coinbase payment")
+	require.Equal(t, new(big.Int).Add(fees, big.NewInt(5)), last.CoinbaseDiff.ToInt(), "invalid coinbase diff")
+	require.Equal(t, int64(5), result.EthSentToCoinbase.ToInt().Int64(), "invalid bundle coinbase payment")
+
+	balance := func() int64 {
+		ret, _, err := exec.Call(ctx, ethereum.CallMsg{
+			From:  sender,
+			To:    &target,
+			Data:  balanceOf,
+			Gas:   30000000,
+			Value: new(big.Int),
+		}, tracer.NewTracer(false), nil)
+		require.NoError(t, err, "failed to read session balance")
+		return new(big.Int).SetBytes(ret).Int64()
+	}
+	require.Equal(t, int64(0), balance(), "uncommitted bundle leaked into the session")
+
+	// the nonces and the chain id are checked like on a regular send
+	_, err = exec.CallBundle(ctx, []*types2.Transaction{deposit, payment}, overrides, blockOverrides, true)
+	require.ErrorContains(t, err, "nonce too high")
+	_, err = exec.CallBundle(ctx, []*types2.Transaction{deposit, deposit}, overrides, blockOverrides, true)
+	require.ErrorContains(t, err, "nonce too low")
+	foreign, err := types2.SignNewTx(key, types2.LatestSignerForChainID(big.NewInt(1)), &types2.LegacyTx{
+		Gas:      21000,
+		GasPrice: big.NewInt(params.GWei),
+		To:       &coinbase,
+		Value:    new(big.Int),
+	})
+	require.NoError(t, err, "failed to sign tx")
+	_, err = exec.CallBundle(ctx, []*types2.Transaction{foreign}, overrides, blockOverrides, true)
+	require.ErrorContains(t, err, "invalid chain id")
+	_, err = exec.CallBundle(ctx, []*types2.Transaction{deposit}, nil, blockOverrides, true)
+	require.ErrorContains(t, err, "insufficient funds for gas * price + value")
+	lowGas, err := types2.SignNewTx(key, signer, &types2.LegacyTx{
+		Gas:      params.TxGas - 1,
+		GasPrice: big.NewInt(params.GWei),
+		To:       &coinbase,
+		Value:    new(big.Int),
+	})
+	require.NoError(t, err, "failed to sign tx")
+	_, err = exec.CallBundle(ctx, []*types2.Transaction{lowGas}, overrides, blockOverrides, true)
+	require.ErrorContains(t, err, "intrinsic gas too low")
+	require.Equal(t, int64(0), balance(), "rejected bundle leaked into the session")
+
+	// a failing bundle is never committed
+	withdraw := sign(3, target, new(big.Int), hexutil.MustDecode("0x2e1a7d4d0000000000000000000000000000000000000000000000000de0b6b3a7640000"))
+	failed, err := exec.CallBundle(ctx, append(txs, withdraw), overrides, blockOverrides, true)
+	require.NoError(t, err, "failed to call bundle")
+	require.False(t, failed.Results[3].Success, "withdraw did not revert")
+	require.False(t, failed.Committed, "failed bundle committed")
+	require.Equal(t, int64(0), balance(), "failed bundle leaked into the session")
+
+	_, prevNum := exec.Latest()
+	committed, err := exec.CallBundle(ctx, txs, overrides, blockOverrides, true)
+	require.NoError(t, err, "failed to commit bundle")
+	require.True(t, committed.Committed, "bundle not committed")
+	require.Equal(t, hexutil.Uint64(prevNum+1), *committed.BlockNumber, "invalid block number")
+	require.Equal(t, int64(1000), balance(), "committed bundle not applied")
+
+	hash, num := exec.Latest()
+	require.Equal(t, *committed.BlockHash, hash, "latest block not updated")
+	block := exec.BlockStorage().GetBlockByNumber(num)
+	require.NotNil(t, block, "block not stored")
+	require.Len(t, block.Block.Transactions(), 3, "bundle not mined as one block")
+
+	for i, tx := range txs {
+		receipt := exec.TxnStorage().GetReceipt(tx.Hash())
+		require.NotNil(t, receipt, "receipt not stored")
+		require.Equal(t, uint(i), receipt.TransactionIndex, "invalid receipt index")
+		require.Equal(t, hash, receipt.BlockHash, "invalid receipt block hash")
+
+		from, ok := exec.TxnStorage().GetSender(tx.Hash())
+		require.True(t, ok, "sender not indexed")
+		require.Equal(t, sender, from, "invalid indexed sender")
+	}
+}
+
+// emptyCodeProvider is a mockProvider without code so contracts can be created
+// at any address.
+type emptyCodeProvider struct {
+	mockProvider
+}
+
+func (e *emptyCodeProvider) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	return nil, nil
+}
+
+func TestTraceCommittedCreateBundle(t *testing.T) {
+	ctx := context.Background()
+	reader := emptyCodeProvider{}
+	forkCfg := testForkConfig()
+	storage := services.NewExecutionStorage(forkCfg, &reader, time.Hour)
+	eth := services.NewRpcService(storage, forkCfg, &reader)
+	smelter := services.NewSmelterRpc(storage)
+	debug := services.NewDebugRpc(storage, forkCfg, &reader)
+	trace := services.NewTraceRpc(storage, forkCfg, &reader)
+	otterscan := services.NewOtterscanRpc(eth, storage, forkCfg, &reader)
+
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err, "failed to generate key")
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	require.NoError(t, smelter.SetStateOverrides(ctx, entity.StateOverrides{sender: {Balance: abi.MaxUint256}}))
+
+	// stores 42 at slot 0 and deploys an empty contract
+	signer := types2.LatestSignerForChainID(new(big.Int).SetUint64(forkCfg.ChainID))
+	create, err := types2.SignNewTx(key, signer, &types2.LegacyTx{
+		Gas:      200_000,
+		GasPrice: big.NewInt(params.GWei),
+		Value:    new(big.Int),
+		Data:     hexutil.MustDecode("0x602a60005560006000f3"),
+	})
+	require.NoError(t, err, "failed to sign tx")
+	encoded, err := create.MarshalBinary()
+	require.NoError(t, err)
+
+	result, err := smelter.SimulateBundle(ctx, entity.BundleArgs{Txs: []hexutil.Bytes{encoded}, Commit: true})
+	require.NoError(t, err, "failed to commit bundle")
+	require.True(t, result.Committed, "bundle not committed")
+
+	raw, err := json.Marshal([]any{create.Hash()})
+	require.NoError(t, err)
+	_, err = debug.TraceTransaction(ctx, jsonrpc.RawParams(raw))
+	require.NoError(t, err, "failed to trace create")
+
+	_, err = trace.ReplayTransaction(ctx, create.Hash(), []entity.TraceType{entity.TraceTypeTrace})
+	require.NoError(t, err, "failed to replay create")
+
+	traces, err := trace.Transaction(ctx, create.Hash())
+	require.NoError(t, err, "failed to get create traces")
+	require.NotEmpty(t, traces, "missing create trace")
+	require.Equal(t, "create", traces[0].Type, "invalid trace type")
+
+	txErr, err := otterscan.GetTransactionError(ctx, create.Hash())
+	require.NoError(t, err, "failed to get create error")
+	require.Equal(t, "0x", txErr, "create reverted")
+}
@@ -0,0 +1,164 @@
+package tests
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	types2 "github.com/ethereum/go-ethereum/core/types"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/pkg/server"
+	"github.com/raul0ligma/smelter/provider"
+	"github.com/raul0ligma/smelter/services"
+	"github.com/raul0ligma/smelter/types"
+	"github.com/stretchr/testify/require"
+)
+
+// countingProvider is a mockProvider counting the state and block reads.
+type countingProvider struct {
+	mockProvider
+	calls atomic.Int64
+}
+
+func (c *countingProvider) BlockByNumber(ctx context.Context, number *big.Int) (*types2.Block, error) {
+	c.calls.Add(1)
+	return c.mockProvider.BlockByNumber(ctx, number)
+}
+
+func (c *countingProvider) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	c.calls.Add(1)
+	return big.NewInt(42), nil
+}
+
+func (c *countingProvider) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	c.calls.Add(1)
+	return c.mockProvider.CodeAt(ctx, account, blockNumber)
+}
+
+func (c *countingProvider) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	c.calls.Add(1)
+	return 7, nil
+}
+
+func (c *countingProvider) StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) ([]byte, error) {
+	c.calls.Add(1)
+	return common.BigToHash(big.NewInt(42)).Bytes(), nil
+}
+
+func TestUpstreamCache(t *testing.T) {
+	ctx := context.WithValue(context.Background(), server.Key{}, "cache")
+	forkCfg := testForkConfig()
+	dir := t.TempDir()
+	whale := common.HexToAddress("0x0000000000000000000000000000000000000006")
+
+	readSession := func(reader *countingProvider) {
+		cache, err := provider.NewDiskCache(dir, 0)
+		require.NoError(t, err)
+		cached := provider.NewCachedProvider(reader, cache, forkCfg.ChainID, 0)
+		storage := services.NewExecutionStorage(forkCfg, cached, time.Hour)
+
+		execCtx := mustSession(t, ctx, storage)
+		code, err := execCtx.Db.GetCode(ctx, types.Address0x69)
+		require.NoError(t, err)
+		require.NotEmpty(t, code)
+		balance, err := execCtx.Db.GetBalance(ctx, whale)
+		require.NoError(t, err)
+		require.Equal(t, int64(42), balance.Int64())
+		nonce, err := execCtx.Db.GetNonce(ctx, whale)
+		require.NoError(t, err)
+		require.Equal(t, uint64(7), nonce)
+	}
+
+	first := &countingProvider{}
+	readSession(first)
+	require.NotZero(t, first.calls.Load())
+
+	// a restart reads everything at the fork block from the disk
+	restarted := &countingProvider{}
+	readSession(restarted)
+	require.Zero(t, restarted.calls.Load())
+
+	cache, err := provider.NewDiskCache(dir, 0)
+	require.NoError(t, err)
+	reader := &countingProvider{}
+	cached := provider.NewCachedProvider(reader, cache, forkCfg.ChainID, 0)
+
+	// requests at the latest block always go to the upstream
+	_, err = cached.BalanceAt(ctx, whale, nil)
+	require.NoError(t, err)
+	_, err = cached.BalanceAt(ctx, whale, nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), reader.calls.Load())
+
+	// batched requests share the entries of the single ones
+	results, err := cached.Batch(ctx, []entity.BatchReq{
+		{Method: provider.MethodBalanceAt, Params: []any{whale.Hex(), forkCfg.ForkBlock}},
+		{Method: provider.MethodNonceAt, Params: []any{whale.Hex(), forkCfg.ForkBlock}},
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `"0x2a"`, string(results[0]))
+	require.JSONEq(t, `"0x7"`, string(results[1]))
+
+	stats := cached.CacheStats()
+	require.Equal(t, forkCfg.ChainID, stats.ChainID)
+	require.NotZero(t, stats.Entries)
+	require.NotZero(t, stats.Hits)
+
+	require.NoError(t, cached.ClearCache())
+	require.Zero(t, cached.CacheStats().Entries)
+	_, err = cached.BalanceAt(ctx, whale, forkCfg.ForkBlock)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), reader.calls.Load())
+}
+
+func TestUpstreamCacheLimit(t *testing.T) {
+	ctx := context.Background()
+	cache, err := provider.NewDiskCache(t.TempDir(), 64)
+	require.NoError(t, err)
+	reader := &countingProvider{}
+	cached := provider.NewCachedProvider(reader, cache, 69, 0)
+
+	for i := int64(1); i <= 16; i++ {
+		_, err = cached.BalanceAt(ctx, common.BigToAddress(big.NewInt(i)), big.NewInt(1))
+		require.NoError(t, err)
+	}
+
+	stats := cached.CacheStats()
+	require.LessOrEqual(t, stats.Bytes, int64(64))
+	require.NotZero(t, stats.Evicted)
+	require.Equal(t, int64(64), stats.MaxBytes)
+
+	// the latest entry is kept
+	_, err = cached.BalanceAt(ctx, common.BigToAddress(big.NewInt(16)), big.NewInt(1))
+	require.NoError(t, err)
+	require.Equal(t, int64(16), reader.calls.Load())
+}
+
+func TestUpstreamCacheConfirmations(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	whale := common.HexToAddress("0x0000000000000000000000000000000000000006")
+
+	// unfinished writes of a previous run are removed
+	tmp := filepath.Join(dir, "69", ".tmp-123")
+	require.NoError(t, os.MkdirAll(filepath.Dir(tmp), 0o755))
+	require.NoError(t, os.WriteFile(tmp, []byte("partial"), 0o644))
+	cache, err := provider.NewDiskCache(dir, 0)
+	require.NoError(t, err)
+	require.NoFileExists(t, tmp)
+
+	// the head of the mock is block 1, it's not confirmed yet
+	reader := &countingProvider{}
+	cached := provider.NewCachedProvider(reader, cache, 69, 10)
+	for range 2 {
+		_, err = cached.BalanceAt(ctx, whale, big.NewInt(1))
+		require.NoError(t, err)
+	}
+	require.Equal(t, int64(2), reader.calls.Load())
+	require.Zero(t, cached.CacheStats().Entries)
+}
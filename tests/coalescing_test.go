@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/provider"
+	"github.com/stretchr/testify/require"
+)
+
+var badSlot = common.HexToHash("0xbad")
+
+// batchingProvider is a mockProvider answering batches of eth_getStorageAt with
+// the slot key as its value, a batch with badSlot fails as a whole. With down
+// every batch fails like on a transport error.
+type batchingProvider struct {
+	mockProvider
+	mu      sync.Mutex
+	batches []int
+	down    error
+}
+
+func (b *batchingProvider) SupportsBatching() bool { return true }
+
+func (b *batchingProvider) Batch(ctx context.Context, requests []entity.BatchReq) ([]json.RawMessage, error) {
+	b.mu.Lock()
+	b.batches = append(b.batches, len(requests))
+	b.mu.Unlock()
+	if b.down != nil {
+		return nil, b.down
+	}
+
+	results := make([]json.RawMessage, len(requests))
+	for i, req := range requests {
+		if req.Method != provider.MethodGetStorageAt {
+			return nil, errors.New("unexpected method")
+		}
+
+		key := req.Params[1].(common.Hash)
+		if key == badSlot {
+			return nil, &provider.RequestError{ID: i + 1, Message: "bad slot"}
+		}
+
+		results[i], _ = json.Marshal(hexutil.Bytes(key.Bytes()))
+	}
+
+	return results, nil
+}
+
+func TestCoalescingProvider(t *testing.T) {
+	ctx := context.Background()
+	addr := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	block := big.NewInt(1)
+
+	upstream := &batchingProvider{}
+	reader := provider.NewCoalescingProvider(upstream, 20*time.Millisecond, 16)
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 40; i++ {
+		wg.Add(1)
+		go func(key common.Hash) {
+			defer wg.Done()
+			value, err := reader.StorageAt(ctx, addr, key, block)
+			require.NoError(t, err)
+			require.Equal(t, key.Bytes(), value)
+		}(common.BigToHash(big.NewInt(int64(i))))
+	}
+	wg.Wait()
+
+	total := 0
+	for _, size := range upstream.batches {
+		require.LessOrEqual(t, size, 16)
+		total += size
+	}
+	require.Equal(t, 40, total)
+	require.Less(t, len(upstream.batches), 40)
+
+	// a failing request only fails its own caller
+	upstream.batches = nil
+	errs := make(chan error, 2)
+	for _, key := range []common.Hash{common.BigToHash(big.NewInt(1)), badSlot} {
+		go func(key common.Hash) {
+			_, err := reader.StorageAt(ctx, addr, key, block)
+			errs <- err
+		}(key)
+	}
+
+	failed := 0
+	for range 2 {
+		if err := <-errs; err != nil {
+			failed++
+		}
+	}
+	require.Equal(t, 1, failed)
+
+	// a failed batch is bisected instead of retried request by request
+	upstream = &batchingProvider{}
+	reader = provider.NewCoalescingProvider(upstream, time.Second, 16)
+	errs = make(chan error, 16)
+	for i := 1; i <= 16; i++ {
+		key := common.BigToHash(big.NewInt(int64(i)))
+		if i == 16 {
+			key = badSlot
+		}
+		go func(key common.Hash) {
+			_, err := reader.StorageAt(ctx, addr, key, block)
+			errs <- err
+		}(key)
+	}
+
+	failed = 0
+	for range 16 {
+		if err := <-errs; err != nil {
+			failed++
+		}
+	}
+	require.Equal(t, 1, failed)
+	require.Len(t, upstream.batches, 9)
+
+	// a transport error fails the whole batch without retries
+	down := errors.New("429 Too Many Requests")
+	upstream = &batchingProvider{down: down}
+	reader = provider.NewCoalescingProvider(upstream, time.Second, 16)
+	for i := 1; i <= 16; i++ {
+		go func(key common.Hash) {
+			_, err := reader.StorageAt(ctx, addr, key, block)
+			errs <- err
+		}(common.BigToHash(big.NewInt(int64(i))))
+	}
+	for range 16 {
+		require.ErrorIs(t, <-errs, down)
+	}
+	require.Len(t, upstream.batches, 1)
+
+	// a cancelled caller stops waiting for the batch
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := reader.StorageAt(cancelled, addr, common.BigToHash(big.NewInt(1)), block)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestBatchRpcProviderResponses(t *testing.T) {
+	ctx := context.Background()
+	var (
+		status = http.StatusOK
+		body   = `[{"jsonrpc":"2.0","id":99,"result":"0x1"}]`
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	upstream, err := provider.NewBatchJSONRPcProvider(server.URL)
+	require.NoError(t, err)
+	requests := []entity.BatchReq{{Method: provider.MethodBlockNumber}}
+
+	// an id which matches no request is rejected instead of indexing past the results
+	_, err = upstream.Batch(ctx, requests)
+	require.ErrorContains(t, err, "invalid batch response id 99")
+
+	status, body = http.StatusTooManyRequests, `{"error":"rate limited"}`
+	_, err = upstream.Batch(ctx, requests)
+	require.ErrorContains(t, err, "429")
+
+	var requestErr *provider.RequestError
+	status, body = http.StatusOK, `[{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"header not found"}}]`
+	_, err = upstream.Batch(ctx, requests)
+	require.ErrorAs(t, err, &requestErr)
+	require.Equal(t, 1, requestErr.ID)
+}
@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/tracer"
+	"github.com/raul0ligma/smelter/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallWithDiff(t *testing.T) {
+	ctx := context.Background()
+	exec := newTestExecutor(t)
+
+	target := types.Address0x69
+	sender := common.HexToAddress("0x0000000000000000000000000000000000000006")
+	recipient := common.HexToAddress("0x0000000000000000000000000000000000000007")
+	call := func(data string, value int64) ethereum.CallMsg {
+		return ethereum.CallMsg{
+			From:  sender,
+			To:    &target,
+			Data:  hexutil.MustDecode(data),
+			Gas:   30000000,
+			Value: big.NewInt(value),
+		}
+	}
+
+	// balanceOf is the fourth slot of WETH9
+	balanceSlot := crypto.Keccak256Hash(common.LeftPadBytes(sender.Bytes(), 32), common.LeftPadBytes([]byte{3}, 32))
+	funded := entity.StateOverrides{target: {StateDiff: entity.Storage{balanceSlot: common.BigToHash(big.NewInt(6969))}}}
+
+	// transfer(0x7, 6967)
+	transfer, err := exec.CallWithDiff(ctx, call("0xa9059cbb00000000000000000000000000000000000000000000000000000000000000070000000000000000000000000000000000000000000000000000000000001b37", 0), funded)
+	require.NoError(t, err, "failed to simulate transfer")
+	require.True(t, transfer.Success, "transfer failed")
+	require.Equal(t, []entity.AssetChange{{
+		Type:   entity.AssetTypeERC20,
+		Token:  target,
+		From:   sender,
+		To:     recipient,
+		Amount: (*hexutil.Big)(big.NewInt(6967)),
+	}}, transfer.AssetChanges, "invalid asset changes")
+	require.Empty(t, transfer.BalanceChanges, "transfer changed native balances")
+	require.Contains(t, transfer.StateDiff, target, "token storage missing from state diff")
+	require.Len(t, transfer.StateDiff[target].Storage, 2, "expected sender and recipient balance slots")
+	require.Equal(t, entity.Diff{From: common.BigToHash(big.NewInt(6969)), To: common.BigToHash(big.NewInt(2))}, transfer.StateDiff[target].Storage[balanceSlot], "invalid sender slot diff")
+	require.Equal(t, entity.Diff{From: hexutil.Uint64(0), To: hexutil.Uint64(1)}, transfer.StateDiff[sender].Nonce, "sender nonce not in state diff")
+
+	// deposit() with an overridden balance, the override itself is not reported
+	overrides := entity.StateOverrides{sender: {Balance: big.NewInt(5000)}}
+	deposit, err := exec.CallWithDiff(ctx, call("0xd0e30db0", 1000), overrides)
+	require.NoError(t, err, "failed to simulate deposit")
+	require.Len(t, deposit.AssetChanges, 1, "missing native transfer")
+	require.Equal(t, entity.AssetTypeNative, deposit.AssetChanges[0].Type, "deposit not a native transfer")
+	require.Equal(t, int64(1000), deposit.AssetChanges[0].Amount.ToInt().Int64(), "invalid native amount")
+	require.Len(t, deposit.Logs, 1, "native transfer reported as a log")
+	require.Len(t, deposit.BalanceChanges, 2, "expected sender and token balance changes")
+	for _, change := range deposit.BalanceChanges {
+		switch change.Address {
+		case sender:
+			require.Equal(t, int64(5000), change.From.ToInt().Int64(), "override reported as a change")
+			require.Equal(t, int64(-1000), change.Diff.ToInt().Int64(), "invalid sender diff")
+		case target:
+			require.Equal(t, int64(1000), change.Diff.ToInt().Int64(), "invalid token diff")
+		default:
+			t.Fatalf("unexpected balance change of %s", change.Address)
+		}
+	}
+
+	// approve(0x7, 5)
+	approve, err := exec.CallWithDiff(ctx, call("0x095ea7b300000000000000000000000000000000000000000000000000000000000000070000000000000000000000000000000000000000000000000000000000000005", 0), nil)
+	require.NoError(t, err, "failed to simulate approve")
+	require.Equal(t, []entity.ApprovalChange{{
+		Type:    entity.AssetTypeERC20,
+		Token:   target,
+		Owner:   sender,
+		Spender: recipient,
+		Amount:  (*hexutil.Big)(big.NewInt(5)),
+	}}, approve.Approvals, "invalid approvals")
+
+	// nothing leaks into the session state
+	ret, _, err := exec.Call(ctx, call("0x70a082310000000000000000000000000000000000000000000000000000000000000007", 0), tracer.NewTracer(false), nil)
+	require.NoError(t, err, "failed to read session balance")
+	require.Equal(t, int64(0), new(big.Int).SetBytes(ret).Int64(), "simulation leaked into the session")
+}
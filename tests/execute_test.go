@@ -11,6 +11,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	types2 "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/raul0ligma/smelter/config"
 	"github.com/raul0ligma/smelter/entity"
 	"github.com/raul0ligma/smelter/executor"
@@ -167,6 +168,23 @@ func TestBlockProduction(t *testing.T) {
 	require.Equal(t, txn.Value().String(), "6969", "invalid txn value")
 	require.Equal(t, txn.Data(), deposit, "invalid txn data")
 
+	// the sender is fresh, so its first transaction is found at nonce 0
+	indexed, ok := exec.TxnStorage().GetBySenderAndNonce(sender, 0)
+	require.True(t, ok, "txn not indexed by sender and nonce")
+	require.Equal(t, *hash, indexed, "invalid txn indexed by sender and nonce")
+
+	for _, account := range []common.Address{sender, target} {
+		history := exec.TxnStorage().AccountTransactions(account, nil, 10, true)
+		require.Len(t, history, 1, "invalid account history")
+		require.Equal(t, *hash, history[0].Hash, "invalid txn in account history")
+	}
+
+	otterTrace := exec.TxnStorage().GetTrace(*hash)
+	require.Len(t, otterTrace, 1, "invalid otter trace")
+	require.Equal(t, "CALL", otterTrace[0].Type, "invalid otter trace type")
+	require.Equal(t, "0x", otterTrace[0].Output, "invalid otter trace output")
+	require.Equal(t, hexutil.EncodeUint64(msg.Gas), otterTrace[0].Gas, "invalid otter trace gas")
+
 	receipt := exec.TxnStorage().GetReceipt(*hash)
 
 	require.Equal(t, len(receipt.Logs), 1, "invalid logs emitted")
@@ -205,3 +223,74 @@ func TestBlockProduction(t *testing.T) {
 	)
 	require.Equal(t, state.Block.Number().Uint64(), uint64(2), "invalid block number")
 }
+
+func TestCallOverrides(t *testing.T) {
+	ctx := context.Background()
+	target := types.Address0x69
+	exec := newTestExecutor(t)
+
+	sender := common.HexToAddress("0x0000000000000000000000000000000000000006")
+	deposit, _ := hexutil.Decode("0xd0e30db0")
+	_, _, _, err := exec.CallAndPersist(
+		ctx, ethereum.CallMsg{
+			From:  sender,
+			To:    &target,
+			Data:  deposit,
+			Gas:   30000000,
+			Value: new(big.Int).SetInt64(6969),
+		}, tracer.NewTracer(false), map[common.Address]entity.StateOverride{
+			sender: {Balance: abi.MaxUint256},
+		},
+	)
+	require.NoError(t, err, "failed to deposit")
+
+	balanceOf, _ := hexutil.Decode("0x70a082310000000000000000000000000000000000000000000000000000000000000006")
+	balanceCall := ethereum.CallMsg{
+		From:  sender,
+		To:    &target,
+		Data:  balanceOf,
+		Gas:   30000000,
+		Value: new(big.Int),
+	}
+
+	ret, _, err := exec.CallWithDB(ctx, balanceCall, tracer.NewTracer(false), nil, entity.StateOverrides{
+		target: {StateDiff: entity.Storage{common.HexToHash("0x1"): common.HexToHash("0x1")}},
+	}, nil)
+	require.NoError(t, err, "failed to call with state diff")
+	require.Equal(t, int64(6969), new(big.Int).SetBytes(ret).Int64(), "state diff dropped untouched slots")
+
+	// a zero in the state diff shadows the balance the sender deposited
+	balanceSlot := crypto.Keccak256Hash(common.LeftPadBytes(sender.Bytes(), 32), common.LeftPadBytes([]byte{3}, 32))
+	ret, _, err = exec.CallWithDB(ctx, balanceCall, tracer.NewTracer(false), nil, entity.StateOverrides{
+		target: {StateDiff: entity.Storage{balanceSlot: {}}},
+	}, nil)
+	require.NoError(t, err, "failed to call with zero state diff")
+	require.Equal(t, int64(0), new(big.Int).SetBytes(ret).Int64(), "zero state diff fell through to the session")
+
+	ret, _, err = exec.CallWithDB(ctx, balanceCall, tracer.NewTracer(false), nil, entity.StateOverrides{
+		target: {State: entity.Storage{}},
+	}, nil)
+	require.NoError(t, err, "failed to call with state replace")
+	require.Equal(t, int64(0), new(big.Int).SetBytes(ret).Int64(), "state replace kept the old slots")
+
+	ret, _, err = exec.CallWithDB(ctx, balanceCall, tracer.NewTracer(false), nil, nil, nil)
+	require.NoError(t, err, "failed to call without overrides")
+	require.Equal(t, int64(6969), new(big.Int).SetBytes(ret).Int64(), "overrides leaked into the session")
+
+	// returns abi.encode(block.number, block.timestamp)
+	env := common.HexToAddress("0x0000000000000000000000000000000000000e0e")
+	code, _ := hexutil.Decode("0x436000524260205260406000f3")
+	number, timestamp := hexutil.Big(*big.NewInt(1234)), hexutil.Uint64(5678)
+	ret, _, err = exec.CallWithDB(ctx, ethereum.CallMsg{
+		From:  sender,
+		To:    &env,
+		Gas:   30000000,
+		Value: new(big.Int),
+	}, tracer.NewTracer(false), nil, entity.StateOverrides{
+		env: {Code: code},
+	}, &entity.BlockOverrides{Number: &number, Time: &timestamp})
+	require.NoError(t, err, "failed to call with block overrides")
+	require.Len(t, ret, 64, "invalid block env output")
+	require.Equal(t, int64(1234), new(big.Int).SetBytes(ret[:32]).Int64(), "block number not overridden")
+	require.Equal(t, int64(5678), new(big.Int).SetBytes(ret[32:]).Int64(), "timestamp not overridden")
+}
@@ -0,0 +1,35 @@
+package tests
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/raul0ligma/smelter/config"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/executor"
+	"github.com/raul0ligma/smelter/fork"
+	"github.com/stretchr/testify/require"
+)
+
+// testForkConfig is the fork the tests run against, the mock upstream is at block 1.
+func testForkConfig() entity.ForkConfig {
+	return entity.ForkConfig{
+		ChainID:   69,
+		ForkBlock: new(big.Int).SetUint64(1),
+	}
+}
+
+// newTestExecutor returns an executor forked from the mock upstream.
+func newTestExecutor(t *testing.T) *executor.SerialExecutor {
+	reader := mockProvider{}
+	forkCfg := testForkConfig()
+	db := fork.NewDB(&reader, forkCfg, entity.NewAccountsStorage(), entity.NewAccountsState())
+	cfg := config.NewConfigWithDefaults()
+	cfg.ForkConfig = &forkCfg
+	cfg.ChainConfig.ChainID = new(big.Int).SetUint64(forkCfg.ChainID)
+	exec, err := executor.NewExecutor(context.Background(), cfg, db, &reader)
+	require.NoError(t, err, "failed to create executor")
+
+	return exec
+}
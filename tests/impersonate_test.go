@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/services"
+	"github.com/raul0ligma/smelter/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImpersonatedAccounts(t *testing.T) {
+	ctx := context.Background()
+	reader := mockProvider{}
+	forkCfg := testForkConfig()
+	storage := services.NewExecutionStorage(forkCfg, &reader, time.Hour)
+	eth := services.NewRpcService(storage, forkCfg, &reader)
+	smelter := services.NewSmelterRpc(storage)
+
+	whale := common.HexToAddress("0x0000000000000000000000000000000000000006")
+	admin := common.HexToAddress("0x0000000000000000000000000000000000000007")
+	keeper := common.HexToAddress("0x0000000000000000000000000000000000000008")
+	require.NoError(t, smelter.SetStateOverrides(ctx, entity.StateOverrides{
+		whale:  {Balance: abi.MaxUint256},
+		admin:  {Balance: abi.MaxUint256},
+		keeper: {Balance: abi.MaxUint256},
+	}))
+
+	target := types.Address0x69
+	deposit := hexutil.Bytes(hexutil.MustDecode("0xd0e30db0"))
+	value := (*hexutil.Big)(big.NewInt(1000))
+	send := func(from common.Address) error {
+		_, err := eth.SendTransaction(ctx, entity.TransactionArgs{From: &from, To: &target, Value: value, Data: &deposit})
+		return err
+	}
+
+	require.ErrorContains(t, send(whale), "not impersonated", "unsigned transaction accepted")
+
+	require.NoError(t, smelter.ImpersonateAccount(ctx, whale))
+	require.NoError(t, smelter.ImpersonateAccount(ctx, admin))
+	require.NoError(t, send(whale), "whale transaction failed")
+	require.NoError(t, send(admin), "admin transaction failed")
+
+	stop := func(addrs ...common.Address) {
+		params, err := json.Marshal(addrs)
+		require.NoError(t, err)
+		require.NoError(t, smelter.StopImpersonatingAccount(ctx, jsonrpc.RawParams(params)))
+	}
+	stop(admin)
+	require.Error(t, send(admin), "stopped account accepted")
+	require.NoError(t, send(whale), "other accounts dropped")
+
+	require.NoError(t, smelter.AutoImpersonateAccount(ctx, true))
+	require.NoError(t, send(keeper), "auto impersonation failed")
+
+	require.NoError(t, smelter.AutoImpersonateAccount(ctx, false))
+	stop()
+	require.Error(t, send(whale), "accounts not dropped")
+
+	execCtx, err := storage.GetOrCreate(ctx)
+	require.NoError(t, err)
+	_, latest := execCtx.Executor.Latest()
+	require.Equal(t, uint64(5), latest, "expected a block per transaction")
+}
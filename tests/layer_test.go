@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/pkg/server"
+	"github.com/raul0ligma/smelter/services"
+	"github.com/raul0ligma/smelter/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayeredBlockStates(t *testing.T) {
+	ctx := context.WithValue(context.Background(), server.Key{}, "layers")
+	reader := mockProvider{}
+	forkCfg := testForkConfig()
+	storage := services.NewExecutionStorage(forkCfg, &reader, time.Hour, services.WithFlattenEvery(3))
+	eth := services.NewRpcService(storage, forkCfg, &reader)
+	smelter := services.NewSmelterRpc(storage)
+
+	whale := common.HexToAddress("0x0000000000000000000000000000000000000006")
+	require.NoError(t, smelter.ImpersonateAccount(ctx, whale))
+	require.NoError(t, smelter.SetStateOverrides(ctx, entity.StateOverrides{whale: {Balance: abi.MaxUint256}}))
+
+	// weth keeps the balances in the mapping at slot 3
+	balanceSlot := crypto.Keccak256Hash(common.LeftPadBytes(whale.Bytes(), 32), common.LeftPadBytes([]byte{3}, 32))
+	deposit := hexutil.Bytes(hexutil.MustDecode("0xd0e30db0"))
+	for i := 0; i < 4; i++ {
+		_, err := eth.SendTransaction(ctx, entity.TransactionArgs{
+			From:  &whale,
+			To:    &types.Address0x69,
+			Value: (*hexutil.Big)(big.NewInt(1000)),
+			Data:  &deposit,
+		})
+		require.NoError(t, err)
+	}
+
+	execCtx := mustSession(t, ctx, storage)
+	blocks := execCtx.Executor.BlockStorage()
+
+	// a block only keeps what it changed and reads the rest from its parent
+	third := blocks.GetBlockByNumber(3)
+	require.NotNil(t, third.Parent)
+	require.Equal(t, uint64(2), third.Parent.Block.NumberU64())
+	accounts, slots, _ := third.Accounts.Size()
+	require.LessOrEqual(t, accounts, 2)
+	require.LessOrEqual(t, slots, 1)
+
+	for number, deposited := range map[uint64]int64{2: 1000, 3: 2000, 4: 3000, 5: 4000} {
+		block := blocks.GetBlockByNumber(number)
+		require.NotNil(t, block)
+
+		value, ok := block.Slot(types.Address0x69, balanceSlot)
+		require.True(t, ok)
+		require.Equal(t, common.BigToHash(big.NewInt(deposited)), value)
+
+		account, ok := block.Account(whale)
+		require.True(t, ok)
+		require.Equal(t, uint64(number-1), account.Nonce)
+
+		code, ok := block.Code(types.Address0x69)
+		require.True(t, ok)
+		require.NotEmpty(t, code)
+	}
+
+	// the third stacked block keeps a full copy
+	fifth := blocks.GetBlockByNumber(5)
+	require.Nil(t, fifth.Parent)
+	flattened, _ := fifth.Flatten()
+	value, ok := flattened.LookupStorage(types.Address0x69, balanceSlot)
+	require.True(t, ok)
+	require.Equal(t, common.BigToHash(big.NewInt(4000)), value)
+
+	// the state at a block is rebuilt from the layers for calls
+	fourth, _ := blocks.GetBlockByNumber(4).Flatten()
+	value, ok = fourth.LookupStorage(types.Address0x69, balanceSlot)
+	require.True(t, ok)
+	require.Equal(t, common.BigToHash(big.NewInt(3000)), value)
+}
@@ -109,3 +109,9 @@ func (m *mockProvider) BatchWithUnmarshal(ctx context.Context, requests []entity
 func (m *mockProvider) Batch(ctx context.Context, requests []entity.BatchReq) ([]json.RawMessage, error) {
 	return nil, nil
 }
+func (m *mockProvider) CallContext(ctx context.Context, result any, method string, args ...any) error {
+	return nil
+}
+func (m *mockProvider) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return nil, nil
+}
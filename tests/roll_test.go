@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/pkg/server"
+	"github.com/raul0ligma/smelter/services"
+	"github.com/stretchr/testify/require"
+)
+
+// headProvider is a mockProvider with a movable chain head.
+type headProvider struct {
+	mockProvider
+	head atomic.Uint64
+}
+
+func (h *headProvider) BlockNumber(ctx context.Context) (uint64, error) {
+	return h.head.Load(), nil
+}
+
+func TestRollingFork(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reader := &headProvider{}
+	reader.head.Store(1)
+	forkCfg := testForkConfig()
+
+	var (
+		mu    sync.Mutex
+		reset []string
+	)
+	storage := services.NewExecutionStorage(forkCfg, reader, time.Hour,
+		services.WithRollingFork(10, 20*time.Millisecond),
+		services.WithResetHook(func(ctx context.Context, key string, execCtx *services.ExecutionCtx) error {
+			mu.Lock()
+			defer mu.Unlock()
+			reset = append(reset, key)
+			return nil
+		}),
+	)
+	go storage.Roller(ctx)
+	smelter := services.NewSmelterRpc(storage)
+
+	// sessions only follow the head when they opt in
+	_, err := storage.GetOrCreate(context.WithValue(ctx, server.Key{}, "implicit"))
+	require.NoError(t, err)
+	_, err = smelter.CreateSession(ctx, jsonrpc.RawParams(`["follower", null, true]`))
+	require.NoError(t, err)
+	_, err = smelter.CreateSession(ctx, jsonrpc.RawParams(`["invalid", "0x1", true]`))
+	require.Error(t, err, "rolling session pinned to a block")
+	pinned, err := json.Marshal([]any{"pinned", "0x1"})
+	require.NoError(t, err)
+	_, err = smelter.CreateSession(ctx, pinned)
+	require.NoError(t, err)
+
+	// resetFork pins the session to the given block or to the head
+	resetCtx := context.WithValue(ctx, server.Key{}, "reset")
+	info, err := smelter.ResetFork(resetCtx, jsonrpc.RawParams(`["0x5"]`))
+	require.NoError(t, err)
+	require.Equal(t, hexutil.Uint64(5), info.ForkBlock)
+	require.Equal(t, hexutil.Uint64(5), info.BlockNumber)
+
+	reader.head.Store(8)
+	info, err = smelter.ResetFork(resetCtx, jsonrpc.RawParams(`["latest"]`))
+	require.NoError(t, err)
+	require.Equal(t, hexutil.Uint64(8), info.ForkBlock)
+
+	// the head isn't far enough ahead to roll
+	time.Sleep(100 * time.Millisecond)
+	follower, err := storage.Info("follower")
+	require.NoError(t, err)
+	require.Equal(t, hexutil.Uint64(1), follower.ForkBlock)
+
+	reader.head.Store(20)
+	require.Eventually(t, func() bool {
+		follower, err = storage.Info("follower")
+		return err == nil && follower.ForkBlock == 20
+	}, 2*time.Second, 20*time.Millisecond)
+
+	for key, block := range map[string]hexutil.Uint64{"implicit": 1, "pinned": 1, "reset": 8} {
+		session, err := storage.Info(key)
+		require.NoError(t, err)
+		require.Equal(t, block, session.ForkBlock, key)
+	}
+
+	// new sessions start at the rolled fork block
+	info, err = smelter.CreateSession(ctx, jsonrpc.RawParams(`["late"]`))
+	require.NoError(t, err)
+	require.Equal(t, hexutil.Uint64(20), info.ForkBlock)
+
+	// a reset session keeps following the head
+	require.NoError(t, storage.Reset(ctx, "follower"))
+	reader.head.Store(40)
+	require.Eventually(t, func() bool {
+		follower, err = storage.Info("follower")
+		return err == nil && follower.ForkBlock == 40
+	}, 2*time.Second, 20*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"reset", "reset", "follower", "follower", "follower"}, reset)
+}
+
+func TestResetState(t *testing.T) {
+	ctx := context.WithValue(context.Background(), server.Key{}, "reset")
+	reader := &headProvider{}
+	reader.head.Store(5)
+	forkCfg := testForkConfig()
+
+	whale := common.HexToAddress("0x0000000000000000000000000000000000000006")
+	setup := &entity.StateDump{Accounts: entity.AccountsDump{
+		whale: {Balance: (*hexutil.Big)(big.NewInt(6969)), Storage: map[entity.Word]entity.Word{}},
+	}}
+	storage := services.NewExecutionStorage(forkCfg, reader, time.Hour, services.WithResetState(setup))
+	smelter := services.NewSmelterRpc(storage)
+
+	_, err := smelter.ResetFork(ctx, nil)
+	require.NoError(t, err)
+
+	dump, err := smelter.DumpState(ctx)
+	require.NoError(t, err)
+	require.Contains(t, dump.Accounts, whale, "reset state not loaded")
+	require.Equal(t, int64(6969), dump.Accounts[whale].Balance.ToInt().Int64())
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/go-errors/errors"
 	"github.com/go-resty/resty/v2"
 	"github.com/raul0ligma/smelter/app"
+	"github.com/raul0ligma/smelter/entity"
 	"github.com/raul0ligma/smelter/types"
 	"github.com/stretchr/testify/require"
 )
@@ -44,7 +45,12 @@ func start(ctx context.Context, chainID *big.Int, block uint64) error {
 	started := make(chan struct{}, 1)
 	errChan := make(chan error, 1)
 	go func(startChan chan<- struct{}, errChan chan<- error) {
-		if err = app.Run(ctx, rpcURL, block, chainID, time.Minute*5, time.Minute*10, started); err != nil {
+		if err = app.Run(ctx, []entity.Chain{{
+			Name:      "default",
+			RPCURL:    rpcURL,
+			ChainID:   chainID.Uint64(),
+			ForkBlock: block,
+		}}, time.Minute*5, time.Minute*10, nil, started); err != nil {
 			errChan <- err
 			return
 		}
@@ -0,0 +1,299 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/labstack/echo/v4"
+	"github.com/raul0ligma/smelter/controller"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/pkg/server"
+	"github.com/raul0ligma/smelter/services"
+	"github.com/raul0ligma/smelter/types"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestSessionEviction(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reader := mockProvider{}
+	forkCfg := testForkConfig()
+	storage := services.NewExecutionStorage(forkCfg, &reader, 300*time.Millisecond, services.WithMaxSessions(2))
+	go storage.Watcher(ctx, 50*time.Millisecond)
+
+	session := func(key string) *services.ExecutionCtx {
+		execCtx, err := storage.GetOrCreate(context.WithValue(ctx, server.Key{}, key))
+		require.NoError(t, err)
+		return execCtx
+	}
+
+	// the least recently used session makes room for a new one
+	alice := session("alice")
+	session("bob")
+	session("alice")
+	session("carol")
+
+	_, err := storage.Get("bob")
+	require.Error(t, err)
+	_, err = storage.Get("alice")
+	require.NoError(t, err)
+
+	metrics := storage.Metrics()
+	require.Equal(t, 2, metrics.Sessions)
+	require.Equal(t, uint64(3), metrics.Created)
+	require.Equal(t, uint64(1), metrics.Evicted)
+
+	// the size grows with the accounts the session loads
+	before := alice.Size()
+	whale := common.HexToAddress("0x0000000000000000000000000000000000000006")
+	require.NoError(t, alice.Db.SetBalance(ctx, whale, abi.MaxUint256))
+	after := alice.Size()
+	require.Greater(t, after.Accounts, before.Accounts)
+	require.Greater(t, after.Bytes, before.Bytes)
+
+	// every access extends the ttl of a session
+	for range 8 {
+		time.Sleep(100 * time.Millisecond)
+		session("alice")
+	}
+	_, err = storage.Get("alice")
+	require.NoError(t, err)
+	_, err = storage.Get("carol")
+	require.Error(t, err)
+
+	require.Eventually(t, func() bool {
+		return storage.Metrics().Sessions == 0
+	}, 2*time.Second, 50*time.Millisecond)
+	require.Equal(t, uint64(2), storage.Metrics().Expired)
+}
+
+func TestSessionManagement(t *testing.T) {
+	ctx := context.Background()
+	reader := mockProvider{}
+	forkCfg := testForkConfig()
+	storage := services.NewExecutionStorage(forkCfg, &reader, time.Hour)
+	eth := services.NewRpcService(storage, forkCfg, &reader)
+	smelter := services.NewSmelterRpc(storage)
+
+	router := echo.New()
+	controller.SetupRouter(router, []controller.Chain{{
+		Chain:     entity.Chain{Name: "default"},
+		RPCServer: jsonrpc.NewServer(),
+		Sessions:  storage,
+	}}, zap.NewNop())
+	request := func(method, path string, out any) int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		if out != nil && rec.Code < http.StatusBadRequest {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), out))
+		}
+		return rec.Code
+	}
+
+	// warm up a session with a persisted transaction
+	setupCtx := context.WithValue(ctx, server.Key{}, "setup")
+	whale := common.HexToAddress("0x0000000000000000000000000000000000000006")
+	require.NoError(t, smelter.ImpersonateAccount(setupCtx, whale))
+	require.NoError(t, smelter.SetStateOverrides(setupCtx, entity.StateOverrides{whale: {Balance: abi.MaxUint256}}))
+	deposit := hexutil.Bytes(hexutil.MustDecode("0xd0e30db0"))
+	_, err := eth.SendTransaction(setupCtx, entity.TransactionArgs{
+		From:  &whale,
+		To:    &types.Address0x69,
+		Value: (*hexutil.Big)(big.NewInt(1000)),
+		Data:  &deposit,
+	})
+	require.NoError(t, err)
+
+	var sessions []entity.SessionInfo
+	require.Equal(t, http.StatusOK, request(http.MethodGet, "/v1/sessions", &sessions))
+	require.Len(t, sessions, 1)
+	require.Equal(t, "setup", sessions[0].Key)
+	require.Equal(t, hexutil.Uint64(2), sessions[0].BlockNumber)
+	require.Equal(t, 1, sessions[0].Size.Transactions)
+
+	// the clone starts from the warmed up state and evolves on its own
+	var clone entity.SessionInfo
+	require.Equal(t, http.StatusCreated, request(http.MethodPost, "/v1/sessions/setup/clone/worker", &clone))
+	require.Equal(t, "worker", clone.Key)
+	require.Equal(t, sessions[0].BlockNumber, clone.BlockNumber)
+	require.Equal(t, http.StatusConflict, request(http.MethodPost, "/v1/sessions/setup/clone/worker", nil))
+	require.Equal(t, http.StatusNotFound, request(http.MethodPost, "/v1/sessions/missing/clone/other", nil))
+
+	workerCtx := context.WithValue(ctx, server.Key{}, "worker")
+	require.True(t, mustSession(t, workerCtx, storage).IsImpersonated(whale))
+	_, err = eth.SendTransaction(workerCtx, entity.TransactionArgs{
+		From:  &whale,
+		To:    &types.Address0x69,
+		Value: (*hexutil.Big)(big.NewInt(1000)),
+		Data:  &deposit,
+	})
+	require.NoError(t, err)
+
+	var setup, worker entity.SessionInfo
+	require.Equal(t, http.StatusOK, request(http.MethodGet, "/v1/sessions/setup", &setup))
+	require.Equal(t, http.StatusOK, request(http.MethodGet, "/v1/sessions/worker", &worker))
+	require.Equal(t, hexutil.Uint64(2), setup.BlockNumber)
+	require.Equal(t, hexutil.Uint64(3), worker.BlockNumber)
+
+	// the overrides are replaced under the session lock while the session is cloned
+	var (
+		wg          sync.WaitGroup
+		overrideErr error
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10 && overrideErr == nil; i++ {
+			overrideErr = smelter.SetStateOverrides(setupCtx, entity.StateOverrides{whale: {Balance: abi.MaxUint256}})
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, storage.Clone("setup", fmt.Sprintf("copy-%d", i)))
+	}
+	wg.Wait()
+	require.NoError(t, overrideErr)
+
+	// reset goes back to the fork block, delete drops the session
+	var reset entity.SessionInfo
+	require.Equal(t, http.StatusOK, request(http.MethodPost, "/v1/sessions/worker/reset", &reset))
+	require.Equal(t, hexutil.Uint64(1), reset.BlockNumber)
+	require.False(t, mustSession(t, workerCtx, storage).IsImpersonated(whale))
+
+	require.Equal(t, http.StatusNoContent, request(http.MethodDelete, "/v1/sessions/worker", nil))
+	require.Equal(t, http.StatusNotFound, request(http.MethodGet, "/v1/sessions/worker", nil))
+	require.Equal(t, http.StatusNotFound, request(http.MethodDelete, "/v1/sessions/worker", nil))
+}
+
+func mustSession(t *testing.T, ctx context.Context, storage *services.ExecutionCtxStorage) *services.ExecutionCtx {
+	execCtx, err := storage.GetOrCreate(ctx)
+	require.NoError(t, err)
+	return execCtx
+}
+
+func TestSessionForkBlock(t *testing.T) {
+	ctx := context.Background()
+	reader := mockProvider{}
+	forkCfg := testForkConfig()
+	storage := services.NewExecutionStorage(forkCfg, &reader, time.Hour)
+	eth := services.NewRpcService(storage, forkCfg, &reader)
+	smelter := services.NewSmelterRpc(storage)
+
+	params, err := json.Marshal([]any{"historic", "0x64"})
+	require.NoError(t, err)
+	info, err := smelter.CreateSession(ctx, params)
+	require.NoError(t, err)
+	require.Equal(t, hexutil.Uint64(100), info.ForkBlock)
+	require.Equal(t, hexutil.Uint64(100), info.BlockNumber)
+
+	_, err = smelter.CreateSession(ctx, params)
+	require.ErrorIs(t, err, services.ErrSessionExists)
+
+	// the block tags of every session resolve against its own fork block
+	historicCtx := context.WithValue(ctx, server.Key{}, "historic")
+	number, err := eth.BlockNumber(historicCtx)
+	require.NoError(t, err)
+	require.Equal(t, "0x64", number)
+
+	number, err = eth.BlockNumber(context.WithValue(ctx, server.Key{}, "default"))
+	require.NoError(t, err)
+	require.Equal(t, "0x01", number)
+
+	whale := common.HexToAddress("0x0000000000000000000000000000000000000006")
+	require.NoError(t, smelter.ImpersonateAccount(historicCtx, whale))
+	require.NoError(t, smelter.SetStateOverrides(historicCtx, entity.StateOverrides{whale: {Balance: abi.MaxUint256}}))
+	deposit := hexutil.Bytes(hexutil.MustDecode("0xd0e30db0"))
+	_, err = eth.SendTransaction(historicCtx, entity.TransactionArgs{
+		From:  &whale,
+		To:    &types.Address0x69,
+		Value: (*hexutil.Big)(big.NewInt(1000)),
+		Data:  &deposit,
+	})
+	require.NoError(t, err)
+
+	number, err = eth.BlockNumber(historicCtx)
+	require.NoError(t, err)
+	require.Equal(t, "0x65", number)
+
+	// a reset keeps the fork block of the session
+	require.NoError(t, storage.Reset(ctx, "historic"))
+	number, err = eth.BlockNumber(historicCtx)
+	require.NoError(t, err)
+	require.Equal(t, "0x64", number)
+}
+
+func TestChainRoutes(t *testing.T) {
+	reader := mockProvider{}
+	chains := make([]controller.Chain, 0, 2)
+	for _, chain := range []entity.Chain{
+		{Name: "mainnet", ChainID: 1, ForkBlock: 1},
+		{Name: "base", ChainID: 8453, ForkBlock: 1},
+	} {
+		forkCfg := entity.ForkConfig{ChainID: chain.ChainID, ForkBlock: new(big.Int).SetUint64(chain.ForkBlock)}
+		storage := services.NewExecutionStorage(forkCfg, &reader, time.Hour)
+		rpcServer := jsonrpc.NewServer(jsonrpc.WithServerMethodNameFormatter(func(namespace, method string) string {
+			return namespace + "_" + strings.ToLower(method[:1]) + method[1:]
+		}))
+		rpcServer.Register("eth", services.NewRpcService(storage, forkCfg, &reader))
+		chains = append(chains, controller.Chain{Chain: chain, RPCServer: rpcServer, Sessions: storage})
+	}
+
+	router := echo.New()
+	controller.SetupRouter(router, chains, zap.NewNop())
+	request := func(method, path string, body string, out any) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(rec, req)
+		if out != nil && rec.Code < http.StatusBadRequest {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), out))
+		}
+		return rec.Code
+	}
+
+	var listed []entity.Chain
+	require.Equal(t, http.StatusOK, request(http.MethodGet, "/v1/chains", "", &listed))
+	require.Equal(t, []entity.Chain{
+		{Name: "mainnet", ChainID: 1, ForkBlock: 1},
+		{Name: "base", ChainID: 8453, ForkBlock: 1},
+	}, listed)
+
+	chainID := func(path string) string {
+		var resp struct {
+			Result string `json:"result"`
+		}
+		body := `{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`
+		require.Equal(t, http.StatusOK, request(http.MethodPost, path, body, &resp))
+		return resp.Result
+	}
+	require.Equal(t, "0x2105", chainID("/v1/base/rpc/alice"))
+	require.Equal(t, "0x01", chainID("/v1/mainnet/rpc/alice"))
+	// the routes without a chain serve the first chain
+	require.Equal(t, "0x01", chainID("/v1/rpc/alice"))
+
+	// every chain has its own sessions
+	body := `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`
+	require.Equal(t, http.StatusOK, request(http.MethodPost, "/v1/base/rpc/bob", body, nil))
+	var sessions []entity.SessionInfo
+	require.Equal(t, http.StatusOK, request(http.MethodGet, "/v1/base/sessions", "", &sessions))
+	require.Len(t, sessions, 1)
+	require.Equal(t, "bob", sessions[0].Key)
+	require.Equal(t, http.StatusOK, request(http.MethodGet, "/v1/sessions", "", &sessions))
+	require.Empty(t, sessions)
+
+	require.Equal(t, http.StatusNotFound, request(http.MethodPost, "/v1/optimism/rpc/alice", body, nil))
+	require.Equal(t, http.StatusNotFound, request(http.MethodGet, "/v1/optimism/sessions", "", nil))
+}
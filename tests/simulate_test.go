@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	types2 "github.com/ethereum/go-ethereum/core/types"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/fork"
+	"github.com/raul0ligma/smelter/services"
+	"github.com/raul0ligma/smelter/tracer"
+	"github.com/raul0ligma/smelter/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulate(t *testing.T) {
+	ctx := context.Background()
+	reader := mockProvider{}
+	forkCfg := testForkConfig()
+	exec := newTestExecutor(t)
+	db := exec.DB()
+
+	target := types.Address0x69
+	sender := common.HexToAddress("0x0000000000000000000000000000000000000006")
+	parent := &types2.Header{Number: big.NewInt(1), Time: 1000, GasLimit: 30_000_000, BaseFee: new(big.Int)}
+
+	deposit := hexutil.Bytes(hexutil.MustDecode("0xd0e30db0"))
+	balanceOf := hexutil.Bytes(hexutil.MustDecode("0x70a082310000000000000000000000000000000000000000000000000000000000000006"))
+	// withdraw(1 ether), more than the sender deposited
+	withdraw := hexutil.Bytes(hexutil.MustDecode("0x2e1a7d4d0000000000000000000000000000000000000000000000000de0b6b3a7640000"))
+	value := hexutil.Big(*big.NewInt(1000))
+	number, timestamp := hexutil.Big(*big.NewInt(4)), hexutil.Uint64(2000)
+
+	accounts, state := db.Copy()
+	blocks, err := exec.Simulate(ctx, fork.NewDB(&reader, forkCfg, accounts, state), parent, entity.SimulateOpts{
+		TraceTransfers: true,
+		BlockStateCalls: []entity.SimBlock{
+			{
+				StateOverrides: entity.StateOverrides{sender: {Balance: abi.MaxUint256}},
+				Calls:          []entity.TransactionArgs{{From: &sender, To: &target, Value: &value, Input: &deposit}},
+			},
+			{
+				BlockOverrides: &entity.BlockOverrides{Number: &number, Time: &timestamp},
+				Calls: []entity.TransactionArgs{
+					{From: &sender, To: &target, Input: &balanceOf},
+					{From: &sender, To: &target, Input: &withdraw},
+				},
+			},
+		},
+	})
+	require.NoError(t, err, "failed to simulate")
+	require.Len(t, blocks, 3, "gap between blocks 2 and 4 not filled")
+
+	first := blocks[0]
+	require.Equal(t, "0x02", first.Number, "invalid first block number")
+	require.Equal(t, "0x03f4", first.Timestamp, "default timestamp not incremented")
+	require.Len(t, first.Calls, 1)
+	require.Equal(t, hexutil.Uint64(1), first.Calls[0].Status, "deposit failed")
+	require.Len(t, first.Calls[0].Logs, 2, "missing transfer or deposit log")
+	transfer := first.Calls[0].Logs[0]
+	require.Equal(t, common.HexToAddress("0xEeeeeEeeeEeEeeEeEeEeeEEEeeeeEeeeeeeeEEeE"), transfer.Address, "invalid transfer log address")
+	require.Equal(t, common.BytesToHash(sender.Bytes()), transfer.Topics[1], "invalid transfer sender")
+	require.Equal(t, int64(1000), new(big.Int).SetBytes(transfer.Data).Int64(), "invalid transfer value")
+	require.Equal(t, common.HexToHash(first.Hash), transfer.BlockHash, "log block hash not repaired")
+	require.Equal(t, first.Transactions[0], transfer.TxHash, "invalid log tx hash")
+
+	require.Empty(t, blocks[1].Calls, "filler block has calls")
+	require.Equal(t, "0x0400", blocks[1].Timestamp, "invalid filler timestamp")
+	require.Equal(t, first.Hash, blocks[1].ParentHash, "blocks are not chained")
+
+	last := blocks[2]
+	require.Equal(t, "0x04", last.Number, "block number not overridden")
+	require.Equal(t, "0x07d0", last.Timestamp, "timestamp not overridden")
+	require.Equal(t, int64(1000), new(big.Int).SetBytes(last.Calls[0].ReturnData).Int64(), "state not chained between blocks")
+	require.Equal(t, hexutil.Uint64(0), last.Calls[1].Status, "withdraw did not revert")
+	require.NotNil(t, last.Calls[1].Error, "missing revert error")
+	require.Equal(t, -32000, last.Calls[1].Error.Code, "invalid revert error code")
+	require.Empty(t, last.Calls[1].Logs, "reverted call kept its logs")
+
+	// nothing leaks into the session state
+	ret, _, err := exec.Call(ctx, ethereum.CallMsg{
+		From:  sender,
+		To:    &target,
+		Data:  balanceOf,
+		Gas:   30000000,
+		Value: new(big.Int),
+	}, tracer.NewTracer(false), nil)
+	require.NoError(t, err, "failed to read session balance")
+	require.Equal(t, int64(0), new(big.Int).SetBytes(ret).Int64(), "simulation leaked into the session")
+
+	nonce := hexutil.Uint64(5)
+	_, err = exec.Simulate(ctx, fork.NewDB(&reader, forkCfg, accounts, state), parent, entity.SimulateOpts{
+		Validation: true,
+		BlockStateCalls: []entity.SimBlock{
+			{Calls: []entity.TransactionArgs{{From: &sender, To: &target, Nonce: &nonce, Input: &balanceOf}}},
+		},
+	})
+	require.ErrorContains(t, err, "nonce too high", "nonce not validated")
+}
+
+func TestSimulateZeroedSlot(t *testing.T) {
+	ctx := context.Background()
+	reader := &heldSlotProvider{}
+	forkCfg := testForkConfig()
+	storage := services.NewExecutionStorage(forkCfg, reader, time.Hour)
+	eth := services.NewRpcService(storage, forkCfg, reader)
+	smelter := services.NewSmelterRpc(storage)
+
+	sender := common.HexToAddress("0x0000000000000000000000000000000000000006")
+	require.NoError(t, smelter.ImpersonateAccount(ctx, sender))
+	target := types.Address0x69
+	balanceOf := hexutil.Bytes(hexutil.MustDecode("0x70a082310000000000000000000000000000000000000000000000000000000000000006"))
+
+	// the transfer of the whole forked balance zeroes the balance slot of the sender
+	transfer := hexutil.Bytes(hexutil.MustDecode("0xa9059cbb0000000000000000000000000000000000000000000000000000000000000007000000000000000000000000000000000000000000000000000000000000002a"))
+	_, err := eth.SendTransaction(ctx, entity.TransactionArgs{From: &sender, To: &target, Data: &transfer})
+	require.NoError(t, err)
+	transferBlock, err := eth.BlockNumber(ctx)
+	require.NoError(t, err)
+
+	simulate := func(block string) {
+		blocks, err := eth.SimulateV1(ctx, mustParams(t, entity.SimulateOpts{BlockStateCalls: []entity.SimBlock{{
+			Calls: []entity.TransactionArgs{{From: &sender, To: &target, Data: &balanceOf}},
+		}}}, block))
+		require.NoError(t, err)
+		require.Len(t, blocks, 1)
+		require.Len(t, blocks[0].Calls, 1)
+		require.Zero(t, new(big.Int).SetBytes(blocks[0].Calls[0].ReturnData).Sign(), "simulation read the forked value of a zeroed slot")
+	}
+	simulate("latest")
+
+	// once another block is mined the transfer block is read from the block storage
+	deposit := hexutil.Bytes(hexutil.MustDecode("0xd0e30db0"))
+	_, err = eth.SendTransaction(ctx, entity.TransactionArgs{From: &sender, To: &target, Data: &deposit})
+	require.NoError(t, err)
+	simulate(transferBlock)
+
+	ret, err := eth.Call(ctx, mustParams(t, entity.TransactionArgs{From: &sender, To: &target, Data: &balanceOf}, transferBlock))
+	require.NoError(t, err)
+	require.Zero(t, new(big.Int).SetBytes(hexutil.MustDecode(ret)).Sign(), "historical call read the forked value of a zeroed slot")
+}
@@ -0,0 +1,216 @@
+package tests
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/pkg/server"
+	"github.com/raul0ligma/smelter/services"
+	"github.com/raul0ligma/smelter/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpAndLoadState(t *testing.T) {
+	ctx := context.Background()
+	reader := mockProvider{}
+	forkCfg := testForkConfig()
+	stateDir := t.TempDir()
+	storage := services.NewExecutionStorage(forkCfg, &reader, time.Hour, services.WithStateDir(stateDir))
+	eth := services.NewRpcService(storage, forkCfg, &reader)
+	smelter := services.NewSmelterRpc(storage)
+	otterscan := services.NewOtterscanRpc(eth, storage, forkCfg, &reader)
+
+	srcCtx := context.WithValue(ctx, server.Key{}, "src")
+	whale := common.HexToAddress("0x0000000000000000000000000000000000000006")
+	slot := common.HexToHash("0x1337")
+	value := common.HexToHash("0xbeef")
+	require.NoError(t, smelter.ImpersonateAccount(srcCtx, whale))
+	require.NoError(t, smelter.SetStateOverrides(srcCtx, entity.StateOverrides{whale: {Balance: abi.MaxUint256}}))
+	require.NoError(t, smelter.SetStorageAt(srcCtx, types.Address0x69, slot, value))
+	deposit := hexutil.Bytes(hexutil.MustDecode("0xd0e30db0"))
+	txHash, err := eth.SendTransaction(srcCtx, entity.TransactionArgs{
+		From:  &whale,
+		To:    &types.Address0x69,
+		Value: (*hexutil.Big)(big.NewInt(1000)),
+		Data:  &deposit,
+	})
+	require.NoError(t, err)
+
+	dump, err := smelter.DumpState(srcCtx)
+	require.NoError(t, err)
+	require.Equal(t, hexutil.Uint64(2), dump.BestBlockNumber)
+	require.Len(t, dump.Smelter.Blocks, 1)
+	require.Len(t, dump.Smelter.Transactions, 1)
+	// accounts only touched through storage still carry the forked balance and code
+	for _, account := range dump.Accounts {
+		require.NotNil(t, account.Balance)
+		require.NotNil(t, account.Code)
+	}
+	require.NotEmpty(t, *dump.Accounts[types.Address0x69].Code)
+	encoded, err := json.Marshal(dump)
+	require.NoError(t, err)
+
+	// the dump restores the state, the chain and the indexes into another session
+	dstCtx := context.WithValue(ctx, server.Key{}, "dst")
+	loaded, err := smelter.LoadState(dstCtx, encoded)
+	require.NoError(t, err)
+	require.True(t, loaded)
+
+	assertRestored := func(sessionCtx context.Context) {
+		number, err := eth.BlockNumber(sessionCtx)
+		require.NoError(t, err)
+		require.Equal(t, "0x02", number)
+
+		receipt, err := eth.GetTransactionReceipt(sessionCtx, common.HexToHash(txHash))
+		require.NoError(t, err)
+		require.NotNil(t, receipt)
+		require.Equal(t, uint64(2), receipt.BlockNumber.Uint64())
+		require.Len(t, receipt.Logs, 1)
+
+		history, err := smelter.GetAccountHistory(sessionCtx, mustParams(t, whale))
+		require.NoError(t, err)
+		require.Len(t, history.Transactions, 1)
+
+		// the whale had nonce 0 before its only transaction
+		bySender, err := otterscan.GetTransactionBySenderAndNonce(sessionCtx, whale, 0)
+		require.NoError(t, err)
+		require.NotNil(t, bySender)
+		require.Equal(t, common.HexToHash(txHash), *bySender)
+
+		execCtx, err := storage.GetOrCreate(sessionCtx)
+		require.NoError(t, err)
+		stored, err := execCtx.Db.GetState(sessionCtx, types.Address0x69, slot)
+		require.NoError(t, err)
+		require.Equal(t, value, stored)
+		nonce, err := execCtx.Db.GetNonce(sessionCtx, whale)
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), nonce)
+	}
+	assertRestored(dstCtx)
+
+	// sessions are persisted on shutdown and restored on start
+	require.NoError(t, storage.Persist(ctx))
+	restarted := services.NewExecutionStorage(forkCfg, &reader, time.Hour, services.WithStateDir(stateDir))
+	require.NoError(t, restarted.Restore(ctx))
+	require.Len(t, restarted.List(), 2)
+	storage = restarted
+	eth = services.NewRpcService(restarted, forkCfg, &reader)
+	smelter = services.NewSmelterRpc(restarted)
+	assertRestored(srcCtx)
+}
+
+func TestLoadAnvilState(t *testing.T) {
+	ctx := context.WithValue(context.Background(), server.Key{}, "anvil")
+	reader := mockProvider{}
+	forkCfg := testForkConfig()
+	storage := services.NewExecutionStorage(forkCfg, &reader, time.Hour)
+	smelter := services.NewSmelterRpc(storage)
+
+	// anvil_dumpState returns the gzipped json as a hex string
+	account := common.HexToAddress("0x0000000000000000000000000000000000000042")
+	state := `{"block":{"number":"0x1"},"accounts":{"` + account.Hex() + `":{"nonce":7,"balance":"0x10","code":"0x6001","storage":{"0x1":"0x2"}}},"best_block_number":"0x1"}`
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err := writer.Write([]byte(state))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	encoded, err := json.Marshal(hexutil.Bytes(compressed.Bytes()))
+	require.NoError(t, err)
+
+	loaded, err := smelter.LoadState(ctx, encoded)
+	require.NoError(t, err)
+	require.True(t, loaded)
+
+	execCtx, err := storage.GetOrCreate(ctx)
+	require.NoError(t, err)
+	balance, err := execCtx.Db.GetBalance(ctx, account)
+	require.NoError(t, err)
+	require.Equal(t, int64(16), balance.Int64())
+	nonce, err := execCtx.Db.GetNonce(ctx, account)
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), nonce)
+	code, err := execCtx.Db.GetCode(ctx, account)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x60, 0x01}, code)
+	slot, err := execCtx.Db.GetState(ctx, account, common.HexToHash("0x1"))
+	require.NoError(t, err)
+	require.Equal(t, common.HexToHash("0x2"), slot)
+}
+
+func mustParams(t *testing.T, params ...any) []byte {
+	encoded, err := json.Marshal(params)
+	require.NoError(t, err)
+	return encoded
+}
+
+func TestPersistDroppedSessions(t *testing.T) {
+	ctx := context.Background()
+	reader := mockProvider{}
+	forkCfg := testForkConfig()
+	stateDir := t.TempDir()
+	storage := services.NewExecutionStorage(
+		forkCfg, &reader, time.Hour,
+		services.WithStateDir(stateDir),
+		services.WithChainName("mainnet"),
+		services.WithMaxSessions(2),
+	)
+
+	// chains are persisted under their name
+	dir := filepath.Join(stateDir, "mainnet")
+	sessionFile := func(key string) string {
+		return filepath.Join(dir, key+".json")
+	}
+	for _, key := range []string{"a", "b"} {
+		_, err := storage.CreateSession(ctx, key, nil, false)
+		require.NoError(t, err)
+	}
+	require.NoError(t, storage.Persist(ctx))
+	require.FileExists(t, sessionFile("a"))
+	require.FileExists(t, sessionFile("b"))
+
+	// deleting a session removes its file
+	require.NoError(t, storage.Delete("a"))
+	require.NoFileExists(t, sessionFile("a"))
+
+	// evicting a session removes its file
+	_, err := storage.CreateSession(ctx, "c", nil, false)
+	require.NoError(t, err)
+	_, err = storage.CreateSession(ctx, "d", nil, false)
+	require.NoError(t, err)
+	require.NoFileExists(t, sessionFile("b"))
+
+	// persisting removes the files of the sessions that are gone
+	require.NoError(t, os.WriteFile(sessionFile("stale"), []byte("{}"), 0o644))
+	require.NoError(t, storage.Persist(ctx))
+	require.NoFileExists(t, sessionFile("stale"))
+	require.FileExists(t, sessionFile("c"))
+	require.FileExists(t, sessionFile("d"))
+
+	// another chain of the same chain id keeps its own sessions
+	other := services.NewExecutionStorage(
+		forkCfg, &reader, time.Hour,
+		services.WithStateDir(stateDir),
+		services.WithChainName("mainnet-archive"),
+	)
+	require.NoError(t, other.Restore(ctx))
+	require.Empty(t, other.List())
+
+	restarted := services.NewExecutionStorage(
+		forkCfg, &reader, time.Hour,
+		services.WithStateDir(stateDir),
+		services.WithChainName("mainnet"),
+	)
+	require.NoError(t, restarted.Restore(ctx))
+	require.Len(t, restarted.List(), 2)
+}
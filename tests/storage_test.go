@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/executor"
+	"github.com/raul0ligma/smelter/pkg/server"
+	"github.com/raul0ligma/smelter/services"
+	"github.com/raul0ligma/smelter/tracer"
+	"github.com/raul0ligma/smelter/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageSlot(t *testing.T) {
+	ctx := context.Background()
+	exec := newTestExecutor(t)
+
+	target := types.Address0x69
+	holder := common.HexToAddress("0x0000000000000000000000000000000000000006")
+	call := func(data string) ethereum.CallMsg {
+		return ethereum.CallMsg{
+			To:    &target,
+			Data:  hexutil.MustDecode(data),
+			Gas:   30000000,
+			Value: new(big.Int),
+		}
+	}
+	balanceOf := call("0x70a082310000000000000000000000000000000000000000000000000000000000000006")
+
+	slot, err := exec.StorageSlot(ctx, balanceOf, nil)
+	require.NoError(t, err, "failed to find balance slot")
+	require.Equal(t, target, slot.Address, "invalid slot owner")
+	// balanceOf is the fourth slot of WETH9
+	require.Equal(t, crypto.Keccak256Hash(common.LeftPadBytes(holder.Bytes(), 32), common.LeftPadBytes([]byte{3}, 32)), slot.Slot, "invalid balance slot")
+	require.Equal(t, common.Hash{}, slot.Value, "invalid balance")
+
+	require.NoError(t, exec.SetStorageAt(ctx, slot.Address, slot.Slot, common.BigToHash(big.NewInt(1e18))), "failed to set balance")
+	ret, _, err := exec.Call(ctx, balanceOf, tracer.NewTracer(false), nil)
+	require.NoError(t, err, "failed to read balance")
+	require.Equal(t, int64(1e18), new(big.Int).SetBytes(ret).Int64(), "balance not written to the session")
+
+	// WETH9 returns its ether balance as total supply
+	_, err = exec.StorageSlot(ctx, call("0x18160ddd"), nil)
+	require.ErrorIs(t, err, executor.ErrSlotNotFound)
+}
+
+func TestFindStorageSlots(t *testing.T) {
+	ctx := context.Background()
+	exec := newTestExecutor(t)
+
+	target := types.Address0x69
+	owner := common.HexToAddress("0x0000000000000000000000000000000000000006")
+	spender := common.HexToAddress("0x0000000000000000000000000000000000000007")
+	call := func(data []byte) ethereum.CallMsg {
+		return ethereum.CallMsg{
+			To:    &target,
+			Data:  data,
+			Gas:   30000000,
+			Value: new(big.Int),
+		}
+	}
+
+	// allowance(0x6, 0x7), allowance is the fifth slot of WETH9
+	allowance := append(hexutil.MustDecode("0xdd62ed3e"), append(common.LeftPadBytes(owner.Bytes(), 32), common.LeftPadBytes(spender.Bytes(), 32)...)...)
+	candidates, err := exec.FindStorageSlots(ctx, call(allowance), nil)
+	require.NoError(t, err, "failed to find allowance slot")
+	require.Len(t, candidates, 1)
+	inner := crypto.Keccak256(common.LeftPadBytes(owner.Bytes(), 32), common.LeftPadBytes([]byte{4}, 32))
+	require.Equal(t, crypto.Keccak256Hash(common.LeftPadBytes(spender.Bytes(), 32), inner), candidates[0].Slot, "invalid allowance slot")
+	require.True(t, candidates[0].Matches, "allowance slot not matched")
+	require.NotNil(t, candidates[0].Probe, "missing probe value")
+
+	// decimals() is packed into the third slot, the probe changes but is not returned as is
+	candidates, err = exec.FindStorageSlots(ctx, call(hexutil.MustDecode("0x313ce567")), nil)
+	require.NoError(t, err, "failed to find decimals slot")
+	require.Len(t, candidates, 1)
+	require.Equal(t, common.BigToHash(big.NewInt(2)), candidates[0].Slot, "invalid decimals slot")
+	require.False(t, candidates[0].Matches, "packed slot matched")
+	require.NotNil(t, candidates[0].Probe, "probe did not change the return")
+}
+
+func TestSetErc20Balance(t *testing.T) {
+	ctx := context.WithValue(context.Background(), server.Key{}, "erc20")
+	reader := mockProvider{}
+	storage := services.NewExecutionStorage(testForkConfig(), &reader, time.Hour)
+	smelter := services.NewSmelterRpc(storage)
+
+	holder := common.HexToAddress("0x0000000000000000000000000000000000000006")
+	amount := big.NewInt(1e18)
+	slot, err := smelter.SetErc20Balance(ctx, mustParams(t, types.Address0x69, holder, (*hexutil.Big)(amount)))
+	require.NoError(t, err)
+	require.Equal(t, crypto.Keccak256Hash(common.LeftPadBytes(holder.Bytes(), 32), common.LeftPadBytes([]byte{3}, 32)), slot.Slot)
+	require.Equal(t, common.Hash{}, slot.Value, "previous balance")
+
+	// balanceOf returns its slot plus one, the slot is written but doesn't hold the amount
+	token := common.HexToAddress("0x0000000000000000000000000000000000000777")
+	offset := hexutil.MustDecode("0x60005460010160005260206000f3")
+	require.NoError(t, smelter.SetStateOverrides(ctx, entity.StateOverrides{token: {Code: offset}}))
+	_, err = smelter.SetErc20Balance(ctx, mustParams(t, token, holder, (*hexutil.Big)(amount)))
+	require.ErrorContains(t, err, "doesn't return its balance slot as is")
+
+	// the slot is restored
+	value, err := mustSession(t, ctx, storage).Db.GetState(ctx, token, common.Hash{})
+	require.NoError(t, err)
+	require.Equal(t, common.Hash{}, value)
+}
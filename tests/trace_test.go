@@ -0,0 +1,289 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/raul0ligma/smelter/entity"
+	"github.com/raul0ligma/smelter/fork"
+	"github.com/raul0ligma/smelter/services"
+	"github.com/raul0ligma/smelter/tracer"
+	"github.com/raul0ligma/smelter/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGethTracers(t *testing.T) {
+	ctx := context.Background()
+	target := types.Address0x69
+	exec := newTestExecutor(t)
+
+	sender := common.HexToAddress("0x0000000000000000000000000000000000000006")
+	deposit, _ := hexutil.Decode("0xd0e30db0")
+	hash, _, _, err := exec.CallAndPersist(
+		ctx, ethereum.CallMsg{
+			From:  sender,
+			To:    &target,
+			Data:  deposit,
+			Gas:   30000000,
+			Value: new(big.Int).SetInt64(6969),
+		}, tracer.NewTracer(false), map[common.Address]entity.StateOverride{
+			sender: {Balance: abi.MaxUint256},
+		},
+	)
+	require.NoError(t, err, "failed to deposit")
+
+	from, ok := exec.TxnStorage().GetSender(*hash)
+	require.True(t, ok, "missing sender")
+	require.Equal(t, sender, from, "invalid sender")
+
+	callTracer := "callTracer"
+	callTrace, err := tracer.NewGethTracer(&entity.TraceConfig{Tracer: &callTracer}, &tracers.Context{}, exec.ChainConfig())
+	require.NoError(t, err, "failed to create call tracer")
+
+	balanceOfx06, _ := hexutil.Decode("0x70a082310000000000000000000000000000000000000000000000000000000000000006")
+	_, _, err = exec.Trace(ctx, ethereum.CallMsg{
+		From:  sender,
+		To:    &target,
+		Data:  balanceOfx06,
+		Gas:   30000000,
+		Value: new(big.Int),
+	}, callTrace, nil, nil, nil)
+	require.NoError(t, err, "failed to trace balanceOf")
+
+	res, err := callTrace.GetResult()
+	require.NoError(t, err, "failed to get call trace")
+
+	var frame struct {
+		Type   string         `json:"type"`
+		From   common.Address `json:"from"`
+		To     common.Address `json:"to"`
+		Output string         `json:"output"`
+	}
+	require.NoError(t, json.Unmarshal(res, &frame), "invalid call trace")
+	require.Equal(t, "CALL", frame.Type, "invalid call type")
+	require.Equal(t, sender, frame.From, "invalid call from")
+	require.Equal(t, target, frame.To, "invalid call to")
+	require.Equal(t, int64(6969), new(big.Int).SetBytes(hexutil.MustDecode(frame.Output)).Int64(), "invalid call output")
+
+	prestateTracer := "prestateTracer"
+	prestateTrace, err := tracer.NewGethTracer(&entity.TraceConfig{
+		Tracer:       &prestateTracer,
+		TracerConfig: json.RawMessage(`{"diffMode":true}`),
+	}, &tracers.Context{}, exec.ChainConfig())
+	require.NoError(t, err, "failed to create prestate tracer")
+
+	transferCall, _ := hexutil.Decode("0xa9059cbb00000000000000000000000000000000000000000000000000000000000000070000000000000000000000000000000000000000000000000000000000001b37")
+	_, _, err = exec.Trace(ctx, ethereum.CallMsg{
+		From:  sender,
+		To:    &target,
+		Data:  transferCall,
+		Gas:   30000000,
+		Value: new(big.Int),
+	}, prestateTrace, nil, nil, nil)
+	require.NoError(t, err, "failed to trace transfer")
+
+	res, err = prestateTrace.GetResult()
+	require.NoError(t, err, "failed to get prestate diff")
+
+	var diff struct {
+		Pre  map[common.Address]json.RawMessage `json:"pre"`
+		Post map[common.Address]json.RawMessage `json:"post"`
+	}
+	require.NoError(t, json.Unmarshal(res, &diff), "invalid prestate diff")
+	require.Contains(t, diff.Post, target, "missing weth storage diff")
+
+	jsTracer := "{result: function() {}}"
+	_, err = tracer.NewGethTracer(&entity.TraceConfig{Tracer: &jsTracer}, &tracers.Context{}, exec.ChainConfig())
+	require.ErrorIs(t, err, tracer.ErrJSTracerUnsupported, "js tracers should be rejected")
+}
+
+func TestParityTracers(t *testing.T) {
+	ctx := context.Background()
+	target := types.Address0x69
+	exec := newTestExecutor(t)
+
+	sender := common.HexToAddress("0x0000000000000000000000000000000000000006")
+	deposit, _ := hexutil.Decode("0xd0e30db0")
+	msg := ethereum.CallMsg{
+		From:  sender,
+		To:    &target,
+		Data:  deposit,
+		Gas:   30000000,
+		Value: new(big.Int).SetInt64(6969),
+	}
+	overrides := map[common.Address]entity.StateOverride{
+		sender: {Balance: new(big.Int).SetInt64(10000)},
+	}
+
+	vmTracer := tracer.NewVmTracer()
+	_, _, err := exec.Trace(ctx, msg, vmTracer, nil, nil, overrides)
+	require.NoError(t, err, "failed to trace deposit")
+
+	vmTrace := vmTracer.Result()
+	require.NotNil(t, vmTrace, "missing vm trace")
+	require.NotEmpty(t, vmTrace.Ops, "missing vm trace ops")
+	require.NotEmpty(t, vmTrace.Code, "missing vm trace code")
+	for _, op := range vmTrace.Ops {
+		require.NotNil(t, op.Ex, "missing executed op %s at %d", op.Op, op.Pc)
+	}
+
+	prestateTracer := "prestateTracer"
+	prestateTrace, err := tracer.NewGethTracer(&entity.TraceConfig{
+		Tracer:       &prestateTracer,
+		TracerConfig: json.RawMessage(`{"diffMode":true}`),
+	}, &tracers.Context{}, exec.ChainConfig())
+	require.NoError(t, err, "failed to create prestate tracer")
+
+	_, _, err = exec.Trace(ctx, msg, prestateTrace, nil, nil, overrides)
+	require.NoError(t, err, "failed to trace deposit")
+
+	res, err := prestateTrace.GetResult()
+	require.NoError(t, err, "failed to get prestate diff")
+
+	diff, err := tracer.ParityStateDiff(res)
+	require.NoError(t, err, "failed to convert prestate diff")
+	require.Contains(t, diff, sender, "missing sender diff")
+
+	encoded, err := json.Marshal(diff[sender].Balance)
+	require.NoError(t, err, "failed to marshal balance diff")
+	require.JSONEq(t, `{"*":{"from":"0x2710","to":"0xbd7"}}`, string(encoded), "invalid balance diff")
+}
+
+// upstreamCallProvider is a mockProvider recording the methods relayed to the upstream.
+type upstreamCallProvider struct {
+	mockProvider
+	mu      sync.Mutex
+	methods []string
+}
+
+func (u *upstreamCallProvider) CallContext(ctx context.Context, result any, method string, args ...any) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.methods = append(u.methods, method)
+	return nil
+}
+
+func TestTraceReplayAndFilter(t *testing.T) {
+	ctx := context.Background()
+	reader := &upstreamCallProvider{}
+	forkCfg := testForkConfig()
+	storage := services.NewExecutionStorage(forkCfg, reader, time.Hour)
+	eth := services.NewRpcService(storage, forkCfg, reader)
+	smelter := services.NewSmelterRpc(storage)
+	trace := services.NewTraceRpc(storage, forkCfg, reader)
+
+	sender := common.HexToAddress("0x0000000000000000000000000000000000000006")
+	// the balance is loaded into the state as overrides aren't part of the blocks
+	funded, err := json.Marshal(entity.StateDump{Accounts: entity.AccountsDump{
+		sender: {Balance: (*hexutil.Big)(big.NewInt(params.Ether)), Code: &hexutil.Bytes{}, Storage: map[entity.Word]entity.Word{}},
+	}})
+	require.NoError(t, err)
+	_, err = smelter.LoadState(ctx, funded)
+	require.NoError(t, err)
+	require.NoError(t, smelter.ImpersonateAccount(ctx, sender))
+
+	target := types.Address0x69
+	deposit := hexutil.Bytes(hexutil.MustDecode("0xd0e30db0"))
+	// the second deposit is replayed on top of the local block holding the first
+	var hash string
+	for range 2 {
+		hash, err = eth.SendTransaction(ctx, entity.TransactionArgs{
+			From:  &sender,
+			To:    &target,
+			Value: (*hexutil.Big)(big.NewInt(6969)),
+			Data:  &deposit,
+		})
+		require.NoError(t, err, "failed to deposit")
+	}
+
+	res, err := trace.ReplayTransaction(ctx, common.HexToHash(hash), []entity.TraceType{
+		entity.TraceTypeTrace, entity.TraceTypeStateDiff, entity.TraceTypeVmTrace,
+	})
+	require.NoError(t, err, "failed to replay deposit")
+
+	var results struct {
+		Trace     json.RawMessage                    `json:"trace"`
+		StateDiff map[common.Address]json.RawMessage `json:"stateDiff"`
+		VmTrace   *entity.VmTrace                    `json:"vmTrace"`
+	}
+	require.NoError(t, json.Unmarshal(res, &results))
+	require.NotEmpty(t, results.Trace, "missing trace")
+	require.Contains(t, results.StateDiff, sender, "missing sender diff")
+	require.NotNil(t, results.VmTrace, "missing vm trace")
+	require.NotEmpty(t, results.VmTrace.Ops, "missing vm trace ops")
+
+	// without a lower bound only the local blocks are traced
+	traces, err := trace.Filter(ctx, entity.TraceFilter{})
+	require.NoError(t, err)
+	require.NotEmpty(t, traces, "missing local traces")
+	require.NotContains(t, reader.methods, "trace_filter", "upstream history traced")
+}
+
+// heldSlotProvider is a mockProvider where every account holds 42 in every slot.
+type heldSlotProvider struct {
+	mockProvider
+}
+
+func (h *heldSlotProvider) StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) ([]byte, error) {
+	return common.BigToHash(big.NewInt(42)).Bytes(), nil
+}
+
+func TestTraceZeroedSlot(t *testing.T) {
+	ctx := context.Background()
+	reader := &heldSlotProvider{}
+	forkCfg := testForkConfig()
+	storage := services.NewExecutionStorage(forkCfg, reader, time.Hour)
+	eth := services.NewRpcService(storage, forkCfg, reader)
+	smelter := services.NewSmelterRpc(storage)
+	debug := services.NewDebugRpc(storage, forkCfg, reader)
+
+	sender := common.HexToAddress("0x0000000000000000000000000000000000000006")
+	require.NoError(t, smelter.ImpersonateAccount(ctx, sender))
+	target := types.Address0x69
+	send := func(data string) string {
+		input := hexutil.Bytes(hexutil.MustDecode(data))
+		hash, err := eth.SendTransaction(ctx, entity.TransactionArgs{From: &sender, To: &target, Data: &input})
+		require.NoError(t, err)
+		return hash
+	}
+
+	// the transfer of the whole forked balance zeroes the balance slot of the sender
+	transfer := send("0xa9059cbb0000000000000000000000000000000000000000000000000000000000000007000000000000000000000000000000000000000000000000000000000000002a")
+	receipt, err := eth.GetTransactionReceipt(ctx, common.HexToHash(transfer))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), uint64(receipt.Status), "transfer failed")
+	read := send("0x70a082310000000000000000000000000000000000000000000000000000000000000006")
+
+	callTracer := "callTracer"
+	raw, err := json.Marshal([]any{common.HexToHash(read), entity.TraceConfig{Tracer: &callTracer}})
+	require.NoError(t, err)
+	res, err := debug.TraceTransaction(ctx, jsonrpc.RawParams(raw))
+	require.NoError(t, err, "failed to trace balance read")
+
+	var call struct {
+		Output hexutil.Bytes `json:"output"`
+	}
+	require.NoError(t, json.Unmarshal(res, &call))
+	require.Zero(t, new(big.Int).SetBytes(call.Output).Sign(), "replay read the forked value of a zeroed slot")
+
+	// the db of a past block reads the zeroed slot as it was stored
+	slot := crypto.Keccak256Hash(common.LeftPadBytes(sender.Bytes(), 32), common.LeftPadBytes([]byte{3}, 32))
+	accounts, state := entity.NewAccountsStorage(), entity.NewAccountsState()
+	accounts.NewAccountWithStorage(target, nil, map[common.Hash]common.Hash{slot: {}})
+	state.NewAccount(target, 0, new(big.Int))
+	value, err := fork.NewDB(reader, forkCfg, accounts, state).GetState(ctx, target, slot)
+	require.NoError(t, err)
+	require.Equal(t, common.Hash{}, value, "zeroed slot read from the fork")
+}
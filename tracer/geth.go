@@ -0,0 +1,75 @@
+package tracer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	_ "github.com/ethereum/go-ethereum/eth/tracers/native"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/raul0ligma/smelter/entity"
+)
+
+var ErrJSTracerUnsupported = errors.New("js tracers are not supported")
+
+// GethTracer wraps one of geth's built-in tracers (callTracer, prestateTracer,
+// 4byteTracer, ...) or the default struct logger so it can be passed to the executor.
+type GethTracer struct {
+	hooks     *tracing.Hooks
+	getResult func() (json.RawMessage, error)
+	stop      func(err error)
+}
+
+func NewGethTracer(
+	cfg *entity.TraceConfig,
+	txCtx *tracers.Context,
+	chainCfg *params.ChainConfig,
+) (*GethTracer, error) {
+	if cfg == nil || cfg.Tracer == nil || *cfg.Tracer == "" {
+		var logCfg *logger.Config
+		if cfg != nil {
+			logCfg = cfg.Config
+		}
+
+		structLogger := logger.NewStructLogger(logCfg)
+		return &GethTracer{
+			hooks:     structLogger.Hooks(),
+			getResult: structLogger.GetResult,
+			stop:      structLogger.Stop,
+		}, nil
+	}
+
+	if tracers.DefaultDirectory.IsJS(*cfg.Tracer) {
+		return nil, fmt.Errorf("%w: %s", ErrJSTracerUnsupported, *cfg.Tracer)
+	}
+
+	t, err := tracers.DefaultDirectory.New(*cfg.Tracer, txCtx, cfg.TracerConfig, chainCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GethTracer{
+		hooks:     t.Hooks,
+		getResult: t.GetResult,
+		stop:      t.Stop,
+	}, nil
+}
+
+func (g *GethTracer) Hooks() *tracing.Hooks {
+	return g.hooks
+}
+
+func (g *GethTracer) OtterTrace() entity.TransactionTraces {
+	return entity.TransactionTraces{}
+}
+
+func (g *GethTracer) GetResult() (json.RawMessage, error) {
+	return g.getResult()
+}
+
+func (g *GethTracer) Stop(err error) {
+	g.stop(err)
+}
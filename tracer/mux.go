@@ -0,0 +1,127 @@
+package tracer
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/raul0ligma/smelter/entity"
+)
+
+// MuxTracer fans the execution hooks out to several tracers so a single execution
+// can feed e.g. the otterscan traces and a log collector.
+type MuxTracer struct {
+	providers []entity.TraceProvider
+}
+
+func NewMuxTracer(providers ...entity.TraceProvider) *MuxTracer {
+	return &MuxTracer{providers: providers}
+}
+
+// OtterTrace returns the otterscan traces of the first tracer recording them.
+func (m *MuxTracer) OtterTrace() entity.TransactionTraces {
+	for _, provider := range m.providers {
+		if traces := provider.OtterTrace(); traces != nil {
+			return traces
+		}
+	}
+
+	return nil
+}
+
+func (m *MuxTracer) Hooks() *tracing.Hooks {
+	hooks := make([]*tracing.Hooks, 0, len(m.providers))
+	for _, provider := range m.providers {
+		if h := provider.Hooks(); h != nil {
+			hooks = append(hooks, h)
+		}
+	}
+
+	return &tracing.Hooks{
+		OnTxStart: func(vm *tracing.VMContext, tx *types.Transaction, from common.Address) {
+			for _, h := range hooks {
+				if h.OnTxStart != nil {
+					h.OnTxStart(vm, tx, from)
+				}
+			}
+		},
+		OnTxEnd: func(receipt *types.Receipt, err error) {
+			for _, h := range hooks {
+				if h.OnTxEnd != nil {
+					h.OnTxEnd(receipt, err)
+				}
+			}
+		},
+		OnEnter: func(depth int, op byte, from, to common.Address, input []byte, gas uint64, value *big.Int) {
+			for _, h := range hooks {
+				if h.OnEnter != nil {
+					h.OnEnter(depth, op, from, to, input, gas, value)
+				}
+			}
+		},
+		OnExit: func(depth int, output []byte, gasUsed uint64, err error, reverted bool) {
+			for _, h := range hooks {
+				if h.OnExit != nil {
+					h.OnExit(depth, output, gasUsed, err, reverted)
+				}
+			}
+		},
+		OnOpcode: func(pc uint64, op byte, gas, cost uint64, scope tracing.OpContext, rData []byte, depth int, err error) {
+			for _, h := range hooks {
+				if h.OnOpcode != nil {
+					h.OnOpcode(pc, op, gas, cost, scope, rData, depth, err)
+				}
+			}
+		},
+		OnFault: func(pc uint64, op byte, gas, cost uint64, scope tracing.OpContext, depth int, err error) {
+			for _, h := range hooks {
+				if h.OnFault != nil {
+					h.OnFault(pc, op, gas, cost, scope, depth, err)
+				}
+			}
+		},
+		OnGasChange: func(prev, next uint64, reason tracing.GasChangeReason) {
+			for _, h := range hooks {
+				if h.OnGasChange != nil {
+					h.OnGasChange(prev, next, reason)
+				}
+			}
+		},
+		OnBalanceChange: func(addr common.Address, prev, next *big.Int, reason tracing.BalanceChangeReason) {
+			for _, h := range hooks {
+				if h.OnBalanceChange != nil {
+					h.OnBalanceChange(addr, prev, next, reason)
+				}
+			}
+		},
+		OnNonceChange: func(addr common.Address, prev, next uint64) {
+			for _, h := range hooks {
+				if h.OnNonceChange != nil {
+					h.OnNonceChange(addr, prev, next)
+				}
+			}
+		},
+		OnCodeChange: func(addr common.Address, prevCodeHash common.Hash, prevCode []byte, codeHash common.Hash, code []byte) {
+			for _, h := range hooks {
+				if h.OnCodeChange != nil {
+					h.OnCodeChange(addr, prevCodeHash, prevCode, codeHash, code)
+				}
+			}
+		},
+		OnStorageChange: func(addr common.Address, slot common.Hash, prev, next common.Hash) {
+			for _, h := range hooks {
+				if h.OnStorageChange != nil {
+					h.OnStorageChange(addr, slot, prev, next)
+				}
+			}
+		},
+		OnLog: func(log *types.Log) {
+			for _, h := range hooks {
+				if h.OnLog != nil {
+					h.OnLog(log)
+				}
+			}
+		},
+	}
+}
@@ -0,0 +1,101 @@
+package tracer
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/raul0ligma/smelter/entity"
+)
+
+// ParityStateDiff converts the output of geth's prestateTracer in diff mode into a
+// parity style stateDiff. Accounts only present in post were created by the
+// transaction and accounts only present in pre were destroyed by it.
+func ParityStateDiff(raw json.RawMessage) (entity.StateDiff, error) {
+	var prestate entity.PrestateDiff
+	if err := json.Unmarshal(raw, &prestate); err != nil {
+		return nil, err
+	}
+
+	diff := make(entity.StateDiff)
+	for addr, post := range prestate.Post {
+		pre, existed := prestate.Pre[addr]
+		if !existed {
+			diff[addr] = bornAccount(post)
+			continue
+		}
+
+		diff[addr] = changedAccount(pre, post)
+	}
+
+	for addr, pre := range prestate.Pre {
+		if _, ok := prestate.Post[addr]; ok {
+			continue
+		}
+
+		diff[addr] = diedAccount(pre)
+	}
+
+	return diff, nil
+}
+
+func bornAccount(post *entity.PrestateAccount) *entity.AccountDiff {
+	account := &entity.AccountDiff{
+		Balance: entity.Diff{To: (*hexutil.Big)(post.BalanceOrZero())},
+		Nonce:   entity.Diff{To: hexutil.Uint64(post.Nonce)},
+		Code:    entity.Diff{To: hexutil.Bytes(post.Code)},
+		Storage: make(map[common.Hash]entity.Diff),
+	}
+
+	for slot, value := range post.Storage {
+		account.Storage[slot] = entity.Diff{To: value}
+	}
+
+	return account
+}
+
+func diedAccount(pre *entity.PrestateAccount) *entity.AccountDiff {
+	account := &entity.AccountDiff{
+		Balance: entity.Diff{From: (*hexutil.Big)(pre.BalanceOrZero())},
+		Nonce:   entity.Diff{From: hexutil.Uint64(pre.Nonce)},
+		Code:    entity.Diff{From: hexutil.Bytes(pre.Code)},
+		Storage: make(map[common.Hash]entity.Diff),
+	}
+
+	for slot, value := range pre.Storage {
+		account.Storage[slot] = entity.Diff{From: value}
+	}
+
+	return account
+}
+
+// changedAccount diffs an existing account, post only holds the fields which changed.
+func changedAccount(pre, post *entity.PrestateAccount) *entity.AccountDiff {
+	account := &entity.AccountDiff{
+		Storage: make(map[common.Hash]entity.Diff),
+	}
+
+	if post.Balance != nil && post.Balance.ToInt().Cmp(pre.BalanceOrZero()) != 0 {
+		account.Balance = entity.Diff{From: (*hexutil.Big)(pre.BalanceOrZero()), To: post.Balance}
+	}
+
+	if post.Nonce != 0 && post.Nonce != pre.Nonce {
+		account.Nonce = entity.Diff{From: hexutil.Uint64(pre.Nonce), To: hexutil.Uint64(post.Nonce)}
+	}
+
+	if post.Code != nil && !bytes.Equal(post.Code, pre.Code) {
+		account.Code = entity.Diff{From: hexutil.Bytes(pre.Code), To: post.Code}
+	}
+
+	// slots cleared by the transaction are only present in pre
+	for slot, from := range pre.Storage {
+		account.Storage[slot] = entity.Diff{From: from, To: post.Storage[slot]}
+	}
+
+	for slot, to := range post.Storage {
+		account.Storage[slot] = entity.Diff{From: pre.Storage[slot], To: to}
+	}
+
+	return account
+}
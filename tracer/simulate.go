@@ -0,0 +1,111 @@
+package tracer
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/raul0ligma/smelter/entity"
+)
+
+// SimulateTracer collects the logs of the simulated calls of a block, logs of
+// reverted frames are dropped. With transfer tracing every value transfer is
+// recorded as an ERC20 like Transfer log emitted by the ERC-7528 address.
+type SimulateTracer struct {
+	// logs of every open call frame
+	logs           [][]*types.Log
+	count          uint
+	traceTransfers bool
+	blockNumber    uint64
+	txHash         common.Hash
+	txIndex        uint
+}
+
+func NewSimulateTracer(traceTransfers bool, blockNumber uint64) *SimulateTracer {
+	return &SimulateTracer{
+		traceTransfers: traceTransfers,
+		blockNumber:    blockNumber,
+	}
+}
+
+func (s *SimulateTracer) Hooks() *tracing.Hooks {
+	return &tracing.Hooks{
+		OnEnter: s.onEnter,
+		OnExit:  s.onExit,
+		OnLog:   s.onLog,
+	}
+}
+
+func (s *SimulateTracer) OtterTrace() entity.TransactionTraces {
+	return nil
+}
+
+// Reset prepares the tracer for the next call of the block.
+func (s *SimulateTracer) Reset(txHash common.Hash, txIndex uint) {
+	s.logs = nil
+	s.txHash = txHash
+	s.txIndex = txIndex
+}
+
+// Logs returns the logs of the last call.
+func (s *SimulateTracer) Logs() []*types.Log {
+	if len(s.logs) == 0 {
+		return []*types.Log{}
+	}
+
+	return s.logs[0]
+}
+
+func (s *SimulateTracer) onEnter(depth int, op byte, from, to common.Address, input []byte, gas uint64, value *big.Int) {
+	s.logs = append(s.logs, make([]*types.Log, 0))
+	if s.traceTransfers && vm.OpCode(op) != vm.DELEGATECALL && value != nil && value.Sign() > 0 {
+		s.addLog(entity.NativeAssetAddress, []common.Hash{
+			entity.TransferTopic,
+			common.BytesToHash(from.Bytes()),
+			common.BytesToHash(to.Bytes()),
+		}, common.BigToHash(value).Bytes())
+	}
+}
+
+func (s *SimulateTracer) onExit(depth int, output []byte, gasUsed uint64, err error, reverted bool) {
+	if depth == 0 {
+		if reverted && len(s.logs) > 0 {
+			s.logs[0] = []*types.Log{}
+		}
+		return
+	}
+
+	size := len(s.logs)
+	if size <= 1 {
+		return
+	}
+
+	frame := s.logs[size-1]
+	s.logs = s.logs[:size-1]
+	if !reverted {
+		s.logs[size-2] = append(s.logs[size-2], frame...)
+	}
+}
+
+func (s *SimulateTracer) onLog(log *types.Log) {
+	s.addLog(log.Address, log.Topics, log.Data)
+}
+
+func (s *SimulateTracer) addLog(address common.Address, topics []common.Hash, data []byte) {
+	if len(s.logs) == 0 {
+		return
+	}
+
+	s.logs[len(s.logs)-1] = append(s.logs[len(s.logs)-1], &types.Log{
+		Address:     address,
+		Topics:      topics,
+		Data:        data,
+		BlockNumber: s.blockNumber,
+		TxHash:      s.txHash,
+		TxIndex:     s.txIndex,
+		Index:       s.count,
+	})
+	s.count++
+}
@@ -0,0 +1,52 @@
+package tracer
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/raul0ligma/smelter/entity"
+)
+
+// SloadTracer records the storage slots read by an execution, a slot read several
+// times is kept at the position of its last read. Reads of delegated frames are
+// attributed to the account owning the storage, e.g. the proxy.
+type SloadTracer struct {
+	reads []entity.StorageSlot
+}
+
+func NewSloadTracer() *SloadTracer {
+	return &SloadTracer{}
+}
+
+func (s *SloadTracer) Hooks() *tracing.Hooks {
+	return &tracing.Hooks{
+		OnOpcode: func(pc uint64, op byte, gas, cost uint64, scope tracing.OpContext, rData []byte, depth int, err error) {
+			stack := scope.StackData()
+			if vm.OpCode(op) != vm.SLOAD || err != nil || len(stack) == 0 {
+				return
+			}
+
+			s.add(scope.Address(), common.Hash(stack[len(stack)-1].Bytes32()))
+		},
+	}
+}
+
+func (s *SloadTracer) OtterTrace() entity.TransactionTraces {
+	return nil
+}
+
+// Reads returns the slots in the order of their last read.
+func (s *SloadTracer) Reads() []entity.StorageSlot {
+	return s.reads
+}
+
+func (s *SloadTracer) add(addr common.Address, slot common.Hash) {
+	for i, read := range s.reads {
+		if read.Address == addr && read.Slot == slot {
+			s.reads = append(s.reads[:i], s.reads[i+1:]...)
+			break
+		}
+	}
+
+	s.reads = append(s.reads, entity.StorageSlot{Address: addr, Slot: slot})
+}
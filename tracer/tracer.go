@@ -28,6 +28,9 @@ type LogTracer struct {
 	Logs         []TraceLog
 	logOP        bool
 	currentDepth uint64
+	otter        entity.TransactionTraces
+	// indexes of the otter traces of the frames which haven't exited yet
+	openFrames []int
 }
 
 func (l *LogTracer) Fmt() string {
@@ -45,44 +48,22 @@ func (l *LogTracer) Fmt() string {
 }
 
 func (l *LogTracer) OtterTrace() entity.TransactionTraces {
-	traces := make(entity.TransactionTraces, 0)
-
-	for _, log := range l.Logs {
-		if log.Type == "RETURN" {
-			traces = append(traces, entity.TransactionTrace{
-				Type:   log.Type,
-				Depth:  uint(log.Depth),
-				From:   common.HexToAddress("").Hex(),
-				To:     common.HexToAddress("").Hex(),
-				Value:  "0x00",
-				Input:  "0x",
-				Output: "0x",
-			})
-			continue
-		}
-
-		traces = append(traces, entity.TransactionTrace{
-			Type:   log.Type,
-			Depth:  uint(log.Depth),
-			From:   log.From,
-			To:     log.To,
-			Value:  log.Value,
-			Input:  log.Text,
-			Output: "0x",
-		})
-	}
-
-	return traces
+	return l.otter
 }
 
 func (l *LogTracer) Hooks() *tracing.Hooks {
 	return &tracing.Hooks{
 		OnTxStart: func(vm *tracing.VMContext, tx *types.Transaction, from common.Address) {
+			// contract creations have no recipient
+			to := ""
+			if tx.To() != nil {
+				to = tx.To().Hex()
+			}
 			l.Logs = append(l.Logs, TraceLog{
 				Type:  "TX_START",
 				Depth: l.currentDepth,
 				From:  from.Hex(),
-				To:    tx.To().Hex(),
+				To:    to,
 				Text:  hexutil.Encode(tx.Data()),
 				Value: fmt.Sprintf("%+v", tx.Value()),
 			})
@@ -110,6 +91,18 @@ func (l *LogTracer) Hooks() *tracing.Hooks {
 				Value: utils.Big2Hex(value),
 			})
 			l.currentDepth++
+
+			l.openFrames = append(l.openFrames, len(l.otter))
+			l.otter = append(l.otter, entity.TransactionTrace{
+				Type:   opCodeToString[op],
+				Depth:  uint(depth),
+				From:   from.Hex(),
+				To:     to.Hex(),
+				Value:  utils.Big2Hex(value),
+				Input:  hexutil.Encode(input),
+				Output: "0x",
+				Gas:    hexutil.EncodeUint64(gas),
+			})
 		},
 		OnExit: func(depth int, output []byte, gasUsed uint64, err error, reverted bool) {
 			l.currentDepth--
@@ -122,6 +115,19 @@ func (l *LogTracer) Hooks() *tracing.Hooks {
 				Value: "",
 			})
 
+			if len(l.openFrames) == 0 {
+				return
+			}
+			frame := l.openFrames[len(l.openFrames)-1]
+			l.openFrames = l.openFrames[:len(l.openFrames)-1]
+			l.otter[frame].Output = hexutil.Encode(output)
+			if reverted || err != nil {
+				// every frame entered after this one is nested in it
+				for i := frame; i < len(l.otter); i++ {
+					l.otter[i].Reverted = true
+				}
+			}
+
 		},
 		OnOpcode: func(
 			pc uint64,
@@ -216,5 +222,7 @@ func NewTracer(logOp bool) *LogTracer {
 		Logs:         make([]TraceLog, 0),
 		logOP:        logOp,
 		currentDepth: 0,
+		otter:        make(entity.TransactionTraces, 0),
+		openFrames:   make([]int, 0),
 	}
 }
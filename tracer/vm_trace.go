@@ -0,0 +1,190 @@
+package tracer
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+	"github.com/raul0ligma/smelter/entity"
+)
+
+type vmFrame struct {
+	trace   *entity.VmTrace
+	pending *entity.VmOperation
+	op      vm.OpCode
+	gas     uint64
+	memOff  uint64
+	memSize uint64
+}
+
+// VmTracer builds a parity style vmTrace, an operation only gets its post
+// execution values (ex) once the next operation of the same frame starts.
+type VmTracer struct {
+	root   *entity.VmTrace
+	frames []*vmFrame
+}
+
+func NewVmTracer() *VmTracer {
+	return &VmTracer{
+		frames: make([]*vmFrame, 0),
+	}
+}
+
+func (v *VmTracer) Result() *entity.VmTrace {
+	return v.root
+}
+
+func (v *VmTracer) OtterTrace() entity.TransactionTraces {
+	return entity.TransactionTraces{}
+}
+
+func (v *VmTracer) Hooks() *tracing.Hooks {
+	return &tracing.Hooks{
+		OnEnter: func(depth int, typ byte, from, to common.Address, input []byte, gas uint64, value *big.Int) {
+			trace := &entity.VmTrace{Ops: make([]*entity.VmOperation, 0)}
+			if len(v.frames) == 0 {
+				v.root = trace
+			} else if parent := v.frames[len(v.frames)-1]; parent.pending != nil {
+				parent.pending.Sub = trace
+			}
+
+			v.frames = append(v.frames, &vmFrame{trace: trace})
+		},
+		OnExit: func(depth int, output []byte, gasUsed uint64, err error, reverted bool) {
+			if len(v.frames) == 0 {
+				return
+			}
+
+			frame := v.frames[len(v.frames)-1]
+			if frame.pending != nil {
+				ex := frame.pending.Ex
+				if ex == nil {
+					ex = &entity.VmExecutedOperation{}
+				}
+				ex.Used = frame.gas - min(frame.pending.Cost, frame.gas)
+				ex.Push = make([]*hexutil.Big, 0)
+				frame.pending.Ex = ex
+				frame.pending = nil
+			}
+
+			v.frames = v.frames[:len(v.frames)-1]
+		},
+		OnOpcode: func(
+			pc uint64,
+			op byte,
+			gas, cost uint64,
+			scope tracing.OpContext,
+			rData []byte,
+			depth int,
+			err error,
+		) {
+			if len(v.frames) == 0 {
+				return
+			}
+
+			frame := v.frames[len(v.frames)-1]
+			if frame.trace.Code == nil {
+				frame.trace.Code = scope.ContractCode()
+			}
+
+			v.finalise(frame, gas, scope)
+
+			operation := &entity.VmOperation{
+				Cost: cost,
+				Pc:   pc,
+				Op:   vm.OpCode(op).String(),
+			}
+			frame.trace.Ops = append(frame.trace.Ops, operation)
+			frame.pending = operation
+			frame.op = vm.OpCode(op)
+			frame.gas = gas
+			frame.memOff, frame.memSize = memoryWrite(frame.op, scope.StackData())
+
+			stack := scope.StackData()
+			if frame.op == vm.SSTORE && len(stack) >= 2 {
+				operation.Ex = &entity.VmExecutedOperation{
+					Store: &entity.VmStoreDiff{
+						Key: (*hexutil.Big)(stack[len(stack)-1].ToBig()),
+						Val: (*hexutil.Big)(stack[len(stack)-2].ToBig()),
+					},
+				}
+			}
+		},
+	}
+}
+
+func (v *VmTracer) finalise(frame *vmFrame, gasLeft uint64, scope tracing.OpContext) {
+	if frame.pending == nil {
+		return
+	}
+
+	ex := frame.pending.Ex
+	if ex == nil {
+		ex = &entity.VmExecutedOperation{}
+	}
+
+	ex.Used = gasLeft
+	ex.Push = make([]*hexutil.Big, 0)
+	stack := scope.StackData()
+	for i := min(stackOutputs(frame.op), len(stack)); i > 0; i-- {
+		ex.Push = append(ex.Push, (*hexutil.Big)(stack[len(stack)-i].ToBig()))
+	}
+
+	memory := scope.MemoryData()
+	if frame.memSize > 0 && frame.memOff+frame.memSize <= uint64(len(memory)) {
+		data := make([]byte, frame.memSize)
+		copy(data, memory[frame.memOff:frame.memOff+frame.memSize])
+		ex.Mem = &entity.VmMemoryDiff{Off: frame.memOff, Data: data}
+	}
+
+	frame.pending.Ex = ex
+	frame.pending = nil
+}
+
+// memoryWrite returns the memory region an opcode writes to, read off the stack before it runs.
+func memoryWrite(op vm.OpCode, stack []uint256.Int) (uint64, uint64) {
+	peek := func(n int) uint64 {
+		if len(stack) < n {
+			return 0
+		}
+		return stack[len(stack)-n].Uint64()
+	}
+
+	switch op {
+	case vm.MSTORE:
+		return peek(1), 32
+	case vm.MSTORE8:
+		return peek(1), 1
+	case vm.CALLDATACOPY, vm.CODECOPY, vm.RETURNDATACOPY, vm.MCOPY:
+		return peek(1), peek(3)
+	case vm.EXTCODECOPY:
+		return peek(2), peek(4)
+	default:
+		return 0, 0
+	}
+}
+
+// stackOutputs returns how many stack items an opcode leaves behind, DUP and SWAP
+// report every item they touched like parity does.
+func stackOutputs(op vm.OpCode) int {
+	switch {
+	case op >= vm.DUP1 && op <= vm.DUP16:
+		return int(op-vm.DUP1) + 2
+	case op >= vm.SWAP1 && op <= vm.SWAP16:
+		return int(op-vm.SWAP1) + 2
+	case op >= vm.LOG0 && op <= vm.LOG4:
+		return 0
+	}
+
+	switch op {
+	case vm.STOP, vm.POP, vm.MSTORE, vm.MSTORE8, vm.SSTORE, vm.TSTORE, vm.JUMP, vm.JUMPI, vm.JUMPDEST,
+		vm.RETURN, vm.REVERT, vm.INVALID, vm.SELFDESTRUCT, vm.CALLDATACOPY, vm.CODECOPY,
+		vm.EXTCODECOPY, vm.RETURNDATACOPY, vm.MCOPY:
+		return 0
+	default:
+		return 1
+	}
+}
@@ -2,7 +2,6 @@ package utils
 
 import (
 	"fmt"
-	"math/big"
 )
 
 func PrintSmelter() {
@@ -15,12 +14,13 @@ func PrintSmelter() {
 	╚══════╝╚═╝     ╚═╝╚══════╝╚══════╝╚═╝   ╚══════╝╚═╝  ╚═╝`)
 }
 
-func PrintConfig(rpcURL string, chainID *big.Int, forkBlock uint64) {
+func PrintConfig(name string, rpcURL string, chainID uint64, forkBlock uint64) {
 	fmt.Println(fmt.Sprintf(`
 	============================================================
+	CHAIN		%s
 	RPC_URL		%s
 	CHAIN_ID	%d
 	FORK_BLOCK	%d
 	============================================================
-`, rpcURL, chainID.Uint64(), forkBlock))
+`, name, rpcURL, chainID, forkBlock))
 }